@@ -0,0 +1,772 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/source"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// subdomainPattern Matches the DNS labels of a subdomain, e.g. team.prod
+var subdomainPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// broadcastOptions The flags of the broadcast command
+type broadcastOptions struct {
+	config                   string
+	interfaces               interfaceOptions
+	responder                responderOptions
+	reflector                reflectorOptions
+	domains                  domainOptions
+	kube                     kubeOptions
+	publishing               publisherOptions
+	llmnr                    bool
+	ssdp                     bool
+	wsDiscovery              bool
+	dnsListen                string
+	dnsZone                  string
+	hostsFile                string
+	dnsmasqPidFile           string
+	corednsConfigMap         string
+	piholeURL                string
+	piholeTokenFile          string
+	gatewayAPI               bool
+	services                 bool
+	headlessServices         bool
+	pods                     bool
+	nodes                    bool
+	nodeSelector             string
+	openshiftRoutes          bool
+	knative                  bool
+	knativeIngressService    string
+	mdnsEntries              bool
+	staticEntriesConfigMap   string
+	staticEntriesFile        string
+	namespaces               []string
+	excludedNamespaces       []string
+	ingressSelector          string
+	ingressControllerPods    string
+	ingressControllerService string
+	withdrawWithoutEndpoints bool
+	allowHostnames           []string
+	denyHostnames            []string
+	instancePerPath          bool
+	instanceTemplate         string
+	namespaceSubdomains      bool
+	namespaceSubdomain       []string
+	collisionPolicy          string
+	httpCheck                bool
+	httpCheckTimeout         uint
+	leaderElect              bool
+	leaderElectionLease      string
+	shardByNode              string
+	shardNodeSelector        string
+	writeStatus              bool
+	stateFile                string
+	healthListen             string
+	metricsListen            string
+	adminListen              string
+	tlsHTTPServiceType       bool
+	srvPriority              uint16
+	srvWeight                uint16
+	ipFamily                 string
+	ingressAPI               string
+}
+
+func newBroadcastCommand() *cobra.Command {
+	options := &broadcastOptions{}
+	cmd := &cobra.Command{
+		Use:   "broadcast",
+		Short: "Broadcast the hostnames of ingresses and the other watched objects",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var flagsFile *configFile
+			if options.config != "" {
+				flagsFile = newConfigFile(options.config, cmd.Flags())
+				if err := flagsFile.load(); err != nil {
+					log.Fatalf("Loading config file: %+v", err)
+				}
+			}
+			ctx, forced := withTermination(time.Second * time.Duration(options.publishing.shutdownTimeout))
+			if err := runBroadcast(ctx, forced, options, flagsFile); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.SortFlags = false
+	flags.StringVar(&options.config, "config", "", "YAML file at `path` mapping the names of these flags to their values, lists for repeated flags. Flags given on the command line or through the environment take precedence, on SIGHUP the file is read again")
+	options.interfaces.addFlags(flags)
+	options.publishing.addPublisherFlags(flags)
+	flags.BoolVar(&options.llmnr, "llmnr", false, "Also answer LLMNR queries for the hostnames, for Windows clients without mDNS support")
+	flags.BoolVar(&options.ssdp, "ssdp", false, "Also advertise ingresses with a zeroconf.ingress/ssdp-description annotation over SSDP, for smart TVs and DLNA apps")
+	flags.BoolVar(&options.wsDiscovery, "ws-discovery", false, "Also advertise ingresses with a zeroconf.ingress/ws-discovery annotation over WS-Discovery, for Windows and ONVIF clients")
+	flags.StringVar(&options.dnsListen, "dns-listen", "", "Also serve the records as an authoritative unicast DNS server on the `address`, e.g. :53, for clients without mDNS")
+	flags.StringVar(&options.dnsZone, "dns-zone", "", "`zone` served by --dns-listen, e.g. k8s.home.arpa, which routers can delegate to this server, defaults to --domain")
+	flags.StringVar(&options.hostsFile, "hosts-file", "", "Also write the published hostnames to an /etc/hosts style file at `path`, e.g. for the addn-hosts option of dnsmasq")
+	flags.StringVar(&options.dnsmasqPidFile, "dnsmasq-pid-file", "", "Send SIGHUP to the dnsmasq of the pid file at `path` whenever the hosts file changed, which makes it re-read the file")
+	flags.StringVar(&options.corednsConfigMap, "coredns-configmap", "", "Also keep the published hostnames in the zeroconf.hosts key of the ConfigMap `namespace/name`, for the hosts plugin of CoreDNS")
+	flags.StringVar(&options.piholeURL, "pihole-url", "", "Also add the published hostnames as local DNS records of the Pi-hole with the admin interface at the `url`, e.g. http://pi.hole")
+	flags.StringVar(&options.piholeTokenFile, "pihole-token-file", "", "File at `path` holding the API token of the Pi-hole")
+	options.kube.addFlags(flags)
+	flags.BoolVar(&options.gatewayAPI, "gateway-api", false, "Also broadcast hostnames of Gateway API HTTPRoutes")
+	flags.BoolVar(&options.services, "services", false, `Also broadcast LoadBalancer services annotated with zeroconf.ingress/publish: "true" and services of any type annotated with zeroconf.ingress/hostname`)
+	flags.BoolVar(&options.headlessServices, "headless-services", false, `Also broadcast every ready pod of headless services annotated with zeroconf.ingress/per-pod: "true" as <pod>.<service>.local, with the IP of its node`)
+	flags.BoolVar(&options.pods, "pods", false, "Also broadcast the hostnames of pods on the host network annotated with zeroconf.ingress/hostname, with the IP of their node")
+	flags.BoolVar(&options.nodes, "nodes", false, "Also broadcast every node as <node>.local with its InternalIP, as an _ssh._tcp instance on port 22 unless annotated otherwise")
+	flags.StringVar(&options.nodeSelector, "node-selector", "", "Only broadcast the nodes matching the label `selector` with --nodes")
+	flags.BoolVar(&options.openshiftRoutes, "openshift-routes", false, "Also broadcast hostnames of OpenShift route.openshift.io/v1 Routes")
+	flags.BoolVar(&options.knative, "knative", false, "Also broadcast domains of Knative Routes and DomainMappings")
+	flags.StringVar(&options.knativeIngressService, "knative-ingress-service", "kourier-system/kourier", "LoadBalancer service `namespace/name` of the Knative networking layer")
+	flags.BoolVar(&options.mdnsEntries, "mdns-entries", false, "Also broadcast zeroconf.ingress/v1alpha1 MDNSEntry resources")
+	flags.StringVar(&options.staticEntriesConfigMap, "static-entries-configmap", "", "Also broadcast the entries of the ConfigMap `namespace/name` mapping hostnames to ip or ip:port")
+	flags.StringVar(&options.staticEntriesFile, "static-entries-file", "", "Also broadcast the entries of the YAML file at `path` mapping hostnames to ip or ip:port, which is read again on SIGHUP")
+	flags.StringArrayVar(&options.namespaces, "namespace", nil, "Only watch the given `namespace`, may be repeated")
+	flags.StringArrayVar(&options.excludedNamespaces, "exclude-namespace", nil, "Do not watch the given `namespace`, may be repeated")
+	flags.StringVar(&options.ingressSelector, "ingress-selector", "", "Only broadcast ingresses matching the label `selector`, e.g. app=public")
+	flags.StringVar(&options.ingressControllerPods, "ingress-controller-pods", "", "Advertise the node IPs of the pods matching the label `selector` for ingresses without a LoadBalancer address, e.g. app.kubernetes.io/name=ingress-nginx")
+	flags.StringVar(&options.ingressControllerService, "ingress-controller-service", "", "Advertise the NodePorts of the http and https ports of the ingress controller service `namespace/name` instead of 80/443")
+	flags.BoolVar(&options.withdrawWithoutEndpoints, "withdraw-without-endpoints", false, "Withdraw the hostnames of ingress rules whose backend services have no ready endpoints, until one becomes ready")
+	flags.StringArrayVar(&options.allowHostnames, "allow-hostnames", nil, "Only broadcast hostnames matching one of the given `regex`es, e.g. '\\.local$', may be repeated")
+	flags.StringArrayVar(&options.denyHostnames, "deny-hostnames", nil, "Never broadcast hostnames matching one of the given `regex`es, may be repeated")
+	flags.BoolVar(&options.instancePerPath, "instance-per-path", false, `Publish every path of an ingress rule as its own DNS-SD instance, e.g. /grafana under host as "grafana (host)"`)
+	flags.StringVar(&options.instanceTemplate, "instance-name-template", "", "Go `template` naming the DNS-SD instances of hostnames after their object instead of the hostname, e.g. '{{ .Namespace }}-{{ .Name }}', with .Kind, .Namespace, .Name, .Labels, .Annotations, .Hostname and .Instance")
+	flags.BoolVar(&options.namespaceSubdomains, "namespace-subdomains", false, "Publish the hostnames of namespaced objects under a subdomain named after their namespace, e.g. grafana.monitoring.local")
+	flags.StringArrayVar(&options.namespaceSubdomain, "namespace-subdomain", nil, "Publish the hostnames of a namespace under another subdomain, as `namespace=subdomain`, e.g. monitoring=mon, an empty subdomain publishes them unchanged, may be repeated")
+	flags.StringVar(&options.collisionPolicy, "collision-policy", publisher.CollisionFirst, "How to handle owners publishing the same hostname differently: first keeps the first, last publishes the latest, qualify publishes others as <hostname>.<namespace>")
+	flags.BoolVar(&options.httpCheck, "http-check", false, "Only publish HTTP and HTTPS hostnames once a GET of their path from their addresses, with the hostname as Host header and SNI, neither fails nor returns 404 or a server error, retrying until then")
+	flags.UintVar(&options.httpCheckTimeout, "http-check-timeout", 2, "Give up on an address of --http-check after this many `seconds`")
+	flags.BoolVar(&options.leaderElect, "leader-elect", false, "Only publish on the replica holding the lease, the others list the objects and take over when the leader fails")
+	flags.StringVar(&options.leaderElectionLease, "leader-election-lease", "kube-system/ingress-frontend-zeroconf", "Lease `namespace/name` competed for with --leader-elect")
+	flags.StringVar(&options.shardByNode, "shard-by-node", "", "Run as a DaemonSet replica on the `node`, which only announces the hostnames it owns among the ready nodes, e.g. $(NODE_NAME)")
+	flags.StringVar(&options.shardNodeSelector, "shard-node-selector", "", "Only shard between the nodes matching the label `selector`, that of the DaemonSet")
+	flags.BoolVar(&options.writeStatus, "write-status", false, "Write the hostnames published for each ingress and their addresses to its zeroconf.ingress/status annotation")
+	flags.StringVar(&options.stateFile, "state-file", "", "Persist the published records to the file at `path`, to withdraw records left over by a crash after restarting")
+	flags.StringVar(&options.healthListen, "health-listen", "", "Serve /healthz and /readyz for liveness and readiness probes on the `address`, e.g. :8081")
+	flags.StringVar(&options.metricsListen, "metrics-listen", "", "Serve the controller metrics for Prometheus on /metrics at the `address`, e.g. :8080")
+	flags.StringVar(&options.adminListen, "admin-listen", "", "Serve the current registrations as JSON on /registrations at the `address`, e.g. 127.0.0.1:8082, which list --server reads")
+	flags.BoolVar(&options.tlsHTTPServiceType, "tls-http-service-type", false, "Publish TLS hosts under _http._tcp as well as _https._tcp")
+	flags.Uint16Var(&options.srvPriority, "srv-priority", 0, "Default SRV priority of published records")
+	flags.Uint16Var(&options.srvWeight, "srv-weight", 0, "Default SRV weight of published records")
+	flags.StringVar(&options.ipFamily, "ip-family", hostname.IPFamilyAny, "Advertise only the ipv4 or ipv6 LoadBalancer addresses, or the addresses of both families with any")
+	options.responder.addFlags(flags)
+	options.reflector.addFlags(flags)
+	options.domains.addFlags(flags)
+	flags.StringVar(&options.ingressAPI, "ingress-api", source.IngressAPIAuto, "Ingress API `version` to watch, one of networking.k8s.io/v1, networking.k8s.io/v1beta1, extensions/v1beta1 or auto to pick the newest version served by the cluster")
+	return cmd
+}
+
+// withTermination Returns a context that is cancelled on the first SIGINT or SIGTERM and a
+// channel that is closed on the second one. Once cancelled the process exits after timeout
+// at the latest
+func withTermination(timeout time.Duration) (context.Context, <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	forced := make(chan struct{})
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Infof("Received %v", sig)
+		cancel()
+		if timeout > 0 {
+			time.AfterFunc(timeout, func() {
+				log.Errorf("Shutting down took longer than %v, exiting", timeout)
+				os.Exit(1)
+			})
+		}
+		sig = <-sigs
+		log.Infof("Received %v again", sig)
+		close(forced)
+	}()
+	return ctx, forced
+}
+
+// newPublisher Starts the publisher named publisherName on the interfaces that are up, the
+// responder is only returned for the mdns publisher, which can re-announce
+func newPublisher(publisherName string, broadcastInterfaces []net.Interface, mdnsOptions publisher.MDNSOptions) (publisher.Publisher, *publisher.MDNSResponder, error) {
+	var backend publisher.Publisher
+	var responder *publisher.MDNSResponder
+	var err error
+	switch publisherName {
+	case publisher.PublisherMDNS:
+		responder, err = publisher.NewMDNSResponder(publisher.UpInterfaces(broadcastInterfaces), mdnsOptions)
+		backend = responder
+	case publisher.PublisherAvahi:
+		backend, err = publisher.NewAvahiPublisher(publisher.UpInterfaces(broadcastInterfaces))
+	case publisher.PublisherResolved:
+		backend, err = publisher.NewResolvedPublisher()
+	default:
+		return nil, nil, fmt.Errorf("Unsupported publisher %v, expected one of %v, %v, %v", publisherName, publisher.PublisherMDNS, publisher.PublisherAvahi, publisher.PublisherResolved)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Starting %v publisher: %+v", publisherName, err)
+	}
+	return backend, responder, nil
+}
+
+// registrySettings The settings of the registry a reload can change
+type registrySettings struct {
+	defaultPriority uint16
+	defaultWeight   uint16
+	recordTTL       uint32
+	collisionPolicy string
+	probePolicy     string
+	allowHostnames  []*regexp.Regexp
+	denyHostnames   []*regexp.Regexp
+}
+
+// registrySettings Returns the registry settings of the flags, or an error when they are invalid
+func (o *broadcastOptions) registrySettings() (registrySettings, error) {
+	settings := registrySettings{
+		defaultPriority: o.srvPriority,
+		defaultWeight:   o.srvWeight,
+		recordTTL:       uint32(o.publishing.recordTTL),
+		collisionPolicy: o.collisionPolicy,
+		probePolicy:     o.publishing.probe,
+	}
+	if settings.collisionPolicy != publisher.CollisionFirst && settings.collisionPolicy != publisher.CollisionLast && settings.collisionPolicy != publisher.CollisionQualify {
+		return settings, fmt.Errorf("Unsupported collision policy %v, expected one of %v, %v, %v", settings.collisionPolicy, publisher.CollisionFirst, publisher.CollisionLast, publisher.CollisionQualify)
+	}
+	if err := o.publishing.validate(); err != nil {
+		return settings, err
+	}
+	var err error
+	if settings.allowHostnames, err = compileRegexps(o.allowHostnames); err != nil {
+		return settings, fmt.Errorf("parsing allow-hostnames: %+v", err)
+	}
+	if settings.denyHostnames, err = compileRegexps(o.denyHostnames); err != nil {
+		return settings, fmt.Errorf("parsing deny-hostnames: %+v", err)
+	}
+	return settings, nil
+}
+
+// apply Sets the settings on registry
+func (s registrySettings) apply(registry *publisher.Registry) {
+	registry.DefaultPriority = s.defaultPriority
+	registry.DefaultWeight = s.defaultWeight
+	registry.RecordTTL = s.recordTTL
+	registry.CollisionPolicy = s.collisionPolicy
+	registry.ProbePolicy = s.probePolicy
+	registry.AllowHostnames = s.allowHostnames
+	registry.DenyHostnames = s.denyHostnames
+}
+
+// reload Re-reads the config file and the static entries file, re-registering only what changed
+func (o *broadcastOptions) reload(flagsFile *configFile, registry *publisher.Registry, staticEntries *source.StaticEntriesFile) {
+	if flagsFile != nil {
+		if err := flagsFile.reload(); err != nil {
+			log.Errorf("Failed to reload config file, keeping the current settings: %+v", err)
+		} else if settings, err := o.registrySettings(); err != nil {
+			log.Errorf("Failed to reload config file, keeping the current settings: %+v", err)
+		} else {
+			registry.Reconfigure(settings.apply)
+		}
+	}
+	if staticEntries != nil {
+		if err := staticEntries.Load(); err != nil {
+			log.Errorf("Failed to reload static entries file, keeping the current entries: %+v", err)
+		}
+	}
+}
+
+// hostnameOptions Returns how the sources map the hostnames of objects, errors for invalid flags
+func (o *broadcastOptions) hostnameOptions() (source.HostnameOptions, error) {
+	domains, err := o.domains.options()
+	if err != nil {
+		return source.HostnameOptions{}, fmt.Errorf("Setting up domains: %+v", err)
+	}
+	hostnames := source.HostnameOptions{
+		Options:              domains,
+		InstancePerPath:      o.instancePerPath,
+		NamespaceSubdomains:  o.namespaceSubdomains,
+		SubdomainOfNamespace: map[string]string{},
+	}
+	if o.instanceTemplate != "" {
+		if hostnames.InstanceTemplate, err = template.New("instance").Option("missingkey=zero").Parse(o.instanceTemplate); err != nil {
+			return hostnames, fmt.Errorf("Parsing instance name template: %+v", err)
+		}
+	}
+	for _, mapping := range o.namespaceSubdomain {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || (parts[1] != "" && !subdomainPattern.MatchString(parts[1])) {
+			return hostnames, fmt.Errorf("Namespace subdomain %v is not of the form namespace=subdomain", mapping)
+		}
+		hostnames.SubdomainOfNamespace[parts[0]] = parts[1]
+	}
+	hostnames.IPFamily = o.ipFamily
+	if hostnames.IPFamily != hostname.IPFamilyAny && hostnames.IPFamily != hostname.IPFamilyIPv4 && hostnames.IPFamily != hostname.IPFamilyIPv6 {
+		return hostnames, fmt.Errorf("Unsupported ip family %v, expected one of %v, %v, %v", hostnames.IPFamily, hostname.IPFamilyAny, hostname.IPFamilyIPv4, hostname.IPFamilyIPv6)
+	}
+	return hostnames, nil
+}
+
+// runBroadcast Publishes the hostnames until ctx is done or a controller manager stopped,
+// flagsFile is the config file when there is one. Once it is done the watches are stopped,
+// goodbyes are sent and the publishers are closed, skipping the drain period when forced is
+// closed. Returns an error when setting up failed or a manager stopped
+func runBroadcast(ctx context.Context, forced <-chan struct{}, options *broadcastOptions, flagsFile *configFile) error {
+	hostnames, err := options.hostnameOptions()
+	if err != nil {
+		return err
+	}
+
+	interfaces, broadcastInterfaces, err := options.interfaces.selection()
+	if err != nil {
+		return fmt.Errorf("Selecting interfaces: %+v", err)
+	}
+	for _, broadcastInterface := range broadcastInterfaces {
+		if hostnames.IPFamily != hostname.IPFamilyIPv4 && !publisher.HasIPv6Address(broadcastInterface) {
+			log.Warnf("Interface %v has no IPv6 address, AAAA records cannot be sent to IPv6 only clients over ff02::fb", broadcastInterface.Name)
+		}
+	}
+
+	clusters, err := newClusters(&options.kube)
+	if err != nil {
+		return err
+	}
+	if len(clusters) > 1 && (options.leaderElect || options.shardByNode != "" || options.writeStatus) {
+		return errors.New("--leader-elect, --shard-by-node and --write-status cannot be combined with several contexts")
+	}
+	clientset, dynamicClient := clusters[0].clientset, clusters[0].dynamicClient
+
+	ingressSelector := labels.Everything()
+	if options.ingressSelector != "" {
+		if ingressSelector, err = labels.Parse(options.ingressSelector); err != nil {
+			return fmt.Errorf("Parsing ingress selector: %+v", err)
+		}
+	}
+
+	scope := source.NamespaceScope{
+		Namespaces: options.namespaces,
+		Excluded:   options.excludedNamespaces,
+	}
+	lease := ""
+	if options.leaderElect {
+		lease = options.leaderElectionLease
+	}
+
+	backend, responder, err := newPublisher(options.publishing.publisher, broadcastInterfaces, options.responder.options())
+	if err != nil {
+		return err
+	}
+	// Closes the publishers added up to a failure too
+	defer func() {
+		backend.Close()
+	}()
+	reflector, err := options.reflector.start(responder)
+	if err != nil {
+		return fmt.Errorf("Starting mDNS reflector: %+v", err)
+	}
+	if reflector != nil {
+		defer reflector.Close()
+	}
+	if options.llmnr {
+		llmnrResponder, err := publisher.NewLLMNRResponder(publisher.UpInterfaces(broadcastInterfaces))
+		if err != nil {
+			return fmt.Errorf("Starting LLMNR responder: %+v", err)
+		}
+		backend = publisher.Publishers{backend, llmnrResponder}
+	}
+	if options.ssdp {
+		ssdpAnnouncer, err := publisher.NewSSDPAnnouncer(publisher.UpInterfaces(broadcastInterfaces))
+		if err != nil {
+			return fmt.Errorf("Starting SSDP announcer: %+v", err)
+		}
+		backend = publisher.Publishers{backend, ssdpAnnouncer}
+	}
+	if options.wsDiscovery {
+		wsdAnnouncer, err := publisher.NewWSDAnnouncer(publisher.UpInterfaces(broadcastInterfaces))
+		if err != nil {
+			return fmt.Errorf("Starting WS-Discovery announcer: %+v", err)
+		}
+		backend = publisher.Publishers{backend, wsdAnnouncer}
+	}
+	if options.dnsListen != "" {
+		dnsZone := options.dnsZone
+		if dnsZone == "" {
+			dnsZone = hostnames.Domain
+		}
+		server, err := publisher.NewDNSServer(options.dnsListen, dnsZone)
+		if err != nil {
+			return fmt.Errorf("Starting DNS server: %+v", err)
+		}
+		backend = publisher.Publishers{backend, server}
+	}
+	if options.hostsFile != "" {
+		backend = publisher.Publishers{backend, publisher.NewHostsFilePublisher(options.hostsFile, options.dnsmasqPidFile)}
+	}
+	if options.corednsConfigMap != "" {
+		corednsPublisher, err := publisher.NewCorednsPublisher(clientset, options.corednsConfigMap)
+		if err != nil {
+			return fmt.Errorf("Setting up CoreDNS configmap: %+v", err)
+		}
+		backend = publisher.Publishers{backend, corednsPublisher}
+	}
+	if options.piholeURL != "" {
+		piholePublisher, err := publisher.NewPiholePublisher(options.piholeURL, options.piholeTokenFile)
+		if err != nil {
+			return fmt.Errorf("Setting up Pi-hole: %+v", err)
+		}
+		backend = publisher.Publishers{backend, piholePublisher}
+	}
+	registry := publisher.NewRegistry(broadcastInterfaces, backend)
+	registry.Domain = hostnames.Domain
+	if responder != nil {
+		registry.Prober = responder.Prober()
+	}
+	settings, err := options.registrySettings()
+	if err != nil {
+		return err
+	}
+	settings.apply(registry)
+	registry.TLSHTTPServiceType = options.tlsHTTPServiceType
+	if len(clusters) == 1 {
+		registry.Recorder = publisher.NewEventRecorder(clientset)
+	} else {
+		log.Infof("Not recording Events, the objects owning hostnames are spread over %v clusters", len(clusters))
+	}
+	if options.httpCheck {
+		registry.HTTPCheck = publisher.NewHTTPCheck(time.Second * time.Duration(options.httpCheckTimeout))
+	}
+	if options.writeStatus {
+		registry.Status = publisher.NewIngressStatusWriter(dynamicClient)
+		defer registry.Status.Close()
+	}
+	stateFile := options.stateFile
+	var previousState []publisher.ServiceInstance
+	if stateFile != "" {
+		if previousState, err = publisher.LoadState(stateFile); err != nil {
+			log.Warnf("Ignoring unreadable state file %v: %+v", stateFile, err)
+		}
+	}
+
+	var staticEntries *source.StaticEntriesFile
+	if options.staticEntriesFile != "" {
+		log.Debugf("Reading static entries file %v", options.staticEntriesFile)
+		staticEntries = source.NewStaticEntriesFile(options.staticEntriesFile, hostnames, registry)
+		if err := staticEntries.Load(); err != nil {
+			return fmt.Errorf("Reading static entries file: %+v", err)
+		}
+	}
+
+	allSources := []*source.Manager{}
+	for i, cluster := range clusters {
+		managerOptions := source.ManagerOptions{
+			Scope:     scope,
+			Hostnames: hostnames,
+			Lease:     lease,
+			Cluster:   cluster.name,
+		}
+		if i == 0 {
+			// The probe and metrics endpoints are served by the manager of the first cluster
+			managerOptions.HealthListen, managerOptions.MetricsListen = options.healthListen, options.metricsListen
+		}
+		sources, err := options.watchCluster(cluster, registry, ingressSelector, managerOptions)
+		if err != nil {
+			if cluster.name == "" {
+				return err
+			}
+			return fmt.Errorf("Watching context %v: %+v", cluster.name, err)
+		}
+		allSources = append(allSources, sources)
+	}
+
+	reannounceInterval := time.Second * time.Duration(options.publishing.reannounceInterval)
+	drainPeriod := time.Second * time.Duration(options.publishing.drainPeriod)
+
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	dumps := make(chan os.Signal, 1)
+	signal.Notify(dumps, syscall.SIGUSR1)
+	defer signal.Stop(reloads)
+	defer signal.Stop(dumps)
+
+	if options.healthListen != "" {
+		if err := source.AddHealthChecks(allSources, registry); err != nil {
+			return fmt.Errorf("Setting up health checks: %+v", err)
+		}
+	}
+	if options.adminListen != "" {
+		if err := source.AddAdminEndpoint(allSources[0], registry, options.adminListen); err != nil {
+			return fmt.Errorf("Setting up admin endpoint: %+v", err)
+		}
+	}
+	// sourcesCtx Stops the managers with their watches and HTTP servers, the background loops
+	// stop with them
+	sourcesCtx, stopSources := context.WithCancel(ctx)
+	defer stopSources()
+	stopped := make(chan error, len(allSources))
+	for _, sources := range allSources {
+		go func(sources *source.Manager) {
+			stopped <- sources.Run(sourcesCtx)
+		}(sources)
+	}
+	ready := allReady(sourcesCtx, allSources)
+	go publisher.WatchInterfaces(sourcesCtx, interfaces, broadcastInterfaces, registry)
+	go notifySystemd(sourcesCtx, ready, registry)
+	if reannounceInterval > 0 && responder != nil {
+		go responder.ReannounceEvery(sourcesCtx, reannounceInterval)
+	}
+	if stateFile != "" {
+		go func() {
+			// Only what is published once all watches listed their objects is current
+			select {
+			case <-sourcesCtx.Done():
+				return
+			case <-ready:
+			}
+			select {
+			case <-sourcesCtx.Done():
+			case <-allSources[0].Elected():
+				registry.WithdrawStale(stateFile, previousState)
+			}
+		}()
+	}
+
+	var result error
+wait:
+	for {
+		select {
+		case <-reloads:
+			log.Infof("Received SIGHUP, reloading")
+			sdNotify("RELOADING=1")
+			options.reload(flagsFile, registry, staticEntries)
+			select {
+			case <-ready:
+				sdNotify("READY=1")
+			default:
+				// notifySystemd sends it once the sources are ready
+			}
+		case <-dumps:
+			dumpState(registry, allSources)
+		case <-ctx.Done():
+			log.Infof("Shutting down, sending goodbye packets")
+			break wait
+		case err := <-stopped:
+			// The manager also stops when the leadership was lost, the restarted process stands by
+			log.Errorf("Controller manager stopped, sending goodbye packets: %+v", err)
+			result = fmt.Errorf("controller manager stopped: %+v", err)
+			break wait
+		}
+	}
+	// No events are registered from here on, the leader releases its lease once the
+	// manager stopped
+	sdNotify("STOPPING=1")
+	stopSources()
+	registry.UnregisterAll()
+	if result == nil {
+		log.Debugf("Waiting for the controller managers to stop")
+		for range allSources {
+			if err := <-stopped; err != nil {
+				log.Warnf("Controller manager stopped with an error: %+v", err)
+			}
+		}
+	}
+	if drainPeriod > 0 {
+		log.Infof("Waiting %v for caches to drain", drainPeriod)
+		select {
+		case <-time.After(drainPeriod):
+		case <-forced:
+			log.Infof("Exiting without draining")
+		}
+	}
+	return result
+}
+
+// allReady Returns a channel closed once the watches of all managers listed their objects,
+// never when ctx is done before
+func allReady(ctx context.Context, allSources []*source.Manager) <-chan struct{} {
+	if len(allSources) == 1 {
+		return allSources[0].Ready()
+	}
+	ready := make(chan struct{})
+	go func() {
+		for _, sources := range allSources {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sources.Ready():
+			}
+		}
+		close(ready)
+	}()
+	return ready
+}
+
+// watchCluster Sets up a manager watching the sources enabled by the flags in cluster, which
+// register their hostnames in registry
+func (o *broadcastOptions) watchCluster(cluster *kubeCluster, registry *publisher.Registry, ingressSelector labels.Selector, managerOptions source.ManagerOptions) (*source.Manager, error) {
+	ingressAPI := o.ingressAPI
+	if ingressAPI == source.IngressAPIAuto {
+		var err error
+		ingressAPI, err = source.DetectServedVersion(cluster.clientset, source.IngressAPIPreference, "ingresses")
+		if err != nil {
+			return nil, fmt.Errorf("Detecting ingress API version: %+v", err)
+		}
+		log.Infof("Detected ingress API version %v", ingressAPI)
+	}
+	ingressObject, toIngress, err := source.GetIngressSource(ingressAPI)
+	if err != nil {
+		return nil, fmt.Errorf("Setting up ingress watch: %+v", err)
+	}
+
+	if !ingressSelector.Empty() {
+		managerOptions.Selectors = append(managerOptions.Selectors, source.ObjectSelector{Object: ingressObject, Labels: ingressSelector})
+	}
+	sources, err := source.NewManager(cluster.config, cluster.clientset, managerOptions)
+	if err != nil {
+		return nil, fmt.Errorf("Setting up controller manager: %+v", err)
+	}
+
+	log.Debugf("Watching %v ingresses in %v", ingressAPI, managerOptions.Scope)
+	if shardNode := o.shardByNode; shardNode != "" {
+		if managerOptions.Lease != "" || registry.Status != nil {
+			return nil, errors.New("--shard-by-node cannot be combined with --leader-elect or --write-status")
+		}
+		selector, err := labels.Parse(o.shardNodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing shard node selector: %+v", err)
+		}
+		log.Debugf("Sharding hostnames between the nodes %v as %v", selector, shardNode)
+		source.NewNodeShards(sources, shardNode, selector.String(), registry)
+	}
+	var nodeIPs *source.NodeIPSource
+	if o.ingressControllerPods != "" {
+		selector, err := labels.Parse(o.ingressControllerPods)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing ingress controller pod selector: %+v", err)
+		}
+		log.Debugf("Falling back to the node IPs of ingress controller pods %v", selector)
+		nodeIPs = source.NewNodeIPSource(sources, selector.String())
+	}
+	var nodePorts *source.NodePortSource
+	if o.ingressControllerService != "" {
+		log.Debugf("Advertising the NodePorts of ingress controller service %v", o.ingressControllerService)
+		if nodePorts, err = source.NewNodePortSource(sources, o.ingressControllerService); err != nil {
+			return nil, fmt.Errorf("Setting up ingress controller service watch: %+v", err)
+		}
+	}
+	var backends *source.BackendSource
+	err = sources.WatchHostnames("ingress", ingressObject, registry, func(obj interface{}) ([]hostname.LocalHostname, []net.IP) {
+		ingress := toIngress(obj)
+		hostnames, ips := source.GetIngressHostnames(managerOptions.Hostnames, ingress)
+		if backends != nil {
+			hostnames = backends.Filter(ingress, hostnames)
+		}
+		if len(hostnames) > 0 && len(ips) == 0 && nodeIPs != nil {
+			ips = nodeIPs.IPs()
+		}
+		if nodePorts != nil {
+			httpPort, httpsPort := nodePorts.Ports()
+			for i := range hostnames {
+				if hostnames[i].Port != 0 {
+					continue
+				}
+				if hostnames[i].TLS {
+					hostnames[i].Port = httpsPort
+				} else {
+					hostnames[i].Port = httpPort
+				}
+			}
+		}
+		return hostnames, ips
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Setting up ingress watch: %+v", err)
+	}
+	if o.withdrawWithoutEndpoints {
+		log.Debugf("Withdrawing hostnames of ingress rules without ready endpoints")
+		if backends, err = source.NewBackendSource(sources, "ingress"); err != nil {
+			return nil, fmt.Errorf("Setting up endpoints watch: %+v", err)
+		}
+	}
+
+	if o.gatewayAPI {
+		gatewayAPI, err := source.DetectServedVersion(cluster.clientset, source.GatewayAPIPreference, "httproutes")
+		if err != nil {
+			return nil, fmt.Errorf("Detecting Gateway API version: %+v", err)
+		}
+		log.Infof("Watching %v gateways and httproutes", gatewayAPI)
+		if _, err := source.NewGatewaySource(sources, gatewayAPI, registry); err != nil {
+			return nil, fmt.Errorf("Setting up Gateway API watch: %+v", err)
+		}
+	}
+
+	if o.services {
+		log.Debugf("Watching services")
+		if err := source.WatchServiceHostnames(sources, registry); err != nil {
+			return nil, fmt.Errorf("Setting up service watch: %+v", err)
+		}
+	}
+
+	if o.headlessServices {
+		log.Debugf("Watching headless services")
+		if _, err := source.NewHeadlessSource(sources, registry); err != nil {
+			return nil, fmt.Errorf("Setting up headless service watch: %+v", err)
+		}
+	}
+
+	if o.pods {
+		log.Debugf("Watching pods")
+		if err := source.WatchPodHostnames(sources, registry); err != nil {
+			return nil, fmt.Errorf("Setting up pod watch: %+v", err)
+		}
+	}
+
+	if o.nodes {
+		selector, err := labels.Parse(o.nodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing node selector: %+v", err)
+		}
+		log.Debugf("Watching nodes %v", selector)
+		if err := source.WatchNodeHostnames(sources, selector.String(), registry); err != nil {
+			return nil, fmt.Errorf("Setting up node watch: %+v", err)
+		}
+	}
+
+	if o.openshiftRoutes {
+		log.Debugf("Watching openshift routes")
+		if err := source.WatchOpenshiftRouteHostnames(sources, registry); err != nil {
+			return nil, fmt.Errorf("Setting up openshift route watch: %+v", err)
+		}
+	}
+
+	if o.mdnsEntries {
+		log.Debugf("Watching mdnsentries")
+		if err := source.WatchMDNSEntryHostnames(sources, registry); err != nil {
+			return nil, fmt.Errorf("Setting up mdnsentry watch: %+v", err)
+		}
+	}
+
+	if o.staticEntriesConfigMap != "" {
+		log.Debugf("Watching static entries configmap %v", o.staticEntriesConfigMap)
+		if err := source.WatchStaticEntries(sources, o.staticEntriesConfigMap, registry); err != nil {
+			return nil, fmt.Errorf("Setting up static entries watch: %+v", err)
+		}
+	}
+
+	if o.knative {
+		domainMappingAPI, err := source.DetectServedVersion(cluster.clientset, source.KnativeDomainMappingPreference, "domainmappings")
+		if err != nil {
+			log.Warnf("Not watching Knative DomainMappings: %+v", err)
+		}
+		log.Debugf("Watching knative routes and domainmappings")
+		if _, err := source.NewKnativeSource(sources, o.knativeIngressService, domainMappingAPI, registry); err != nil {
+			return nil, fmt.Errorf("Setting up knative watch: %+v", err)
+		}
+	}
+	return sources, nil
+}
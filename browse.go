@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	"github.com/spf13/cobra"
+)
+
+func newBrowseCommand() *cobra.Command {
+	var interfaces interfaceOptions
+	var domain, output string
+	var types bool
+	cmd := &cobra.Command{
+		Use:   "browse [SERVICE_TYPE]",
+		Short: "Browse the instances of a service type over mDNS, _http._tcp by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, ifaces, err := interfaces.selection()
+			if err != nil {
+				return err
+			}
+			domain = strings.Trim(domain, ".")
+			if types {
+				serviceTypes := publisher.BrowseServiceTypes(ifaces, domain)
+				if len(serviceTypes) == 0 {
+					return fmt.Errorf("no service types in %v answered", domain)
+				}
+				for _, serviceType := range serviceTypes {
+					fmt.Fprintln(cmd.OutOrStdout(), serviceType)
+				}
+				return nil
+			}
+			serviceType := hostname.ServiceTypeHTTP
+			if len(args) > 0 {
+				serviceType = strings.Trim(args[0], ".")
+			}
+			instances := publisher.Browse(ifaces, serviceType, domain)
+			if len(instances) == 0 {
+				return fmt.Errorf("no instances of %v.%v answered", serviceType, domain)
+			}
+			switch output {
+			case outputTable:
+				return printBrowsedInstances(cmd, instances)
+			case outputJSON:
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(instances)
+			default:
+				return fmt.Errorf("unknown output format %v, expected %v or %v", output, outputTable, outputJSON)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	interfaces.addFlags(flags)
+	flags.StringVar(&domain, "domain", "local", "Domain to browse")
+	flags.BoolVar(&types, "types", false, "List the service types announced in the domain instead")
+	flags.StringVarP(&output, "output", "o", outputTable, "Print a table or json")
+	return cmd
+}
+
+// printBrowsedInstances Prints a table of the instances found by browsing
+func printBrowsedInstances(cmd *cobra.Command, instances []publisher.ServiceInstance) error {
+	writer := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "INSTANCE\tHOSTNAME\tPORT\tADDRESSES\tTXT")
+	for _, instance := range instances {
+		host := "-"
+		if instance.Hostname != "" {
+			host = instance.Hostname + "." + instance.Domain
+		}
+		fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t%v\n", instance.Instance, host, instance.Port,
+			strings.Join(instance.IPs, ","), strings.Join(instance.Text, " "))
+	}
+	return writer.Flush()
+}
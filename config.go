@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// reloadableFlags The flags of broadcast a changed config file applies on SIGHUP, the
+// others take effect after a restart
+var reloadableFlags = map[string]bool{
+	"allow-hostnames":  true,
+	"deny-hostnames":   true,
+	"collision-policy": true,
+	"probe":            true,
+	"record-ttl":       true,
+	"srv-priority":     true,
+	"srv-weight":       true,
+}
+
+// configFile Sets flags from a YAML file mapping flag names to values, or to lists of values
+// for repeatable flags. Flags given on the command line or through the environment win
+type configFile struct {
+	path  string
+	flags *pflag.FlagSet
+	// fixed The flags given on the command line or through the environment
+	fixed map[string]bool
+	// values The values read from the file the last time
+	values map[string][]string
+}
+
+// newConfigFile Returns the config file at path for flags, which were parsed already
+func newConfigFile(path string, flags *pflag.FlagSet) *configFile {
+	fixed := map[string]bool{}
+	flags.Visit(func(flag *pflag.Flag) {
+		fixed[flag.Name] = true
+	})
+	return &configFile{path: path, flags: flags, fixed: fixed, values: map[string][]string{}}
+}
+
+// read Returns the values of the flags in the file
+func (c *configFile) read() (map[string][]string, error) {
+	content, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %v: %+v", c.path, err)
+	}
+	values := map[string][]string{}
+	for name, value := range raw {
+		if c.flags.Lookup(name) == nil || name == "config" || name == "help" {
+			return nil, fmt.Errorf("%v sets unknown flag %v", c.path, name)
+		}
+		switch value := value.(type) {
+		case nil:
+		case []interface{}:
+			values[name] = []string{}
+			for _, item := range value {
+				values[name] = append(values[name], fmt.Sprint(item))
+			}
+		default:
+			values[name] = []string{fmt.Sprint(value)}
+		}
+	}
+	return values, nil
+}
+
+// load Sets the flags that are not fixed from the file
+func (c *configFile) load() error {
+	values, err := c.read()
+	if err != nil {
+		return err
+	}
+	for name, flagValues := range values {
+		if c.fixed[name] {
+			continue
+		}
+		if err := setFlag(c.flags.Lookup(name), flagValues); err != nil {
+			return fmt.Errorf("setting --%v from %v: %+v", name, c.path, err)
+		}
+	}
+	c.values = values
+	return nil
+}
+
+// reload Re-reads the file and sets the reloadable flags that are not fixed, those the file
+// leaves out now go back to their defaults. Changes of the other flags are logged and ignored
+func (c *configFile) reload() error {
+	values, err := c.read()
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	c.flags.VisitAll(func(flag *pflag.Flag) {
+		names = append(names, flag.Name)
+	})
+	ignored := []string{}
+	for _, name := range names {
+		flagValues, previous := values[name], c.values[name]
+		if c.fixed[name] || reflect.DeepEqual(flagValues, previous) {
+			continue
+		}
+		if !reloadableFlags[name] {
+			// Kept as loaded, which is what the flag still holds
+			ignored = append(ignored, name)
+			if previous == nil {
+				delete(values, name)
+			} else {
+				values[name] = previous
+			}
+			continue
+		}
+		if err := setFlag(c.flags.Lookup(name), flagValues); err != nil {
+			return fmt.Errorf("setting --%v from %v: %+v", name, c.path, err)
+		}
+		log.Infof("Reloaded --%v from %v", name, c.path)
+	}
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		log.Warnf("Changes of %v in %v take effect after a restart", strings.Join(ignored, ", "), c.path)
+	}
+	c.values = values
+	return nil
+}
+
+// setFlag Replaces the value of flag with values, its default when values is nil
+func setFlag(flag *pflag.Flag, values []string) error {
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		if values == nil {
+			values = []string{}
+		}
+		return slice.Replace(values)
+	}
+	if values == nil {
+		return flag.Value.Set(flag.DefValue)
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("expected a single value, got %v", len(values))
+	}
+	return flag.Value.Set(values[0])
+}
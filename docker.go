@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/source"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// dockerOptions The flags of docker
+type dockerOptions struct {
+	interfaces interfaceOptions
+	responder  responderOptions
+	reflector  reflectorOptions
+	domains    domainOptions
+	publishing publisherOptions
+	socket     string
+}
+
+func newDockerCommand() *cobra.Command {
+	options := &dockerOptions{}
+	cmd := &cobra.Command{
+		Use:   "docker",
+		Short: "Broadcast the hostnames in the labels of Docker or Podman containers, without Kubernetes",
+		Long: `Broadcast the hostnames in the labels of the running containers of a local Docker
+or Podman engine, for single hosts that do not run Kubernetes. Containers declare
+them in a zeroconf.ingress/hostname label, or in the Host rules of Traefik routers.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, forced := withTermination(time.Second * time.Duration(options.publishing.shutdownTimeout))
+			if err := runDocker(ctx, forced, options); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.SortFlags = false
+	options.interfaces.addFlags(flags)
+	flags.StringVar(&options.socket, "socket", source.DockerSocket(), "Unix socket at `path` of the Docker engine, /run/podman/podman.sock for Podman, defaults to that of a unix:// $DOCKER_HOST")
+	options.publishing.addPublisherFlags(flags)
+	options.responder.addFlags(flags)
+	options.reflector.addFlags(flags)
+	options.domains.addFlags(flags)
+	return cmd
+}
+
+// runDocker Publishes the hostnames of the containers until ctx is done, then sends goodbyes
+// and closes the publisher, skipping the drain period when forced is closed
+func runDocker(ctx context.Context, forced <-chan struct{}, options *dockerOptions) error {
+	domains, err := options.domains.options()
+	if err != nil {
+		return fmt.Errorf("Setting up domains: %+v", err)
+	}
+	if err := options.publishing.validate(); err != nil {
+		return err
+	}
+	interfaces, broadcastInterfaces, err := options.interfaces.selection()
+	if err != nil {
+		return fmt.Errorf("Selecting interfaces: %+v", err)
+	}
+	backend, responder, err := newPublisher(options.publishing.publisher, broadcastInterfaces, options.responder.options())
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+	reflector, err := options.reflector.start(responder)
+	if err != nil {
+		return fmt.Errorf("Starting mDNS reflector: %+v", err)
+	}
+	if reflector != nil {
+		defer reflector.Close()
+	}
+
+	registry := publisher.NewRegistry(broadcastInterfaces, backend)
+	if responder != nil {
+		registry.Prober = responder.Prober()
+	}
+	registry.CollisionPolicy = publisher.CollisionFirst
+	registry.ProbePolicy = options.publishing.probe
+	registry.RecordTTL = uint32(options.publishing.recordTTL)
+	registry.Domain = domains.Domain
+	containers := source.NewDockerSource(options.socket, source.HostnameOptions{Options: domains}, registry, func() []net.IP {
+		return interfaceIPs(broadcastInterfaces)
+	})
+	log.Infof("Watching the containers of %v", options.socket)
+
+	sourcesCtx, stopSources := context.WithCancel(ctx)
+	defer stopSources()
+	ready := make(chan struct{})
+	go containers.Run(sourcesCtx, ready)
+	go publisher.WatchInterfaces(sourcesCtx, interfaces, broadcastInterfaces, registry)
+	go notifySystemd(sourcesCtx, ready, registry)
+	if options.publishing.reannounceInterval > 0 && responder != nil {
+		go responder.ReannounceEvery(sourcesCtx, time.Second*time.Duration(options.publishing.reannounceInterval))
+	}
+
+	<-ctx.Done()
+	log.Infof("Shutting down, sending goodbye packets")
+	sdNotify("STOPPING=1")
+	stopSources()
+	registry.UnregisterAll()
+	if options.publishing.drainPeriod > 0 {
+		drainPeriod := time.Second * time.Duration(options.publishing.drainPeriod)
+		log.Infof("Waiting %v for caches to drain", drainPeriod)
+		select {
+		case <-time.After(drainPeriod):
+		case <-forced:
+			log.Infof("Exiting without draining")
+		}
+	}
+	return nil
+}
+
+// interfaceIPs Returns the addresses of the interfaces, those containers publish their ports
+// on, leaving out link-local IPv6 addresses that need a zone
+func interfaceIPs(ifaces []net.Interface) []net.IP {
+	ips := []net.IP{}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			log.Warnf("Failed to list the addresses of %v: %+v", iface.Name, err)
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+	return hostname.SortIPs(ips)
+}
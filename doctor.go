@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/source"
+	"github.com/spf13/cobra"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// doctorPermission A permission broadcast needs with its default flags
+type doctorPermission struct {
+	verb     string
+	group    string
+	resource string
+}
+
+func newDoctorCommand() *cobra.Command {
+	var interfaces interfaceOptions
+	var kube kubeOptions
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the interfaces, the connection to the cluster and the permissions broadcast needs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d := &doctor{cmd: cmd}
+			d.checkInterfaces(&interfaces)
+			d.checkCluster(&kube)
+			if d.failures > 0 {
+				return fmt.Errorf("%v checks failed", d.failures)
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	interfaces.addFlags(flags)
+	kube.addFlags(flags)
+	return cmd
+}
+
+// doctor Prints the results of the checks and counts the failed ones
+type doctor struct {
+	cmd      *cobra.Command
+	failures int
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	fmt.Fprintf(d.cmd.OutOrStdout(), "ok    "+format+"\n", args...)
+}
+
+func (d *doctor) fail(format string, args ...interface{}) {
+	d.failures++
+	fmt.Fprintf(d.cmd.OutOrStdout(), "FAIL  "+format+"\n", args...)
+}
+
+// checkInterfaces Checks that the selected interfaces are up and can multicast
+func (d *doctor) checkInterfaces(interfaces *interfaceOptions) {
+	_, ifaces, err := interfaces.selection()
+	if err != nil {
+		d.fail("%v", err)
+		return
+	}
+	if len(ifaces) == 0 {
+		d.fail("no interfaces selected")
+		return
+	}
+	for _, iface := range ifaces {
+		switch {
+		case iface.Flags&net.FlagUp == 0:
+			d.fail("interface %v is down", iface.Name)
+		case iface.Flags&net.FlagMulticast == 0:
+			d.fail("interface %v is not multicast capable", iface.Name)
+		case !publisher.HasIPv6Address(iface):
+			d.ok("interface %v is up, without IPv6 address for AAAA queries", iface.Name)
+		default:
+			d.ok("interface %v is up", iface.Name)
+		}
+	}
+}
+
+// checkCluster Checks the connection to the API server, the served ingress API and the permissions
+func (d *doctor) checkCluster(kube *kubeOptions) {
+	config, err := kube.config()
+	if err != nil {
+		d.fail("%v", err)
+		return
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		d.fail("failed to create kubernetes client: %+v", err)
+		return
+	}
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		d.fail("failed to reach the API server at %v: %+v", config.Host, err)
+		return
+	}
+	d.ok("API server %v is %v", config.Host, serverVersion.GitVersion)
+
+	ingressAPI, err := source.DetectServedVersion(clientset, source.IngressAPIPreference, "ingresses")
+	if err != nil {
+		d.fail("no ingress API served: %+v", err)
+		ingressAPI = source.IngressAPIPreference[0]
+	} else {
+		d.ok("ingress API %v is served", ingressAPI)
+	}
+	ingressGroup := strings.Split(ingressAPI, "/")[0]
+
+	permissions := []doctorPermission{
+		{"list", ingressGroup, "ingresses"},
+		{"watch", ingressGroup, "ingresses"},
+		{"create", "", "events"},
+	}
+	for _, permission := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:     permission.verb,
+					Group:    permission.group,
+					Resource: permission.resource,
+				},
+			},
+		}
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+		switch {
+		case err != nil:
+			d.fail("failed to review permission to %v %v: %+v", permission.verb, permission.resource, err)
+		case !result.Status.Allowed:
+			d.fail("not allowed to %v %v %v", permission.verb, permission.resource, result.Status.Reason)
+		default:
+			d.ok("allowed to %v %v", permission.verb, permission.resource)
+		}
+	}
+}
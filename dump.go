@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/source"
+	log "github.com/sirupsen/logrus"
+)
+
+// dumpState Logs the registrations, the broadcast interfaces and the objects that are retried,
+// one entry each between a begin and an end entry so that the block can be found in the logs
+func dumpState(registry *publisher.Registry, allSources []*source.Manager) {
+	registrations := registry.Registrations()
+	retries := map[string]string{}
+	for _, sources := range allSources {
+		for owner, reason := range sources.Retries() {
+			retries[owner] = reason
+		}
+	}
+	log.WithFields(log.Fields{"registrations": len(registrations), "retries": len(retries)}).Infof("State dump begins")
+	log.WithField("interfaces", registry.InterfacesState()).Infof("State dump: interfaces")
+	for _, registration := range registrations {
+		fields := publisher.OwnerFields(registration.Owner)
+		fields["hostname"] = registration.Hostname + "." + registration.Domain
+		fields["instance"] = registration.Instance
+		fields["serviceType"] = registration.ServiceType
+		fields["port"] = registration.Port
+		fields["ip"] = strings.Join(registration.IPs, ",")
+		fields["ttl"] = registration.TTL
+		fields["owners"] = strings.Join(registration.Owners, ",")
+		fields["published"] = registration.Published
+		if registration.Pending != "" {
+			fields["pending"] = registration.Pending
+		}
+		if registration.Announced != nil {
+			fields["announced"] = registration.Announced.Format(time.RFC3339)
+		}
+		log.WithFields(fields).Infof("State dump: registration %v", registration.Instance)
+	}
+	owners := []string{}
+	for owner := range retries {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		log.WithFields(publisher.OwnerFields(owner)).WithField("reason", retries[owner]).Infof("State dump: retrying %v", owner)
+	}
+	log.Infof("State dump ends")
+}
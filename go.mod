@@ -1,15 +1,21 @@
 module github.com/mikeas1/ingress-frontend-zeroconf
 
-go 1.13
+go 1.15
 
 require (
-	github.com/docopt/docopt-go v0.0.0-20180111231733-ee0de3bc6815
-	github.com/grandcat/zeroconf v1.0.0
+	github.com/godbus/dbus/v5 v5.0.3
 	github.com/imdario/mergo v0.3.11 // indirect
+	github.com/miekg/dns v1.1.27
+	github.com/prometheus/client_golang v1.7.1
 	github.com/sirupsen/logrus v1.6.0
-	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e // indirect
-	k8s.io/api v0.19.1
-	k8s.io/apimachinery v0.19.1
-	k8s.io/client-go v0.19.0
-	k8s.io/utils v0.0.0-20200912215256-4140de9c8800 // indirect
+	github.com/spf13/cobra v1.1.1
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/net v0.0.0-20200707034311-ab3426394381
+	golang.org/x/sys v0.0.0-20200622214017-ed371f2e16b4
+	gopkg.in/yaml.v2 v2.3.0
+	k8s.io/api v0.19.2
+	k8s.io/apimachinery v0.19.2
+	k8s.io/client-go v0.19.2
+	sigs.k8s.io/controller-runtime v0.7.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -1,213 +1,396 @@
 package main
 
 import (
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"os/signal"
-	"path/filepath"
-	"reflect"
+	"regexp"
 	"strings"
-	"syscall"
-	"time"
 
-	v1 "k8s.io/api/core/v1"
-	v1beta1 "k8s.io/api/networking/v1beta1"
-	"k8s.io/apimachinery/pkg/fields"
-
-	docopt "github.com/docopt/docopt-go"
-	"github.com/grandcat/zeroconf"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
-// LocalHostname An Ingress hostname in the .local domain
-type LocalHostname struct {
-	TLS      bool
-	Hostname string
-}
+// environmentPrefix The prefix of the environment variables setting flags, e.g.
+// ZEROCONF_LOG_FORMAT sets --log-format
+const environmentPrefix = "ZEROCONF_"
 
 func main() {
-	usage := `Kubernetes Ingress Frontend Zeroconf - Broadcast ingress hostnames via mDNS
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
 
-Usage: broadcast [options]
+func newRootCommand() *cobra.Command {
+	var debug bool
+	var logFormat string
+	cmd := &cobra.Command{
+		Use:   "ingress-frontend-zeroconf",
+		Short: "Kubernetes Ingress Frontend Zeroconf - Broadcast ingress hostnames via mDNS",
+		Long: `Kubernetes Ingress Frontend Zeroconf - Broadcast ingress hostnames via mDNS
 
-Options:
-  --interface=name  Interface on which to broadcast [default: eth0]
-  --kubeconfig      Use $HOME/.kube config instead of in-cluster config
-  --debug           Print debugging information
-  -h, --help        show this help`
+Every flag can also be set through an environment variable named after it, e.g.
+ZEROCONF_LOG_FORMAT=json for --log-format=json. Flags that may be repeated take
+comma separated values, quoted like CSV fields when a value holds a comma.`,
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := bindEnvironment(cmd.Flags()); err != nil {
+				return err
+			}
+			if debug {
+				log.SetLevel(log.DebugLevel)
+			} else {
+				log.SetLevel(log.InfoLevel)
+			}
+			return setLogFormat(logFormat)
+		},
+	}
+	var info strings.Builder
+	getBuildInfo().print(&info)
+	cmd.Version = version
+	cmd.SetVersionTemplate(info.String())
+	flags := cmd.PersistentFlags()
+	flags.BoolVar(&debug, "debug", false, "Print debugging information")
+	flags.StringVar(&logFormat, "log-format", logFormatText, "Log as text or as json, for log pipelines such as Loki or Elasticsearch")
+	cmd.AddCommand(newBroadcastCommand(), newListCommand(), newResolveCommand(), newBrowseCommand(), newDoctorCommand(), newDockerCommand(), newVersionCommand())
+	describeEnvironment(cmd, map[*pflag.Flag]bool{})
+	return cmd
+}
 
-	arguments, _ := docopt.ParseDoc(usage)
-	debug, _ := arguments.Bool("--debug")
-	if debug {
-		log.SetLevel(log.DebugLevel)
-	} else {
-		log.SetLevel(log.InfoLevel)
+// describeEnvironment Appends the environment variable of every flag of cmd and its
+// subcommands to the usage of the flag, once for flags shared between commands
+func describeEnvironment(cmd *cobra.Command, described map[*pflag.Flag]bool) {
+	describe := func(flag *pflag.Flag) {
+		if described[flag] || flag.Name == "help" {
+			return
+		}
+		described[flag] = true
+		flag.Usage += " [$" + environmentVariable(flag.Name) + "]"
+	}
+	cmd.PersistentFlags().VisitAll(describe)
+	cmd.Flags().VisitAll(describe)
+	for _, subcommand := range cmd.Commands() {
+		describeEnvironment(subcommand, described)
 	}
-	log.Debug(arguments)
+}
+
+// bindEnvironment Sets the flags that were not given on the command line from their
+// environment variables, repeatable flags are split like a line of CSV. Empty variables
+// are ignored
+func bindEnvironment(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "help" {
+			return
+		}
+		name := environmentVariable(flag.Name)
+		value := os.Getenv(name)
+		if value == "" {
+			return
+		}
+		values := []string{value}
+		if flag.Value.Type() == "stringArray" {
+			if values, err = csv.NewReader(strings.NewReader(value)).Read(); err != nil {
+				err = fmt.Errorf("parsing %v: %+v", name, err)
+				return
+			}
+		}
+		for _, value := range values {
+			if err = flags.Set(flag.Name, value); err != nil {
+				err = fmt.Errorf("setting --%v from %v: %+v", flag.Name, name, err)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// environmentVariable Returns the name of the environment variable of a flag
+func environmentVariable(flag string) string {
+	return environmentPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
 
-	interfaceName, err := arguments.String("--interface")
+// interfaceOptions The flags selecting the interfaces to broadcast on, or to query from
+type interfaceOptions struct {
+	names    []string
+	patterns []string
+	all      bool
+	excluded []string
+}
+
+func (o *interfaceOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringArrayVar(&o.names, "interface", nil, "Interface `name` on which to broadcast, may be repeated, auto picks the interface of the default route, eth0 when neither this nor --interface-pattern is set")
+	flags.StringArrayVar(&o.patterns, "interface-pattern", nil, "Broadcast on every interface whose name matches the `glob`, e.g. 'en*', may be repeated")
+	flags.BoolVar(&o.all, "all-interfaces", false, "Broadcast on every interface that is up and multicast capable")
+	flags.StringArrayVar(&o.excluded, "exclude-interface", nil, "Comma separated `names` or globs of interfaces to leave out of all interfaces and interface patterns, e.g. docker0,cni0,veth*")
+}
+
+// selection Returns the selection and the interfaces it currently selects
+func (o *interfaceOptions) selection() (publisher.InterfaceSelection, []net.Interface, error) {
+	selection, err := publisher.NewInterfaceSelection(o.names, o.patterns, o.all, o.excluded)
 	if err != nil {
-		log.Fatalf("retrieving interface arg: %+v", err)
+		return selection, nil, fmt.Errorf("parsing interfaces: %+v", err)
 	}
-	broadcastInterface, err := getInterfaceByName(interfaceName)
+	ifaces, err := selection.Interfaces()
 	if err != nil {
-		log.Fatalf("Setting up interface: %+v", err)
+		return selection, nil, fmt.Errorf("setting up interface: %+v", err)
 	}
+	return selection, ifaces, nil
+}
 
-	useKubeConfig, err := arguments.Bool("--kubeconfig")
-	if err != nil {
-		log.Fatalf("retrieving kubeconfig arg: %+v", err)
+// domainOptions The flags of the domain broadcast in and the domains mapped into it
+type domainOptions struct {
+	domain       string
+	mapped       []string
+	rewrites     []string
+	otherDomains string
+}
+
+func (o *domainOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.domain, "domain", "local", "Domain of the broadcast hostnames")
+	flags.StringArrayVar(&o.mapped, "map-domain", nil, "Also broadcast hostnames in `domain` under --domain, e.g. grafana.example.com as grafana.local, may be repeated")
+	flags.StringVar(&o.otherDomains, "publish-all-with-suffix", hostname.OtherDomainsSkip, "Also broadcast hostnames outside of --domain and --map-domain: replace publishes grafana.example.com as grafana.local, append as grafana.example.com.local, empty skips them")
+	flags.StringArrayVar(&o.rewrites, "rewrite-hostname", nil, "Rewrite hostnames before their domain is looked at with a `regex => replacement` rule, e.g. '^prod-(.+)$ => $1', $1 standing for submatches, may be repeated and applied in order")
+}
+
+// options Returns the hostname options of the domains and rewrites, errors for invalid ones
+func (o *domainOptions) options() (hostname.Options, error) {
+	options := hostname.DefaultOptions()
+	options.Domain = strings.Trim(o.domain, ".")
+	for _, domain := range o.mapped {
+		options.MappedDomains = append(options.MappedDomains, strings.Trim(domain, "."))
+	}
+	if o.otherDomains != hostname.OtherDomainsSkip && o.otherDomains != hostname.OtherDomainsReplace && o.otherDomains != hostname.OtherDomainsAppend {
+		return options, fmt.Errorf("Unsupported --publish-all-with-suffix %v, expected %v or %v", o.otherDomains, hostname.OtherDomainsReplace, hostname.OtherDomainsAppend)
 	}
-	clientset := getKubernetesClientSet(useKubeConfig)
+	options.OtherDomains = o.otherDomains
+	for _, rule := range o.rewrites {
+		rewrite, err := hostname.ParseRewrite(rule)
+		if err != nil {
+			return options, err
+		}
+		options.Rewrites = append(options.Rewrites, rewrite)
+	}
+	return options, nil
+}
 
-	var zeroconfServers = map[LocalHostname]*zeroconf.Server{}
-	defer unregisterAllHostnames(zeroconfServers)
+// responderOptions The flags of the mDNS responder of --publisher=mdns
+type responderOptions struct {
+	reverseRecords bool
+	responseRate   int
+}
 
-	watcher := cache.NewListWatchFromClient(clientset.NetworkingV1beta1().RESTClient(), "ingresses", v1.NamespaceAll, fields.Everything())
-	log.Debugf("Watching ingresses")
-	_, controller := cache.NewInformer(watcher, &v1beta1.Ingress{}, time.Second*30, cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			log.Debugf("Got new ingress:\n%+v", obj)
-			hostnames, ingressIP := getIngressHostnames(obj.(*v1beta1.Ingress))
-			registerHostnames(hostnames, broadcastInterface, ingressIP, zeroconfServers)
-		},
-		DeleteFunc: func(obj interface{}) {
-			log.Debugf("Got removed ingress:\n%+v", obj)
-			hostnames, _ := getIngressHostnames(obj.(*v1beta1.Ingress))
-			unregisterHostnames(hostnames, zeroconfServers)
-		},
-		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
-			log.Debugf("Got updated ingress")
-			oldIngress := oldObj.(*v1beta1.Ingress)
-			newIngress := oldObj.(*v1beta1.Ingress)
-			oldHostnames, _ := getIngressHostnames(oldIngress)
-			newHostnames, ingressIP := getIngressHostnames(newIngress)
-			if !reflect.DeepEqual(oldHostnames, newHostnames) {
-				log.Infof("Ingress %v changed, re-registering hostnames", oldIngress.Name)
-				unregisterHostnames(oldHostnames, zeroconfServers)
-				registerHostnames(newHostnames, broadcastInterface, ingressIP, zeroconfServers)
-			}
-		},
-	})
+func (o *responderOptions) addFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&o.reverseRecords, "reverse-records", false, "Also publish in-addr.arpa and ip6.arpa PTR records pointing the advertised addresses at their hostnames, for reverse lookups")
+	flags.IntVar(&o.responseRate, "max-response-rate", 20, "Most `responses` per second sent to queries on an interface, those beyond are dropped so a flooding client does not flood the LAN in turn, 0 is unlimited")
+}
+
+func (o *responderOptions) options() publisher.MDNSOptions {
+	return publisher.MDNSOptions{ReverseRecords: o.reverseRecords, ResponseRate: o.responseRate}
+}
+
+// publisherOptions The flags of how the registry publishes records, shared by the commands
+type publisherOptions struct {
+	publisher          string
+	probe              string
+	recordTTL          uint
+	reannounceInterval uint
+	drainPeriod        uint
+	shutdownTimeout    uint
+}
 
-	sigs := make(chan os.Signal, 1)
-	stop := make(chan struct{})
-	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+func (o *publisherOptions) addPublisherFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.publisher, "publisher", publisher.PublisherMDNS, "How records are published: mdns answers queries itself, avahi registers them with the avahi-daemon of the host and resolved registers the services with systemd-resolved, both over the system D-Bus")
+	flags.StringVar(&o.probe, "probe", publisher.ProbeSkip, "Probe the network for each hostname before publishing it, skip does not publish hostnames already in use, rename publishes them as host-2, host-3, ..., off disables probing")
+	flags.UintVar(&o.recordTTL, "record-ttl", 0, "TTL in `seconds` of the published records, 0 keeps the defaults of 3200 and 120 for A and AAAA records")
+	flags.UintVar(&o.reannounceInterval, "reannounce-interval", 0, "Multicast all published records again at this interval in `seconds`, 0 disables re-announcing")
+	flags.UintVar(&o.drainPeriod, "drain-period", 1, "Time in `seconds` to wait after sending goodbye packets on shutdown, before exiting")
+	flags.UintVar(&o.shutdownTimeout, "shutdown-timeout", 30, "Exit at the latest this many `seconds` after SIGTERM or SIGINT, even when stopping the sources or sending goodbyes hangs, 0 waits for them")
+}
+
+// validate Returns an error when the probe policy is not one of the supported policies
+func (o *publisherOptions) validate() error {
+	if o.probe != publisher.ProbeOff && o.probe != publisher.ProbeSkip && o.probe != publisher.ProbeRename {
+		return fmt.Errorf("Unsupported probe policy %v, expected one of %v, %v, %v", o.probe, publisher.ProbeOff, publisher.ProbeSkip, publisher.ProbeRename)
+	}
+	return nil
+}
 
-	go controller.Run(stop)
+// reflectorOptions The flags of the mDNS reflector between network segments
+type reflectorOptions struct {
+	interfaces []string
+	all        bool
+}
 
-	go func() {
-		sig := <-sigs
-		log.Debugf("%v", sig)
-		close(stop)
-	}()
-	<-stop
+func (o *reflectorOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringArrayVar(&o.interfaces, "reflect-interface", nil, "Relay the mDNS responses of this host and the queries they answer between the interfaces with these `names`, e.g. of an IoT VLAN, given at least twice")
+	flags.BoolVar(&o.all, "reflect-all", false, "Relay every mDNS packet between the --reflect-interface interfaces, those of other hosts included")
 }
 
-func getInterfaceByName(interfaceName string) (net.Interface, error) {
-	ifaces, _ := net.Interfaces()
-	ifaceNames := []string{}
-	for _, iface := range ifaces {
-		if iface.Name == interfaceName {
-			log.Debugf("Found interface %v", interfaceName)
-			return iface, nil
+// start Starts relaying between the interfaces of the flags the queries responder answers, every
+// query when it is nil, returns nil without any interfaces
+func (o *reflectorOptions) start(responder *publisher.MDNSResponder) (*publisher.MDNSReflector, error) {
+	if len(o.interfaces) == 0 {
+		if o.all {
+			return nil, errors.New("--reflect-all needs --reflect-interface")
 		}
-		ifaceNames = append(ifaceNames, iface.Name)
+		return nil, nil
+	}
+	if len(o.interfaces) < 2 {
+		return nil, fmt.Errorf("--reflect-interface needs at least two interfaces to relay between, got %v", strings.Join(o.interfaces, ", "))
+	}
+	selection, err := publisher.NewInterfaceSelection(o.interfaces, nil, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	ifaces, err := selection.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var answers func(name string) bool
+	if responder != nil {
+		answers = responder.Answers
+	}
+	reflector, err := publisher.NewMDNSReflector(ifaces, o.all, answers)
+	if err != nil {
+		return nil, err
 	}
-	return net.Interface{}, fmt.Errorf("No interface named %v was found, available interfaces are:\n%v", interfaceName, strings.Join(ifaceNames, "\n"))
+	log.Infof("Reflecting mDNS between %v", strings.Join(o.interfaces, ", "))
+	return reflector, nil
 }
 
-func getKubernetesClientSet(useKubeConfig bool) *kubernetes.Clientset {
-	var config *rest.Config
-	var err error
-	if useKubeConfig {
-		var home string
-		if home = os.Getenv("HOME"); home == "" {
-			home = os.Getenv("USERPROFILE") // windows
-		}
-		path := filepath.Join(home, ".kube", "config")
-		config, err = clientcmd.BuildConfigFromFlags("", path)
+// kubeOptions The flags of the connection to the cluster
+type kubeOptions struct {
+	kubeconfig string
+	contexts   []string
+	master     string
+}
+
+func (o *kubeOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.kubeconfig, "kubeconfig", "", "Kubeconfig file at `path`, without it $KUBECONFIG, the in-cluster config and then $HOME/.kube/config are tried")
+	flags.StringArrayVar(&o.contexts, "context", nil, "Kubeconfig `context` to use instead of the current context, broadcast may be given several to watch all of their clusters")
+	flags.StringVar(&o.master, "master", "", "API server `url` overriding that of the kubeconfig or the in-cluster config, e.g. through an SSH tunnel or kubectl proxy")
+}
+
+// config Returns the config of the only cluster of the flags
+func (o *kubeOptions) config() (*rest.Config, error) {
+	switch len(o.contexts) {
+	case 0:
+		return getKubernetesConfig(o.kubeconfig, "", o.master)
+	case 1:
+		return getKubernetesConfig(o.kubeconfig, o.contexts[0], o.master)
+	default:
+		return nil, fmt.Errorf("Only one --context can be given, got %v", strings.Join(o.contexts, ", "))
+	}
+}
+
+// kubeCluster The clients of a watched cluster. name is its kubeconfig context when several
+// clusters are watched, empty otherwise
+type kubeCluster struct {
+	name          string
+	config        *rest.Config
+	clientset     *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+}
+
+// newClusters Returns the clusters of the contexts of the flags, the one of the current context
+// when there is none or a single one
+func newClusters(o *kubeOptions) ([]*kubeCluster, error) {
+	if len(o.contexts) <= 1 {
+		config, err := o.config()
 		if err != nil {
-			log.Fatalf("failed to construct kube client config from path %v: %+v", path, err)
+			return nil, fmt.Errorf("Setting up kube config: %+v", err)
 		}
-	} else {
-		config, err = rest.InClusterConfig()
+		cluster, err := newKubeCluster("", config)
 		if err != nil {
-			log.Fatalf("failed to construct in-cluster kube config: %+v", err)
+			return nil, err
 		}
+		return []*kubeCluster{cluster}, nil
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("failed to construct kube client: %+v", err)
-	}
-	return clientset
-}
-
-func registerHostnames(
-	hostnames []LocalHostname,
-	broadcastInterface net.Interface,
-	ingressIP net.IP,
-	servers map[LocalHostname]*zeroconf.Server) {
-	for _, local := range hostnames {
-		log.Infof("Registering %v", local.Hostname)
-		// Simplification: Assume ingress listens on standard HTTP(s) ports.
-		port := 80
-		if local.TLS {
-			port = 443
+	if o.master != "" {
+		return nil, errors.New("--master cannot be combined with several contexts")
+	}
+	clusters := []*kubeCluster{}
+	seen := map[string]bool{}
+	for _, context := range o.contexts {
+		if seen[context] {
+			continue
 		}
-		server, err := zeroconf.RegisterProxy(
-			local.Hostname,
-			"_http._tcp.",
-			"local.",
-			port,
-			local.Hostname,
-			[]string{ingressIP.String()},
-			[]string{"path=/"},
-			[]net.Interface{broadcastInterface},
-		)
+		seen[context] = true
+		config, err := getKubernetesConfig(o.kubeconfig, context, "")
 		if err != nil {
-			log.Errorf("Failed to register hostname %v: %+v", local.Hostname, err)
-			continue
+			return nil, fmt.Errorf("Setting up kube config of context %v: %+v", context, err)
+		}
+		cluster, err := newKubeCluster(context, config)
+		if err != nil {
+			return nil, err
 		}
-		servers[local] = server
+		clusters = append(clusters, cluster)
 	}
+	return clusters, nil
 }
 
-func unregisterHostnames(hostnames []LocalHostname, servers map[LocalHostname]*zeroconf.Server) {
-	for _, local := range hostnames {
-		if server, exists := servers[local]; exists {
-			log.Infof("Unregistering %v", local.Hostname)
-			server.Shutdown()
-			delete(servers, local)
-		}
+func newKubeCluster(name string, config *rest.Config) (*kubeCluster, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct kube client: %+v", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct dynamic kube client: %+v", err)
 	}
+	return &kubeCluster{name: name, config: config, clientset: clientset, dynamicClient: dynamicClient}, nil
 }
 
-func unregisterAllHostnames(servers map[LocalHostname]*zeroconf.Server) {
-	for local, server := range servers {
-		log.Infof("Unregistering %v", local.Hostname)
-		server.Shutdown()
+func compileRegexps(expressions []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, expression := range expressions {
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
 	}
+	return compiled, nil
 }
 
-func getIngressHostnames(ingress *v1beta1.Ingress) ([]LocalHostname, net.IP) {
-	// The same ingress can have both cleartext and tls hosts.
-	// This is not implemented yet, for now we just check for the presence
-	// of the tls.
-	tls := ingress.Spec.TLS != nil
-	hostnames := []LocalHostname{}
-	for _, rule := range ingress.Spec.Rules {
-		hostname := rule.Host
-		if !strings.HasSuffix(hostname, ".local") {
-			continue
+// getKubernetesConfig Returns the config of the kubeconfig file at path, or else of the
+// files in $KUBECONFIG, the in-cluster config or else that of $HOME/.kube/config. A context
+// selects another context than the current one of the kubeconfig, master replaces the URL
+// of the API server, which needs no kubeconfig at all when it does not require credentials
+func getKubernetesConfig(path string, context string, master string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+	if path == "" && context == "" && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" {
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			if master != "" {
+				config.Host = master
+			}
+			return config, nil
+		}
+		if err != rest.ErrNotInCluster {
+			return nil, fmt.Errorf("failed to construct in-cluster kube config: %+v", err)
 		}
-		hostnames = append(hostnames, LocalHostname{tls, strings.TrimSuffix(hostname, ".local")})
 	}
-	ip := net.ParseIP(ingress.Status.LoadBalancer.Ingress[0].IP)
-	return hostnames, ip
+	files := rules.GetLoadingPrecedence()
+	if path != "" {
+		files = []string{path}
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context, ClusterInfo: clientcmdapi.Cluster{Server: master}}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct kube client config from %v: %+v", strings.Join(files, ", "), err)
+	}
+	return config, nil
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/source"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// listTimeout How long list waits for the admin endpoint
+const listTimeout = time.Second * 10
+
+func newListCommand() *cobra.Command {
+	var server, stateFile, output string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the registrations of a running broadcast, from its --admin-listen endpoint or --state-file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var registrations []publisher.Registration
+			var err error
+			switch {
+			case server != "":
+				registrations, err = fetchRegistrations(server)
+			case stateFile != "":
+				registrations, err = loadRegistrations(stateFile)
+			default:
+				return fmt.Errorf("either --server or --state-file is required")
+			}
+			if err != nil {
+				return err
+			}
+			switch output {
+			case outputTable:
+				return printRegistrations(cmd, registrations)
+			case outputJSON:
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(registrations)
+			default:
+				return fmt.Errorf("unknown output format %v, expected %v or %v", output, outputTable, outputJSON)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&server, "server", "", "Address `host:port` or URL of the endpoint served by broadcast --admin-listen")
+	flags.StringVar(&stateFile, "state-file", "", "State file at `path` written by broadcast --state-file, which only holds the published records")
+	flags.StringVarP(&output, "output", "o", outputTable, "Print a table or json")
+	return cmd
+}
+
+// fetchRegistrations Returns the registrations served by the admin endpoint at server
+func fetchRegistrations(server string) ([]publisher.Registration, error) {
+	url := strings.TrimSuffix(server, "/")
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	url += source.RegistrationsPath
+	client := &http.Client{Timeout: listTimeout}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %v: %+v", url, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %v: %v", url, response.Status)
+	}
+	registrations := []publisher.Registration{}
+	if err := json.NewDecoder(response.Body).Decode(&registrations); err != nil {
+		return nil, fmt.Errorf("decoding %v: %+v", url, err)
+	}
+	return registrations, nil
+}
+
+// loadRegistrations Returns the instances of the state file as published registrations
+func loadRegistrations(stateFile string) ([]publisher.Registration, error) {
+	instances, err := publisher.LoadState(stateFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading state file %v: %+v", stateFile, err)
+	}
+	registrations := []publisher.Registration{}
+	for _, instance := range instances {
+		registrations = append(registrations, publisher.Registration{ServiceInstance: instance, Published: true})
+	}
+	return registrations, nil
+}
+
+// printRegistrations Prints a table of the registrations
+func printRegistrations(cmd *cobra.Command, registrations []publisher.Registration) error {
+	writer := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "HOSTNAME\tINSTANCE\tSERVICE\tPORT\tADDRESSES\tTTL\tOWNER\tPUBLISHED\tANNOUNCED")
+	for _, registration := range registrations {
+		announced := "-"
+		if registration.Announced != nil {
+			announced = registration.Announced.Format(time.RFC3339)
+		}
+		owner := registration.Owner
+		if owner == "" {
+			owner = "-"
+		}
+		fmt.Fprintf(writer, "%v.%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", registration.Hostname, registration.Domain,
+			registration.Instance, registration.ServiceType, registration.Port, strings.Join(registration.IPs, ","),
+			registration.TTL, owner, registration.Published, announced)
+	}
+	return writer.Flush()
+}
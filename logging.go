@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setLogFormat Switches logrus to the text or JSON formatter
+func setLogFormat(format string) error {
+	switch format {
+	case logFormatText:
+		log.SetFormatter(&log.TextFormatter{})
+	case logFormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unsupported log format %v, expected one of %v, %v", format, logFormatText, logFormatJSON)
+	}
+	return nil
+}
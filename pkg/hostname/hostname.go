@@ -0,0 +1,286 @@
+// Package hostname Maps the hostnames of Kubernetes objects into the broadcast domain and
+// orders the addresses they are advertised with
+package hostname
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AnnotationPrefix The prefix of the annotations configuring and reporting the broadcast of objects
+const AnnotationPrefix = "zeroconf.ingress/"
+
+// DefaultDomain The domain hostnames are broadcast in without --domain
+const DefaultDomain = "local"
+
+// Options How the hostnames of objects are mapped into the broadcast domain and which
+// addresses they are advertised with, configured once at startup through the flags
+type Options struct {
+	// Domain The domain hostnames are selected from and broadcast in, --domain
+	Domain string
+	// IPFamily The address family advertised from LoadBalancer statuses, --ip-family
+	IPFamily string
+	// MappedDomains Domains whose hostnames are broadcast in Domain as well, --map-domain
+	MappedDomains []string
+	// Rewrites Rewrite the hostnames of objects in order before their domain is looked at,
+	// --rewrite-hostname
+	Rewrites []Rewrite
+	// OtherDomains Decides whether hostnames outside of Domain and MappedDomains are broadcast,
+	// --publish-all-with-suffix
+	OtherDomains string
+}
+
+// DefaultOptions Returns the options of the flags defaults, broadcasting the hostnames of the
+// local domain with all their addresses
+func DefaultOptions() Options {
+	return Options{Domain: DefaultDomain, IPFamily: IPFamilyAny, OtherDomains: OtherDomainsSkip}
+}
+
+const (
+	// OtherDomainsSkip Skips hostnames outside of the broadcast and mapped domains
+	OtherDomainsSkip = ""
+	// OtherDomainsReplace Broadcasts them with their domain replaced, grafana.example.com as grafana.local
+	OtherDomainsReplace = "replace"
+	// OtherDomainsAppend Broadcasts them with the domain appended, grafana.example.com as grafana.example.com.local
+	OtherDomainsAppend = "append"
+)
+
+// Rewrite Replaces the matches of Pattern in a hostname with Replacement, in which $1 or
+// ${name} stand for the submatches
+type Rewrite struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseRewrite Parses a rewrite of the form "regex => replacement"
+func ParseRewrite(rule string) (Rewrite, error) {
+	separator := strings.LastIndex(rule, " => ")
+	if separator < 0 {
+		return Rewrite{}, fmt.Errorf("rewrite %v is not of the form 'regex => replacement'", rule)
+	}
+	pattern, err := regexp.Compile(strings.TrimSpace(rule[:separator]))
+	if err != nil {
+		return Rewrite{}, fmt.Errorf("rewrite %v: %+v", rule, err)
+	}
+	return Rewrite{Pattern: pattern, Replacement: strings.TrimSpace(rule[separator+len(" => "):])}, nil
+}
+
+// rewrite Returns hostname with every rewrite applied to the result of the previous one
+func (o Options) rewrite(hostname string) string {
+	for _, rule := range o.Rewrites {
+		rewritten := rule.Pattern.ReplaceAllString(hostname, rule.Replacement)
+		if rewritten != hostname {
+			log.Debugf("Rewrote hostname %v to %v", hostname, rewritten)
+			hostname = rewritten
+		}
+	}
+	return hostname
+}
+
+const (
+	IPFamilyAny  = "any"
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+const (
+	ServiceTypeHTTP  = "_http._tcp"
+	ServiceTypeHTTPS = "_https._tcp"
+)
+
+// ServiceTypePattern Matches DNS-SD service types such as _http._tcp
+var ServiceTypePattern = regexp.MustCompile(`^_[A-Za-z0-9-]{1,15}\._(tcp|udp)$`)
+
+// LocalHostname An Ingress hostname in the broadcast domain, without the domain suffix
+type LocalHostname struct {
+	TLS      bool
+	Hostname string
+	// Instance overrides the DNS-SD instance name, which is the hostname, when set
+	Instance string
+	// Port overrides the standard HTTP(s) port when set
+	Port int
+	// ServiceType overrides the _http._tcp or, for TLS, _https._tcp DNS-SD service type when set
+	ServiceType string
+	// Text overrides the path=/ TXT record when set, ingresses set the path of their rule
+	Text []string
+	// Priority and Weight override the registry wide SRV defaults when set
+	Priority *uint16
+	Weight   *uint16
+	// TTL overrides the TTL of the PTR, SRV and TXT records when set
+	TTL uint32
+	// SSDPDescription The path of the UPnP device description, advertised over SSDP when set
+	SSDPDescription string
+	// WSDiscoveryTypes The WS-Discovery types, advertised over WS-Discovery when set
+	WSDiscoveryTypes string
+}
+
+// AdvertisedPort Returns Port, or 443 and 80 for TLS and plain HTTP hostnames without one
+func (local LocalHostname) AdvertisedPort() int {
+	if local.Port != 0 {
+		return local.Port
+	}
+	// Simplification: Assume ingress listens on standard HTTP(s) ports.
+	if local.TLS {
+		return 443
+	}
+	return 80
+}
+
+// AdvertisedServiceType Returns ServiceType, or _https._tcp and _http._tcp without one
+func (local LocalHostname) AdvertisedServiceType() string {
+	if local.ServiceType != "" {
+		return local.ServiceType
+	}
+	if local.TLS {
+		return ServiceTypeHTTPS
+	}
+	return ServiceTypeHTTP
+}
+
+// TextRecords Returns Text, or path=/ without it
+func (local LocalHostname) TextRecords() []string {
+	if local.Text != nil {
+		return local.Text
+	}
+	return []string{"path=/"}
+}
+
+// InstanceName Returns the DNS-SD instance name, the hostname without an Instance override
+func (local LocalHostname) InstanceName() string {
+	if local.Instance != "" {
+		return local.Instance
+	}
+	return local.Hostname
+}
+
+// Key Identifies the DNS-SD registration of a hostname
+func (local LocalHostname) Key() string {
+	return fmt.Sprintf("%v.%v", local.InstanceName(), local.AdvertisedServiceType())
+}
+
+// resolverRefreshInterval How long resolved hostnames are cached, informer resyncs
+// pick up changed addresses once it passed
+const resolverRefreshInterval = time.Second * 30
+
+// resolver Resolves the hostnames that LoadBalancers and routers are addressed by
+var resolver = &cachingResolver{entries: map[string]resolvedHostname{}}
+
+// cachingResolver Caches DNS lookups so that the old and new object of an update and
+// all objects behind the same load balancer see the same addresses
+type cachingResolver struct {
+	mutex   sync.Mutex
+	entries map[string]resolvedHostname
+}
+
+type resolvedHostname struct {
+	ips        []net.IP
+	resolvedAt time.Time
+}
+
+// Resolve Looks up the addresses of hostname through the caching resolver
+func Resolve(hostname string) ([]net.IP, error) {
+	return resolver.lookup(hostname)
+}
+
+func (r *cachingResolver) lookup(hostname string) ([]net.IP, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if entry, exists := r.entries[hostname]; exists && time.Since(entry.resolvedAt) < resolverRefreshInterval {
+		return entry.ips, nil
+	}
+	ips, err := net.LookupIP(hostname)
+	if err != nil {
+		delete(r.entries, hostname)
+		return nil, err
+	}
+	// Round robin DNS shuffles the answers, keep them in a stable order
+	SortIPs(ips)
+	if entry, exists := r.entries[hostname]; exists && !IPsEqual(entry.ips, ips) {
+		log.Infof("Hostname %v now resolves to %v", hostname, IPStrings(ips))
+	}
+	r.entries[hostname] = resolvedHostname{ips: ips, resolvedAt: time.Now()}
+	return ips, nil
+}
+
+// SelectAddresses Returns every distinct address, IPv4 before IPv6,
+// leaving out the family not selected with IPFamily
+func (o Options) SelectAddresses(ips []net.IP) []net.IP {
+	ipv4s, ipv6s := []net.IP{}, []net.IP{}
+	seen := map[string]bool{}
+	for _, ip := range ips {
+		if seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		if ip.To4() != nil {
+			if o.IPFamily != IPFamilyIPv6 {
+				ipv4s = append(ipv4s, ip)
+			}
+		} else if o.IPFamily != IPFamilyIPv4 {
+			ipv6s = append(ipv6s, ip)
+		}
+	}
+	return append(ipv4s, ipv6s...)
+}
+
+// SortIPs Sorts ips in place and returns them
+func SortIPs(ips []net.IP) []net.IP {
+	sort.Slice(ips, func(i, j int) bool { return bytes.Compare(ips[i].To16(), ips[j].To16()) < 0 })
+	return ips
+}
+
+// IPsEqual Reports whether a and b hold the same addresses in the same order
+func IPsEqual(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// IPStrings Formats ips for logs and records
+func IPStrings(ips []net.IP) []string {
+	strs := []string{}
+	for _, ip := range ips {
+		strs = append(strs, ip.String())
+	}
+	return strs
+}
+
+// TrimDomain Returns hostname without the broadcast domain suffix, or false if it is not in that domain.
+// Hostnames in one of the mapped domains are returned without that domain instead, those of
+// other domains as OtherDomains says. The rewrites are applied first
+func (o Options) TrimDomain(hostname string) (string, bool) {
+	hostname = o.rewrite(hostname)
+	if strings.HasSuffix(hostname, "."+o.Domain) {
+		return strings.TrimSuffix(hostname, "."+o.Domain), true
+	}
+	for _, domain := range o.MappedDomains {
+		if strings.HasSuffix(hostname, "."+domain) {
+			return strings.TrimSuffix(hostname, "."+domain), true
+		}
+	}
+	hostname = strings.TrimSuffix(hostname, ".")
+	if hostname == "" || strings.HasPrefix(hostname, "*") {
+		return "", false
+	}
+	switch o.OtherDomains {
+	case OtherDomainsReplace:
+		return strings.SplitN(hostname, ".", 2)[0], true
+	case OtherDomainsAppend:
+		return hostname, true
+	}
+	return "", false
+}
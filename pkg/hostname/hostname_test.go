@@ -0,0 +1,136 @@
+package hostname
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestTrimDomain(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  Options
+		hostname string
+		want     string
+		wantOK   bool
+	}{
+		{"in the domain", DefaultOptions(), "grafana.local", "grafana", true},
+		{"subdomain", DefaultOptions(), "grafana.apps.local", "grafana.apps", true},
+		{"domain only", DefaultOptions(), "local", "", false},
+		{"other domain", DefaultOptions(), "grafana.example.com", "", false},
+		{"suffix of a label", DefaultOptions(), "grafana.nonlocal", "", false},
+		{"other broadcast domain", Options{Domain: "home.arpa"}, "grafana.home.arpa", "grafana", true},
+		{"local outside of the broadcast domain", Options{Domain: "home.arpa"}, "grafana.local", "", false},
+		{"mapped domain", Options{Domain: DefaultDomain, MappedDomains: []string{"example.com", "example.org"}}, "grafana.example.org", "grafana", true},
+		{"replaced other domain", Options{Domain: DefaultDomain, OtherDomains: OtherDomainsReplace}, "grafana.example.com", "grafana", true},
+		{"appended other domain", Options{Domain: DefaultDomain, OtherDomains: OtherDomainsAppend}, "grafana.example.com.", "grafana.example.com", true},
+		{"wildcard other domain", Options{Domain: DefaultDomain, OtherDomains: OtherDomainsAppend}, "*.example.com", "", false},
+		{"empty", Options{Domain: DefaultDomain, OtherDomains: OtherDomainsAppend}, "", "", false},
+		{"rewritten into the domain", Options{Domain: DefaultDomain, Rewrites: []Rewrite{mustParseRewrite(t, `\.example\.com$ => .local`)}}, "grafana.example.com", "grafana", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := test.options.TrimDomain(test.hostname)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("TrimDomain(%q) = %q, %v, want %q, %v", test.hostname, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestSelectAddresses(t *testing.T) {
+	ips := []net.IP{net.ParseIP("fd00::1"), net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}
+	tests := []struct {
+		ipFamily string
+		want     []string
+	}{
+		{IPFamilyAny, []string{"10.0.0.1", "10.0.0.2", "fd00::1"}},
+		{IPFamilyIPv4, []string{"10.0.0.1", "10.0.0.2"}},
+		{IPFamilyIPv6, []string{"fd00::1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.ipFamily, func(t *testing.T) {
+			got := IPStrings(Options{IPFamily: test.ipFamily}.SelectAddresses(ips))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SelectAddresses() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRewrite(t *testing.T) {
+	tests := []struct {
+		rule            string
+		wantPattern     string
+		wantReplacement string
+		wantErr         bool
+	}{
+		{`^(.+)\.example\.com$ => $1.local`, `^(.+)\.example\.com$`, "$1.local", false},
+		{`  \.lan$   =>   .local  `, `\.lan$`, ".local", false},
+		{`^(?P<app>[^.]+)\.apps\. => ${app}.`, `^(?P<app>[^.]+)\.apps\.`, "${app}.", false},
+		{`=> => x`, `=>`, "x", false},
+		{`-staging => `, `-staging`, "", false},
+		{`\.lan$ =>`, ``, "", true},
+		{`\.lan$=>.local`, ``, "", true},
+		{`\.lan$`, ``, "", true},
+		{`(unclosed => .local`, ``, "", true},
+		{`[a-z => .local`, ``, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.rule, func(t *testing.T) {
+			rewrite, err := ParseRewrite(test.rule)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseRewrite(%q) = %v => %q, want an error", test.rule, rewrite.Pattern, rewrite.Replacement)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRewrite(%q) = %+v", test.rule, err)
+			}
+			if rewrite.Pattern.String() != test.wantPattern || rewrite.Replacement != test.wantReplacement {
+				t.Errorf("ParseRewrite(%q) = %v => %q, want %v => %q", test.rule, rewrite.Pattern, rewrite.Replacement, test.wantPattern, test.wantReplacement)
+			}
+		})
+	}
+}
+
+func TestRewrites(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []string
+		hostname string
+		want     string
+	}{
+		{"no rewrites", nil, "grafana.example.com", "grafana.example.com"},
+		{"no match", []string{`\.lan$ => .local`}, "grafana.example.com", "grafana.example.com"},
+		{"match", []string{`\.lan$ => .local`}, "grafana.lan", "grafana.local"},
+		{"submatch", []string{`^(.+)\.example\.com$ => $1.local`}, "grafana.example.com", "grafana.local"},
+		{"named submatch", []string{`^(?P<app>[^.]+)-prod\. => ${app}.`}, "grafana-prod.local", "grafana.local"},
+		{"every match", []string{`-staging => `}, "grafana-staging-staging.local", "grafana.local"},
+		{"chained in order", []string{`\.lan$ => .example.com`, `\.example\.com$ => .local`}, "grafana.lan", "grafana.local"},
+		{"not chained out of order", []string{`\.example\.com$ => .local`, `\.lan$ => .example.com`}, "grafana.lan", "grafana.example.com"},
+		{"first match rewritten again", []string{`^grafana\. => dash.`, `^dash\. => charts.`}, "grafana.local", "charts.local"},
+		{"later rules only match the rewritten hostname", []string{`^grafana\. => dash.`, `^grafana\. => charts.`}, "grafana.local", "dash.local"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := DefaultOptions()
+			for _, rule := range test.rules {
+				options.Rewrites = append(options.Rewrites, mustParseRewrite(t, rule))
+			}
+			if got := options.rewrite(test.hostname); got != test.want {
+				t.Errorf("rewrite(%q) = %q, want %q", test.hostname, got, test.want)
+			}
+		})
+	}
+}
+
+func mustParseRewrite(t *testing.T, rule string) Rewrite {
+	t.Helper()
+	rewrite, err := ParseRewrite(rule)
+	if err != nil {
+		t.Fatalf("ParseRewrite(%q) = %+v", rule, err)
+	}
+	return rewrite
+}
@@ -0,0 +1,205 @@
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	avahiService    = "org.freedesktop.Avahi"
+	avahiServer     = avahiService + ".Server"
+	avahiEntryGroup = avahiService + ".EntryGroup"
+	// avahiProtoUnspec Publishes over both IPv4 and IPv6
+	avahiProtoUnspec = int32(-1)
+	// avahiPublishNoReverse Leaves out the reverse PTR record, several hostnames may share an address
+	avahiPublishNoReverse = uint32(16)
+)
+
+// AvahiPublisher Publishes instances through EntryGroups of the avahi-daemon on the host, which
+// keeps owning the mDNS port. The service records of each instance are in a group of their own,
+// the address records of each host in one shared by all instances of the host
+type AvahiPublisher struct {
+	mutex     sync.Mutex
+	conn      *dbus.Conn
+	ifaces    []net.Interface
+	instances map[string]ServiceInstance
+	services  map[string]dbus.BusObject
+	hosts     map[string]dbus.BusObject
+}
+
+// NewAvahiPublisher Connects to the avahi-daemon over the system D-Bus
+func NewAvahiPublisher(ifaces []net.Interface) (*AvahiPublisher, error) {
+	conn, err := dbus.SystemBusPrivate()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the system bus: %+v", err)
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("authenticating on the system bus: %+v", err)
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("greeting the system bus: %+v", err)
+	}
+	var version string
+	if err := conn.Object(avahiService, "/").Call(avahiServer+".GetVersionString", 0).Store(&version); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reaching avahi-daemon: %+v", err)
+	}
+	log.Infof("Publishing through %v", version)
+	return &AvahiPublisher{
+		conn:      conn,
+		ifaces:    ifaces,
+		instances: map[string]ServiceInstance{},
+		services:  map[string]dbus.BusObject{},
+		hosts:     map[string]dbus.BusObject{},
+	}, nil
+}
+
+func (p *AvahiPublisher) Publish(instance ServiceInstance) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.instances[instance.key()] = instance
+	if err := p.publishService(instance); err != nil {
+		return err
+	}
+	return p.publishHost(instance.hostKey())
+}
+
+// Unpublish Frees the groups of instance, avahi-daemon already withdrew the instances of
+// previous runs when their connection closed
+func (p *AvahiPublisher) Unpublish(instance ServiceInstance) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, exists := p.instances[instance.key()]; !exists {
+		return
+	}
+	delete(p.instances, instance.key())
+	p.free(p.services, instance.key())
+	if err := p.publishHost(instance.hostKey()); err != nil {
+		log.Errorf("Failed to update the addresses of %v: %+v", instance.Hostname, err)
+	}
+}
+
+func (p *AvahiPublisher) SetInterfaces(ifaces []net.Interface) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.ifaces = ifaces
+	hostKeys := map[string]bool{}
+	for _, instance := range p.instances {
+		if err := p.publishService(instance); err != nil {
+			return err
+		}
+		hostKeys[instance.hostKey()] = true
+	}
+	for hostKey := range hostKeys {
+		if err := p.publishHost(hostKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *AvahiPublisher) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for key := range p.services {
+		p.free(p.services, key)
+	}
+	for key := range p.hosts {
+		p.free(p.hosts, key)
+	}
+	p.conn.Close()
+}
+
+// publishService Replaces the PTR, SRV and TXT records of instance on every interface,
+// the caller holds the mutex
+func (p *AvahiPublisher) publishService(instance ServiceInstance) error {
+	text := [][]byte{}
+	for _, entry := range instance.Text {
+		text = append(text, []byte(entry))
+	}
+	host := instance.Hostname + "." + instance.Domain
+	return p.commit(p.services, instance.key(), func(group dbus.BusObject, index int32) error {
+		return group.Call(avahiEntryGroup+".AddService", 0, index, avahiProtoUnspec, uint32(0),
+			instance.Instance, instance.ServiceType, instance.Domain, host, uint16(instance.Port), text).Err
+	})
+}
+
+// publishHost Replaces the address records of a host with the addresses of all its instances,
+// the caller holds the mutex
+func (p *AvahiPublisher) publishHost(hostKey string) error {
+	host := ""
+	ips := []string{}
+	seen := map[string]bool{}
+	for _, instance := range p.instances {
+		if instance.hostKey() != hostKey {
+			continue
+		}
+		host = instance.Hostname + "." + instance.Domain
+		for _, ip := range instance.IPs {
+			if !seen[ip] {
+				seen[ip] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		p.free(p.hosts, hostKey)
+		return nil
+	}
+	return p.commit(p.hosts, hostKey, func(group dbus.BusObject, index int32) error {
+		for _, ip := range ips {
+			if err := group.Call(avahiEntryGroup+".AddAddress", 0, index, avahiProtoUnspec, avahiPublishNoReverse, host, ip).Err; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// commit Fills the group stored under key in groups, creating or emptying it first, with add
+// called for the index of every interface and commits it. The caller holds the mutex
+func (p *AvahiPublisher) commit(groups map[string]dbus.BusObject, key string, add func(group dbus.BusObject, index int32) error) error {
+	group, exists := groups[key]
+	if exists {
+		if err := group.Call(avahiEntryGroup+".Reset", 0).Err; err != nil {
+			return fmt.Errorf("resetting entry group of %v: %+v", key, err)
+		}
+	} else {
+		var path dbus.ObjectPath
+		if err := p.conn.Object(avahiService, "/").Call(avahiServer+".EntryGroupNew", 0).Store(&path); err != nil {
+			return fmt.Errorf("creating entry group of %v: %+v", key, err)
+		}
+		group = p.conn.Object(avahiService, path)
+		groups[key] = group
+	}
+	if len(p.ifaces) == 0 {
+		return nil
+	}
+	for _, iface := range p.ifaces {
+		if err := add(group, int32(iface.Index)); err != nil {
+			return fmt.Errorf("adding %v on %v: %+v", key, iface.Name, err)
+		}
+	}
+	if err := group.Call(avahiEntryGroup+".Commit", 0).Err; err != nil {
+		return fmt.Errorf("committing entry group of %v: %+v", key, err)
+	}
+	return nil
+}
+
+// free Frees the group stored under key in groups, which withdraws its records
+func (p *AvahiPublisher) free(groups map[string]dbus.BusObject, key string) {
+	group, exists := groups[key]
+	if !exists {
+		return
+	}
+	delete(groups, key)
+	if err := group.Call(avahiEntryGroup+".Free", 0).Err; err != nil {
+		log.Errorf("Failed to free entry group of %v: %+v", key, err)
+	}
+}
@@ -0,0 +1,227 @@
+package publisher
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+// serviceEnumeration The name of the DNS-SD service type enumeration, RFC 6763 section 9
+const serviceEnumeration = "_services._dns-sd._udp"
+
+// BrowseServiceTypes Queries the service types announced in domain on the interfaces
+func BrowseServiceTypes(ifaces []net.Interface, domain string) []string {
+	suffix := "." + dns.Fqdn(domain)
+	types := []string{}
+	for _, record := range queryRecords(ifaces, []dns.Question{newQuestion(serviceEnumeration+suffix, dns.TypePTR)}) {
+		if ptr, ok := record.(*dns.PTR); ok && strings.HasSuffix(strings.ToLower(ptr.Ptr), strings.ToLower(suffix)) {
+			types = append(types, ptr.Ptr[:len(ptr.Ptr)-len(suffix)])
+		}
+	}
+	sort.Strings(types)
+	return uniqueStrings(types)
+}
+
+// Browse Queries the instances of serviceType in domain on the interfaces, resolving their
+// SRV, TXT and address records with follow-up queries when responders left them out
+func Browse(ifaces []net.Interface, serviceType string, domain string) []ServiceInstance {
+	service := serviceType + "." + dns.Fqdn(domain)
+	records := queryRecords(ifaces, []dns.Question{newQuestion(service, dns.TypePTR)})
+	names := []string{}
+	for _, record := range records {
+		if ptr, ok := record.(*dns.PTR); ok && strings.EqualFold(ptr.Hdr.Name, service) {
+			names = append(names, ptr.Ptr)
+		}
+	}
+	followUps := []dns.Question{}
+	for _, name := range names {
+		if srv := findSRV(records, name); srv == nil {
+			followUps = append(followUps, newQuestion(name, dns.TypeANY))
+		} else if len(findAddresses(records, srv.Target)) == 0 {
+			followUps = append(followUps, newQuestion(srv.Target, dns.TypeANY))
+		}
+	}
+	if len(followUps) > 0 {
+		records = append(records, queryRecords(ifaces, followUps)...)
+	}
+
+	instances := []ServiceInstance{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[strings.ToLower(name)] || !strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(service)) {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		instance := ServiceInstance{
+			Instance:    unescapeLabel(name[:len(name)-len(service)-1]),
+			ServiceType: serviceType,
+			Domain:      strings.TrimSuffix(dns.Fqdn(domain), "."),
+			Text:        []string{},
+			IPs:         []string{},
+		}
+		if srv := findSRV(records, name); srv != nil {
+			instance.Hostname = strings.TrimSuffix(strings.TrimSuffix(srv.Target, "."+dns.Fqdn(domain)), ".")
+			instance.Port, instance.Priority, instance.Weight = int(srv.Port), srv.Priority, srv.Weight
+			instance.TTL = srv.Hdr.Ttl
+			for _, ip := range findAddresses(records, srv.Target) {
+				instance.IPs = append(instance.IPs, ip.String())
+			}
+			instance.IPs = uniqueStrings(instance.IPs)
+		}
+		for _, record := range records {
+			if txt, ok := record.(*dns.TXT); ok && strings.EqualFold(txt.Hdr.Name, name) {
+				instance.Text = txt.Txt
+			}
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Instance < instances[j].Instance })
+	return instances
+}
+
+// QueryHostname Queries fqdn on the interfaces and returns the addresses responders answer with
+func QueryHostname(ifaces []net.Interface, fqdn string) []net.IP {
+	ips := []net.IP{}
+	for _, ip := range findAddresses(queryRecords(ifaces, []dns.Question{newQuestion(dns.Fqdn(fqdn), dns.TypeANY)}), dns.Fqdn(fqdn)) {
+		if !containsIP(ips, ip) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// queryRecords Multicasts the questions probeCount times on the interfaces and returns the
+// records of all responses received until probeCount * probeInterval later. Responses are read
+// from the mDNS groups as well, since most responders multicast their answers
+func queryRecords(ifaces []net.Interface, questions []dns.Question) []dns.RR {
+	query := new(dns.Msg)
+	query.Question = questions
+	query.RecursionDesired = false
+	packed, err := query.Pack()
+	if err != nil {
+		log.Errorf("Failed to pack query: %+v", err)
+		return nil
+	}
+
+	conns, send := openMulticastSenders(ifaces)
+	groups, err := listenMulticastGroups(ifaces, mdnsGroupIPv4, mdnsGroupIPv6)
+	if err != nil {
+		log.Debugf("Only reading unicast responses: %+v", err)
+	}
+	for _, group := range groups {
+		conns = append(conns, group.conn)
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	responses := make(chan []dns.RR, 16)
+	for _, conn := range conns {
+		go readRecords(conn, responses)
+	}
+	for i := 0; i < probeCount; i++ {
+		send(packed)
+		time.Sleep(probeInterval)
+	}
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	records := []dns.RR{}
+	for range conns {
+		records = append(records, <-responses...)
+	}
+	return records
+}
+
+// readRecords Collects the answers and additional records of the responses received on conn
+// until it times out
+func readRecords(conn *net.UDPConn, responses chan<- []dns.RR) {
+	records := []dns.RR{}
+	buffer := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			responses <- records
+			return
+		}
+		response := new(dns.Msg)
+		if err := response.Unpack(buffer[:n]); err != nil || !response.Response {
+			continue
+		}
+		records = append(records, response.Answer...)
+		records = append(records, response.Extra...)
+	}
+}
+
+func newQuestion(name string, qtype uint16) dns.Question {
+	return dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET}
+}
+
+// findSRV Returns the last SRV record of name in records, nil if there is none
+func findSRV(records []dns.RR, name string) *dns.SRV {
+	var found *dns.SRV
+	for _, record := range records {
+		if srv, ok := record.(*dns.SRV); ok && strings.EqualFold(srv.Hdr.Name, name) {
+			found = srv
+		}
+	}
+	return found
+}
+
+// findAddresses Returns the addresses of the A and AAAA records of host in records
+func findAddresses(records []dns.RR, host string) []net.IP {
+	ips := []net.IP{}
+	for _, record := range records {
+		if !strings.EqualFold(record.Header().Name, host) {
+			continue
+		}
+		switch address := record.(type) {
+		case *dns.A:
+			ips = append(ips, address.A)
+		case *dns.AAAA:
+			ips = append(ips, address.AAAA)
+		}
+	}
+	return ips
+}
+
+// unescapeLabel Reverses escapeLabel
+func unescapeLabel(label string) string {
+	unescaped := make([]byte, 0, len(label))
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 >= len(label) {
+			unescaped = append(unescaped, label[i])
+			continue
+		}
+		if i+3 < len(label) {
+			if code, err := strconv.Atoi(label[i+1 : i+4]); err == nil && code < 256 {
+				unescaped = append(unescaped, byte(code))
+				i += 3
+				continue
+			}
+		}
+		unescaped = append(unescaped, label[i+1])
+		i++
+	}
+	return string(unescaped)
+}
+
+// uniqueStrings Drops repeated values, keeping the first of each
+func uniqueStrings(values []string) []string {
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,173 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// corednsHostsKey The key of the ConfigMap holding the hosts file
+	corednsHostsKey = "zeroconf.hosts"
+	// corednsRetryInterval How long to wait before retrying a failed ConfigMap update
+	corednsRetryInterval = time.Second * 10
+	// corednsFlushTimeout Bounds the last ConfigMap update on Close
+	corednsFlushTimeout = time.Second * 10
+)
+
+// CorednsPublisher Keeps the published hostnames in a ConfigMap in /etc/hosts format, which
+// the hosts plugin of CoreDNS serves once it is mounted, so that they resolve inside the cluster
+// as well. The ConfigMap is updated in the background, the registry is not held up by the API
+type CorednsPublisher struct {
+	mutex      sync.Mutex
+	configMaps typedv1.ConfigMapInterface
+	namespace  string
+	name       string
+	instances  map[string]ServiceInstance
+	changed    chan struct{}
+	stop       chan struct{}
+	// done Is closed once the sync loop returned
+	done chan struct{}
+}
+
+// NewCorednsPublisher Maintains the ConfigMap namespace/name, which is created if it does not exist.
+// The content of a previous run is kept until the first hostname is published
+func NewCorednsPublisher(clientset kubernetes.Interface, configMap string) (*CorednsPublisher, error) {
+	parts := strings.SplitN(configMap, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("CoreDNS configmap %v is not of the form namespace/name", configMap)
+	}
+	publisher := &CorednsPublisher{
+		configMaps: clientset.CoreV1().ConfigMaps(parts[0]),
+		namespace:  parts[0],
+		name:       parts[1],
+		instances:  map[string]ServiceInstance{},
+		changed:    make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go publisher.syncLoop()
+	return publisher, nil
+}
+
+func (p *CorednsPublisher) Publish(instance ServiceInstance) error {
+	p.mutex.Lock()
+	p.instances[instance.key()] = instance
+	p.mutex.Unlock()
+	p.notify()
+	return nil
+}
+
+func (p *CorednsPublisher) Unpublish(instance ServiceInstance) {
+	p.mutex.Lock()
+	delete(p.instances, instance.key())
+	p.mutex.Unlock()
+	p.notify()
+}
+
+// SetInterfaces Does nothing, the ConfigMap does not depend on the interfaces
+func (p *CorednsPublisher) SetInterfaces(ifaces []net.Interface) error {
+	return nil
+}
+
+// Close Writes the ConfigMap without the instances unpublished before, which shutting down
+// unregisters, then stops updating it
+func (p *CorednsPublisher) Close() {
+	close(p.stop)
+	<-p.done
+}
+
+func (p *CorednsPublisher) notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// syncLoop Writes the hosts file to the ConfigMap whenever it changed until close is called,
+// and a last time then
+func (p *CorednsPublisher) syncLoop() {
+	defer close(p.done)
+	var written []byte
+	for {
+		select {
+		case <-p.stop:
+			p.flush(written)
+			return
+		case <-p.changed:
+		}
+		for {
+			content := p.content()
+			if bytes.Equal(content, written) {
+				break
+			}
+			if err := p.sync(context.TODO(), string(content)); err != nil {
+				log.Errorf("Failed to update CoreDNS configmap %v/%v, retrying in %v: %+v", p.namespace, p.name, corednsRetryInterval, err)
+				select {
+				case <-p.stop:
+					p.flush(written)
+					return
+				case <-time.After(corednsRetryInterval):
+				}
+				continue
+			}
+			log.Debugf("Updated CoreDNS configmap %v/%v", p.namespace, p.name)
+			written = content
+		}
+	}
+}
+
+// flush Writes the hosts file to the ConfigMap unless it is the written one the ConfigMap holds,
+// giving up after corednsFlushTimeout
+func (p *CorednsPublisher) flush(written []byte) {
+	content := p.content()
+	if bytes.Equal(content, written) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), corednsFlushTimeout)
+	defer cancel()
+	if err := p.sync(ctx, string(content)); err != nil {
+		log.Errorf("Failed to update CoreDNS configmap %v/%v before closing: %+v", p.namespace, p.name, err)
+		return
+	}
+	log.Debugf("Updated CoreDNS configmap %v/%v before closing", p.namespace, p.name)
+}
+
+// content Returns the hosts file of the published instances
+func (p *CorednsPublisher) content() []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return hostsFileContent(p.instances)
+}
+
+func (p *CorednsPublisher) sync(ctx context.Context, content string) error {
+	configMap, err := p.configMaps.Get(ctx, p.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: p.name, Namespace: p.namespace},
+			Data:       map[string]string{corednsHostsKey: content},
+		}
+		_, err = p.configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[corednsHostsKey] = content
+	_, err = p.configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
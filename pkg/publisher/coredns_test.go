@@ -0,0 +1,47 @@
+package publisher
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCorednsShutdown(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	coredns, err := NewCorednsPublisher(clientset, "kube-system/zeroconf-hosts")
+	if err != nil {
+		t.Fatalf("NewCorednsPublisher() = %+v", err)
+	}
+	registry := NewRegistry(nil, coredns)
+	ips := []net.IP{net.ParseIP("10.0.0.1")}
+	if err := registry.Register("ingress default/grafana", nil, []hostname.LocalHostname{{Hostname: "grafana"}}, ips); err != nil {
+		t.Fatalf("Register() = %+v", err)
+	}
+	published := "# Written by ingress-frontend-zeroconf, changes are overwritten\n10.0.0.1 grafana.local\n"
+	for deadline := time.Now().Add(time.Second * 5); corednsHosts(t, clientset) != published; time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatalf("ConfigMap = %q, want %q", corednsHosts(t, clientset), published)
+		}
+	}
+
+	registry.UnregisterAll()
+	coredns.Close()
+	want := "# Written by ingress-frontend-zeroconf, changes are overwritten\n"
+	if got := corednsHosts(t, clientset); got != want {
+		t.Errorf("ConfigMap after closing = %q, want %q", got, want)
+	}
+}
+
+func corednsHosts(t *testing.T, clientset *fake.Clientset) string {
+	t.Helper()
+	configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "zeroconf-hosts", metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	return configMap.Data[corednsHostsKey]
+}
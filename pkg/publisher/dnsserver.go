@@ -0,0 +1,162 @@
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+// dnsNegativeTTL How long resolvers cache that a name of the zone does not exist
+const dnsNegativeTTL = 60
+
+// DNSServer Serves the published instances under zone as an authoritative unicast DNS server,
+// for clients that do not resolve names over mDNS. Routers delegating the zone to it make the
+// hostnames resolvable for every client on the LAN
+type DNSServer struct {
+	mutex sync.RWMutex
+	// zone The fully qualified, lower cased zone
+	zone      string
+	instances map[string]ServiceInstance
+	// serial The SOA serial, which changes with every change of the records
+	serial  uint32
+	servers []*dns.Server
+}
+
+// NewDNSServer Listens on address over UDP and TCP, e.g. :53
+func NewDNSServer(address string, zone string) (*DNSServer, error) {
+	server := &DNSServer{
+		zone:      dns.Fqdn(strings.ToLower(zone)),
+		instances: map[string]ServiceInstance{},
+		serial:    uint32(time.Now().Unix()),
+	}
+	for _, network := range []string{"udp", "tcp"} {
+		listener := &dns.Server{Addr: address, Net: network, Handler: server}
+		started := make(chan error, 1)
+		listener.NotifyStartedFunc = func() { started <- nil }
+		go func() {
+			if err := listener.ListenAndServe(); err != nil {
+				started <- err
+			}
+		}()
+		if err := <-started; err != nil {
+			server.Close()
+			return nil, fmt.Errorf("listening on %v over %v: %+v", address, network, err)
+		}
+		server.servers = append(server.servers, listener)
+	}
+	log.Infof("Serving zone %v on %v", server.zone, address)
+	return server, nil
+}
+
+// inZone Returns instance moved from its domain into the zone
+func (s *DNSServer) inZone(instance ServiceInstance) ServiceInstance {
+	instance.Domain = strings.TrimSuffix(s.zone, ".")
+	return instance
+}
+
+func (s *DNSServer) Publish(instance ServiceInstance) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	instance = s.inZone(instance)
+	s.instances[instance.key()] = instance
+	s.serial++
+	return nil
+}
+
+func (s *DNSServer) Unpublish(instance ServiceInstance) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.instances, s.inZone(instance).key())
+	s.serial++
+}
+
+// SetInterfaces Does nothing, the server listens on its address regardless of the interfaces
+func (s *DNSServer) SetInterfaces(ifaces []net.Interface) error {
+	return nil
+}
+
+func (s *DNSServer) Close() {
+	for _, listener := range s.servers {
+		if err := listener.Shutdown(); err != nil {
+			log.Debugf("Failed to shut down DNS server: %+v", err)
+		}
+	}
+}
+
+func (s *DNSServer) ServeDNS(w dns.ResponseWriter, request *dns.Msg) {
+	response := new(dns.Msg)
+	response.SetReply(request)
+	response.Authoritative = true
+	response.RecursionAvailable = false
+	if len(request.Question) != 1 {
+		response.Rcode = dns.RcodeFormatError
+		_ = w.WriteMsg(response)
+		return
+	}
+	question := request.Question[0]
+	name := strings.ToLower(question.Name)
+	if name != s.zone && !strings.HasSuffix(name, "."+s.zone) {
+		response.Rcode = dns.RcodeRefused
+		_ = w.WriteMsg(response)
+		return
+	}
+
+	s.mutex.RLock()
+	answers, extras := answerQuestion(s.instances, question)
+	exists := name == s.zone || len(answers) > 0
+	if !exists {
+		anyAnswers, _ := answerQuestion(s.instances, dns.Question{Name: question.Name, Qtype: dns.TypeANY, Qclass: question.Qclass})
+		exists = len(anyAnswers) > 0
+	}
+	soa := s.soa()
+	s.mutex.RUnlock()
+
+	if name == s.zone && (question.Qtype == dns.TypeSOA || question.Qtype == dns.TypeANY) {
+		answers = append(answers, soa)
+	}
+	response.Answer = unicastRecords(uniqueRecords(answers, nil))
+	response.Extra = unicastRecords(uniqueRecords(extras, response.Answer))
+	if len(response.Answer) == 0 {
+		if !exists {
+			response.Rcode = dns.RcodeNameError
+		}
+		response.Ns = []dns.RR{soa}
+	}
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+		size := dns.MinMsgSize
+		if opt := request.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		response.Truncate(size)
+	}
+	if err := w.WriteMsg(response); err != nil {
+		log.Debugf("Failed to answer DNS query for %v: %+v", question.Name, err)
+	}
+}
+
+// soa Returns the SOA record of the zone, the caller holds the mutex
+func (s *DNSServer) soa() dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: s.zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: dnsNegativeTTL},
+		Ns:      "ns." + s.zone,
+		Mbox:    "hostmaster." + s.zone,
+		Serial:  s.serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  dnsNegativeTTL,
+	}
+}
+
+// unicastRecords Clears the mDNS cache flush bit, which has no place in unicast DNS
+func unicastRecords(records []dns.RR) []dns.RR {
+	for _, record := range records {
+		record.Header().Class &^= cacheFlushBit
+	}
+	return records
+}
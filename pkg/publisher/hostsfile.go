@@ -0,0 +1,128 @@
+package publisher
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HostsFilePublisher Writes the addresses of the published hostnames to an /etc/hosts style
+// file, which dnsmasq reads with addn-hosts, for networks resolving names centrally
+type HostsFilePublisher struct {
+	mutex     sync.Mutex
+	path      string
+	instances map[string]ServiceInstance
+	// pidFile Names the pid file of the dnsmasq that is sent SIGHUP to re-read the file, when set
+	pidFile string
+	written []byte
+}
+
+// NewHostsFilePublisher Keeps the file of a previous run until the first hostname is published,
+// so that the hostnames of a run that crashed stay resolvable while the process restarts. A run
+// that shuts down unregisters its hostnames, which removes them from the file
+func NewHostsFilePublisher(path string, pidFile string) *HostsFilePublisher {
+	return &HostsFilePublisher{path: path, pidFile: pidFile, instances: map[string]ServiceInstance{}}
+}
+
+func (p *HostsFilePublisher) Publish(instance ServiceInstance) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.instances[instance.key()] = instance
+	return p.write()
+}
+
+func (p *HostsFilePublisher) Unpublish(instance ServiceInstance) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.instances, instance.key())
+	if err := p.write(); err != nil {
+		log.Errorf("Failed to write hosts file %v: %+v", p.path, err)
+	}
+}
+
+// SetInterfaces Does nothing, the file does not depend on the interfaces
+func (p *HostsFilePublisher) SetInterfaces(ifaces []net.Interface) error {
+	return nil
+}
+
+// Close Leaves the file as the last Publish or Unpublish wrote it
+func (p *HostsFilePublisher) Close() {
+}
+
+// write Replaces the file when its content changed and reloads dnsmasq, the caller holds the mutex
+func (p *HostsFilePublisher) write() error {
+	content := hostsFileContent(p.instances)
+	if bytes.Equal(content, p.written) {
+		return nil
+	}
+	if err := replaceFile(p.path, content, 0644); err != nil {
+		return err
+	}
+	p.written = content
+	if p.pidFile != "" {
+		p.reload()
+	}
+	return nil
+}
+
+// hostsFileContent Returns the addresses of the hostnames of instances in /etc/hosts format
+func hostsFileContent(instances map[string]ServiceInstance) []byte {
+	hostnames := map[string][]string{}
+	for _, instance := range instances {
+		hostname := instance.Hostname + "." + instance.Domain
+		for _, ip := range instance.IPs {
+			hostnames[ip] = append(hostnames[ip], hostname)
+		}
+	}
+	ips := []string{}
+	for ip := range hostnames {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	var content bytes.Buffer
+	content.WriteString("# Written by ingress-frontend-zeroconf, changes are overwritten\n")
+	for _, ip := range ips {
+		fmt.Fprintf(&content, "%v %v\n", ip, strings.Join(uniqueSortedStrings(hostnames[ip]), " "))
+	}
+	return content.Bytes()
+}
+
+// reload Sends SIGHUP to the dnsmasq of pidFile, which makes it re-read addn-hosts files
+func (p *HostsFilePublisher) reload() {
+	content, err := ioutil.ReadFile(p.pidFile)
+	if err != nil {
+		log.Errorf("Failed to read dnsmasq pid file %v: %+v", p.pidFile, err)
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		log.Errorf("Invalid dnsmasq pid file %v: %+v", p.pidFile, err)
+		return
+	}
+	process, _ := os.FindProcess(pid)
+	if err := process.Signal(syscall.SIGHUP); err != nil {
+		log.Errorf("Failed to reload dnsmasq %v: %+v", pid, err)
+	}
+}
+
+func uniqueSortedStrings(values []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
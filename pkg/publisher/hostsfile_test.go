@@ -0,0 +1,42 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+)
+
+func TestHostsFileShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zeroconf.hosts")
+	previous := "10.0.0.9 stale.local\n"
+	if err := ioutil.WriteFile(path, []byte(previous), 0644); err != nil {
+		t.Fatalf("Failed to write the hosts file of the previous run: %+v", err)
+	}
+	hostsFile := NewHostsFilePublisher(path, "")
+	registry := NewRegistry(nil, hostsFile)
+	assertHostsFile(t, path, previous)
+
+	ips := []net.IP{net.ParseIP("10.0.0.1")}
+	if err := registry.Register("ingress default/grafana", nil, []hostname.LocalHostname{{Hostname: "grafana"}}, ips); err != nil {
+		t.Fatalf("Register() = %+v", err)
+	}
+	assertHostsFile(t, path, "# Written by ingress-frontend-zeroconf, changes are overwritten\n10.0.0.1 grafana.local\n")
+
+	registry.UnregisterAll()
+	hostsFile.Close()
+	assertHostsFile(t, path, "# Written by ingress-frontend-zeroconf, changes are overwritten\n")
+}
+
+func assertHostsFile(t *testing.T, path string, want string) {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the hosts file: %+v", err)
+	}
+	if string(content) != want {
+		t.Errorf("Hosts file = %q, want %q", content, want)
+	}
+}
@@ -0,0 +1,111 @@
+package publisher
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+)
+
+const (
+	// httpCheckRetryInitialDelay How long a hostname that is not served waits to be checked
+	// again, which doubles with every failed check up to httpCheckRetryMaxDelay
+	httpCheckRetryInitialDelay = time.Second
+	httpCheckRetryMaxDelay     = time.Minute * 5
+)
+
+// HTTPCheck Requests hostnames from the addresses they are published with before they
+// are published, so that only hostnames the ingress actually serves are advertised
+type HTTPCheck struct {
+	// Timeout Bounds the request to each address
+	Timeout time.Duration
+}
+
+// NewHTTPCheck Returns a check giving up on an address after timeout
+func NewHTTPCheck(timeout time.Duration) *HTTPCheck {
+	return &HTTPCheck{Timeout: timeout}
+}
+
+// applies Reports whether instance is checked, which HTTP and HTTPS instances are
+func (c *HTTPCheck) applies(instance ServiceInstance) bool {
+	return instance.ServiceType == hostname.ServiceTypeHTTP || instance.ServiceType == hostname.ServiceTypeHTTPS
+}
+
+// check GETs the path of the TXT records of instance from each of its addresses in turn, with
+// the published hostname as Host header and SNI server name. Returns nil once an address
+// serves it, any response but 404 and server errors counts as served, redirects and
+// authentication challenges included. Certificates are not verified, the ingress may serve
+// one the broadcaster does not trust
+func (c *HTTPCheck) check(instance ServiceInstance) error {
+	scheme := "http"
+	if instance.ServiceType == hostname.ServiceTypeHTTPS {
+		scheme = "https"
+	}
+	host := instance.Hostname + "." + instance.Domain
+	url := fmt.Sprintf("%v://%v%v", scheme, net.JoinHostPort(host, strconv.Itoa(instance.Port)), textPath(instance.Text))
+	failures := []string{}
+	for _, ip := range instance.IPs {
+		err := c.get(url, host, net.JoinHostPort(ip, strconv.Itoa(instance.Port)))
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%v: %+v", ip, err))
+	}
+	return fmt.Errorf("GET %v failed from %v", url, strings.Join(failures, ", "))
+}
+
+// get GETs url from the address, reporting an error unless it is served
+func (c *HTTPCheck) get(url string, host string, address string) error {
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	client := &http.Client{
+		Timeout: c.Timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSClientConfig:   &tls.Config{ServerName: host, InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusNotFound || response.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("status %v", response.Status)
+	}
+	return nil
+}
+
+// httpCheckRetryDelay Returns how long to wait before checking a hostname again after the
+// attempt-th failed check, counting from 0
+func httpCheckRetryDelay(attempt int) time.Duration {
+	delay := httpCheckRetryInitialDelay
+	for i := 0; i < attempt && delay < httpCheckRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > httpCheckRetryMaxDelay {
+		delay = httpCheckRetryMaxDelay
+	}
+	return delay
+}
+
+// textPath Returns the path of the path= TXT record, / without one
+func textPath(text []string) string {
+	for _, record := range text {
+		if strings.HasPrefix(record, "path=") && strings.HasPrefix(record[len("path="):], "/") {
+			return record[len("path="):]
+		}
+	}
+	return "/"
+}
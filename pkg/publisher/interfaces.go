@@ -0,0 +1,256 @@
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// interfaceAuto The --interface value selecting the interface automatically
+	interfaceAuto = "auto"
+	// defaultInterface The interface broadcast on when none is selected
+	defaultInterface = "eth0"
+	// interfacePollInterval How often the selected interfaces are checked for changes
+	interfacePollInterval = time.Second * 5
+)
+
+// InterfaceSelection The interfaces to broadcast on, by name, by glob pattern or all of them
+type InterfaceSelection struct {
+	names    []string
+	patterns []string
+	all      bool
+	// excluded Globs of interfaces skipped by patterns and all, docker0 or veth* for example
+	excluded []string
+}
+
+// NewInterfaceSelection Validates the glob patterns, excluded entries may be comma separated
+func NewInterfaceSelection(names []string, patterns []string, all bool, excluded []string) (InterfaceSelection, error) {
+	selection := InterfaceSelection{names: names, patterns: patterns, all: all}
+	for _, entry := range excluded {
+		for _, pattern := range strings.Split(entry, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				selection.excluded = append(selection.excluded, pattern)
+			}
+		}
+	}
+	for _, pattern := range append(append([]string{}, selection.patterns...), selection.excluded...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return selection, fmt.Errorf("Invalid interface pattern %v: %+v", pattern, err)
+		}
+	}
+	if len(selection.names) == 0 && len(selection.patterns) == 0 && !selection.all {
+		selection.names = []string{defaultInterface}
+	}
+	return selection, nil
+}
+
+// Interfaces Returns the named interfaces and those matching a pattern, or every interface
+// that is up and multicast capable with all, leaving out the excluded ones
+func (s InterfaceSelection) Interfaces() ([]net.Interface, error) {
+	selected := []net.Interface{}
+	seen := map[string]bool{}
+	add := func(iface net.Interface) {
+		if !seen[iface.Name] {
+			seen[iface.Name] = true
+			selected = append(selected, iface)
+		}
+	}
+	for _, name := range s.names {
+		iface, err := getInterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		add(iface)
+	}
+	if len(s.patterns) == 0 && !s.all {
+		return selected, nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		if s.isExcluded(iface.Name) {
+			log.Debugf("Skipping excluded interface %v", iface.Name)
+			continue
+		}
+		if s.all && isBroadcastCapable(iface) {
+			add(iface)
+		}
+	}
+	for _, pattern := range s.patterns {
+		matched := false
+		for _, iface := range ifaces {
+			if ok, _ := path.Match(pattern, iface.Name); ok && !s.isExcluded(iface.Name) {
+				log.Debugf("Interface %v matches pattern %v", iface.Name, pattern)
+				matched = true
+				add(iface)
+			}
+		}
+		if !matched {
+			log.Warnf("No interface matches pattern %v", pattern)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("No interface matches %v", s)
+	}
+	return selected, nil
+}
+
+func (s InterfaceSelection) isExcluded(name string) bool {
+	for _, pattern := range s.excluded {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s InterfaceSelection) String() string {
+	selectors := append([]string{}, s.names...)
+	selectors = append(selectors, s.patterns...)
+	if s.all {
+		selectors = append(selectors, "all interfaces")
+	}
+	description := strings.Join(selectors, ", ")
+	if len(s.excluded) > 0 {
+		description += " excluding " + strings.Join(s.excluded, ", ")
+	}
+	return description
+}
+
+// WatchInterfaces Polls the selected interfaces until ctx is done and moves the
+// registrations over whenever one goes up or down, appears, or changes address
+func WatchInterfaces(ctx context.Context, selection InterfaceSelection, current []net.Interface, registry *Registry) {
+	state := interfacesState(current)
+	ticker := time.NewTicker(interfacePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ifaces, err := selection.Interfaces()
+		if err != nil {
+			// A named interface may be gone for a moment, keep the current ones until it returns
+			log.Debugf("Checking interfaces: %+v", err)
+			continue
+		}
+		if newState := interfacesState(ifaces); newState != state {
+			log.Infof("Broadcast interfaces changed from [%v] to [%v], re-announcing", state, newState)
+			state = newState
+			registry.setInterfaces(ifaces)
+		}
+	}
+}
+
+// interfacesState Summarizes the names, flags and addresses of interfaces for comparison
+func interfacesState(ifaces []net.Interface) string {
+	states := []string{}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		addrStrings := []string{}
+		for _, addr := range addrs {
+			addrStrings = append(addrStrings, addr.String())
+		}
+		states = append(states, fmt.Sprintf("%v(%v) %v", iface.Name, iface.Flags, strings.Join(addrStrings, " ")))
+	}
+	return strings.Join(states, ", ")
+}
+
+// getInterfaceByName Looks up an interface, auto selects the interface of the default route
+func getInterfaceByName(interfaceName string) (net.Interface, error) {
+	if interfaceName == interfaceAuto {
+		return detectInterface()
+	}
+	ifaces, _ := net.Interfaces()
+	ifaceNames := []string{}
+	for _, iface := range ifaces {
+		if iface.Name == interfaceName {
+			log.Debugf("Found interface %v", interfaceName)
+			return iface, nil
+		}
+		ifaceNames = append(ifaceNames, iface.Name)
+	}
+	return net.Interface{}, fmt.Errorf("No interface named %v was found, available interfaces are:\n%v", interfaceName, strings.Join(ifaceNames, "\n"))
+}
+
+// detectInterface Returns the interface carrying the IPv4 default route, or the
+// first multicast capable interface that is up and not a loopback
+func detectInterface() (net.Interface, error) {
+	if name, err := defaultRouteInterface(); err == nil {
+		if iface, err := net.InterfaceByName(name); err == nil {
+			log.Infof("Using interface %v of the default route", name)
+			return *iface, nil
+		}
+	} else {
+		log.Debugf("Failed to determine the default route: %+v", err)
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, err
+	}
+	for _, iface := range ifaces {
+		if isBroadcastCapable(iface) {
+			log.Infof("Using multicast capable interface %v", iface.Name)
+			return iface, nil
+		}
+	}
+	return net.Interface{}, fmt.Errorf("No interface that is up and multicast capable was found")
+}
+
+// UpInterfaces Returns the interfaces that are up
+func UpInterfaces(ifaces []net.Interface) []net.Interface {
+	up := []net.Interface{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 {
+			up = append(up, iface)
+		}
+	}
+	return up
+}
+
+func isBroadcastCapable(iface net.Interface) bool {
+	return iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0
+}
+
+// defaultRouteInterface Reads the interface of the IPv4 default route from the Linux routing table
+func defaultRouteInterface() (string, error) {
+	routes, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	defer routes.Close()
+	scanner := bufio.NewScanner(routes)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[1] == "00000000" {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("No default route found")
+}
+
+// HasIPv6Address Reports whether iface can join the IPv6 mDNS multicast group
+func HasIPv6Address(iface net.Interface) bool {
+	addrs, _ := iface.Addrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package publisher
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+// llmnrTTL The TTL of LLMNR answers recommended by RFC 4795 section 2.8
+const llmnrTTL = 30
+
+var (
+	llmnrGroupIPv4 = &net.UDPAddr{IP: net.ParseIP("224.0.0.252"), Port: 5355}
+	llmnrGroupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::1:3"), Port: 5355}
+)
+
+// LLMNRResponder Answers LLMNR queries for the addresses of the published hostnames, which
+// Windows clients without Bonjour resolve names with. Both the bare hostname, e.g. grafana,
+// and the hostname in the domain are answered for
+type LLMNRResponder struct {
+	mutex     sync.Mutex
+	ifaces    []net.Interface
+	instances map[string]ServiceInstance
+	conns     []*multicastConn
+}
+
+// NewLLMNRResponder Listens for LLMNR queries on ifaces
+func NewLLMNRResponder(ifaces []net.Interface) (*LLMNRResponder, error) {
+	responder := &LLMNRResponder{instances: map[string]ServiceInstance{}}
+	if err := responder.listen(ifaces); err != nil {
+		return nil, err
+	}
+	return responder, nil
+}
+
+// listen Joins the LLMNR groups on ifaces and starts answering queries, the caller holds the mutex
+func (r *LLMNRResponder) listen(ifaces []net.Interface) error {
+	r.ifaces = ifaces
+	if len(ifaces) == 0 {
+		return nil
+	}
+	conns, err := listenMulticastGroups(ifaces, llmnrGroupIPv4, llmnrGroupIPv6)
+	if err != nil {
+		return err
+	}
+	r.conns = conns
+	for _, conn := range r.conns {
+		go r.serve(conn)
+	}
+	return nil
+}
+
+// serve Answers the queries arriving on conn until it is closed
+func (r *LLMNRResponder) serve(conn *multicastConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, ifIndex, src, err := conn.read(buf)
+		if err != nil {
+			if !r.isOpen(conn) {
+				return
+			}
+			log.Debugf("Failed to read LLMNR packet: %+v", err)
+			continue
+		}
+		query := new(dns.Msg)
+		if err := query.Unpack(buf[:n]); err != nil {
+			log.Debugf("Ignoring malformed LLMNR packet: %+v", err)
+			continue
+		}
+		// RFC 4795 section 2.1.1 has queries carry exactly one question
+		if query.Response || query.Opcode != dns.OpcodeQuery || len(query.Question) != 1 {
+			continue
+		}
+		r.mutex.Lock()
+		response := r.answer(ifIndex, query)
+		r.mutex.Unlock()
+		if response == nil {
+			continue
+		}
+		packed, err := response.Pack()
+		if err != nil {
+			log.Errorf("Failed to pack LLMNR response: %+v", err)
+			continue
+		}
+		// Responses go to the sender by unicast, RFC 4795 section 2.4
+		if _, err := conn.conn.WriteTo(packed, src); err != nil {
+			log.Debugf("Failed to answer LLMNR query of %v: %+v", src, err)
+		}
+	}
+}
+
+func (r *LLMNRResponder) isOpen(conn *multicastConn) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, open := range r.conns {
+		if open == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// answer Returns the response to query, or nil when it is not about one of our hostnames or
+// arrived on an interface other than ours. The caller holds the mutex
+func (r *LLMNRResponder) answer(ifIndex int, query *dns.Msg) *dns.Msg {
+	ours := false
+	for _, iface := range r.ifaces {
+		ours = ours || iface.Index == ifIndex
+	}
+	if !ours {
+		return nil
+	}
+	question := query.Question[0]
+	name := strings.TrimSuffix(question.Name, ".")
+	authoritative := false
+	answers := []dns.RR{}
+	for _, instance := range r.instances {
+		if !strings.EqualFold(name, instance.Hostname) && !strings.EqualFold(name, instance.Hostname+"."+instance.Domain) {
+			continue
+		}
+		authoritative = true
+		instance.AddressTTL = llmnrTTL
+		for _, record := range newInstanceRecords(instance, llmnrTTL).addresses {
+			record.Header().Name = question.Name
+			record.Header().Class = dns.ClassINET
+			answers = append(answers, record)
+		}
+	}
+	if !authoritative {
+		return nil
+	}
+	response := new(dns.Msg)
+	response.SetReply(query)
+	response.RecursionDesired = false
+	response.Answer = uniqueRecords(matchingRecords(answers, question.Qtype), nil)
+	return response
+}
+
+func (r *LLMNRResponder) Publish(instance ServiceInstance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.instances[instance.key()] = instance
+	return nil
+}
+
+func (r *LLMNRResponder) Unpublish(instance ServiceInstance) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.instances, instance.key())
+}
+
+func (r *LLMNRResponder) SetInterfaces(ifaces []net.Interface) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closeConns()
+	return r.listen(ifaces)
+}
+
+func (r *LLMNRResponder) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closeConns()
+}
+
+// closeConns Closes the sockets, which stops their serve loops. The caller holds the mutex
+func (r *LLMNRResponder) closeConns() {
+	conns := r.conns
+	r.conns = nil
+	for _, conn := range conns {
+		conn.conn.Close()
+	}
+}
@@ -0,0 +1,42 @@
+package publisher
+
+import (
+	"net"
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	log "github.com/sirupsen/logrus"
+)
+
+// OwnerFields Returns the namespace and name of an owner such as "ingress default/grafana" as
+// log fields, the name under the kind of the owner, e.g. ingress=grafana. The cluster of owners
+// such as "ingress default/grafana (prod)" is a field too
+func OwnerFields(owner string) log.Fields {
+	fields := log.Fields{}
+	if open := strings.LastIndex(owner, " ("); open >= 0 && strings.HasSuffix(owner, ")") {
+		fields["cluster"] = owner[open+2 : len(owner)-1]
+		owner = owner[:open]
+	}
+	parts := strings.SplitN(owner, " ", 2)
+	if len(parts) != 2 {
+		return fields
+	}
+	kind, key := parts[0], parts[1]
+	if slash := strings.Index(key, "/"); slash >= 0 {
+		fields["namespace"] = key[:slash]
+		key = key[slash+1:]
+	}
+	fields[kind] = key
+	return fields
+}
+
+// claimFields Returns the log fields of the hostname, owner and addresses of a registration,
+// owner may be empty
+func (r *Registry) claimFields(owner string, local hostname.LocalHostname, ips []net.IP) log.Fields {
+	fields := OwnerFields(owner)
+	fields["hostname"] = local.Hostname + "." + r.Domain
+	if len(ips) > 0 {
+		fields["ip"] = strings.Join(hostname.IPStrings(ips), ",")
+	}
+	return fields
+}
@@ -0,0 +1,163 @@
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// defaultRecordTTL The TTL of PTR, SRV and TXT records
+	defaultRecordTTL = 3200
+	// addressRecordTTL The TTL of A and AAAA records recommended by RFC 6762
+	addressRecordTTL = 120
+	// legacyRecordTTL The TTL records are capped at in answers to legacy unicast queries, RFC 6762 section 6.7
+	legacyRecordTTL = 10
+	// cacheFlushBit Marks records of which the receivers should drop other cached data, RFC 6762 section 10.2
+	cacheFlushBit = 1 << 15
+)
+
+var (
+	mdnsGroupIPv4 = &net.UDPAddr{IP: net.ParseIP("224.0.0.251"), Port: 5353}
+	mdnsGroupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+// openMulticastSenders Opens an IPv4 and an IPv6 socket on an ephemeral port, send
+// multicasts a packet to the mDNS group of both families on each interface
+func openMulticastSenders(ifaces []net.Interface) ([]*net.UDPConn, func(packet []byte)) {
+	conns := []*net.UDPConn{}
+	sends := []func(packet []byte){}
+	if conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero}); err == nil {
+		packetConn := ipv4.NewPacketConn(conn)
+		conns = append(conns, conn)
+		sends = append(sends, func(packet []byte) {
+			for i := range ifaces {
+				if err := packetConn.SetMulticastInterface(&ifaces[i]); err == nil {
+					_, _ = conn.WriteTo(packet, mdnsGroupIPv4)
+				}
+			}
+		})
+	} else {
+		log.Debugf("Not sending over IPv4: %+v", err)
+	}
+	if conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified}); err == nil {
+		packetConn := ipv6.NewPacketConn(conn)
+		conns = append(conns, conn)
+		sends = append(sends, func(packet []byte) {
+			for i := range ifaces {
+				if err := packetConn.SetMulticastInterface(&ifaces[i]); err == nil {
+					_, _ = conn.WriteTo(packet, &net.UDPAddr{IP: mdnsGroupIPv6.IP, Port: mdnsGroupIPv6.Port, Zone: ifaces[i].Name})
+				}
+			}
+		})
+	} else {
+		log.Debugf("Not sending over IPv6: %+v", err)
+	}
+	return conns, func(packet []byte) {
+		for _, send := range sends {
+			send(packet)
+		}
+	}
+}
+
+// instanceRecords The records of a service instance
+type instanceRecords struct {
+	ptr       dns.RR
+	srv       dns.RR
+	txt       dns.RR
+	addresses []dns.RR
+}
+
+// newInstanceRecords Returns the records of instance, a ttl of 0 makes goodbyes of them. Other than
+// goodbyes, the records set the cache flush bit on the unique records, which are all but the shared
+// PTR record
+func newInstanceRecords(instance ServiceInstance, ttl uint32) instanceRecords {
+	service := instance.ServiceType + "." + instance.Domain + "."
+	name := escapeLabel(instance.Instance) + "." + service
+	host := instance.Hostname + "." + instance.Domain + "."
+	addressTTL := ttl
+	uniqueClass := uint16(dns.ClassINET)
+	if ttl > 0 {
+		addressTTL = instance.AddressTTL
+		if addressTTL == 0 {
+			addressTTL = addressRecordTTL
+		}
+		uniqueClass |= cacheFlushBit
+	}
+	records := instanceRecords{
+		ptr: &dns.PTR{Hdr: dns.RR_Header{Name: service, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}, Ptr: name},
+		srv: &dns.SRV{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: uniqueClass, Ttl: ttl}, Priority: instance.Priority, Weight: instance.Weight, Port: uint16(instance.Port), Target: host},
+		txt: &dns.TXT{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: uniqueClass, Ttl: ttl}, Txt: instance.Text},
+	}
+	for _, rawIP := range instance.IPs {
+		ip := net.ParseIP(rawIP)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			records.addresses = append(records.addresses, &dns.A{Hdr: dns.RR_Header{Name: host, Rrtype: dns.TypeA, Class: uniqueClass, Ttl: addressTTL}, A: ip})
+		} else {
+			records.addresses = append(records.addresses, &dns.AAAA{Hdr: dns.RR_Header{Name: host, Rrtype: dns.TypeAAAA, Class: uniqueClass, Ttl: addressTTL}, AAAA: ip})
+		}
+	}
+	return records
+}
+
+// all Returns the records of the instance, the address records last
+func (records instanceRecords) all() []dns.RR {
+	return append([]dns.RR{records.ptr, records.srv, records.txt}, records.addresses...)
+}
+
+// reverseRecords Returns the in-addr.arpa and ip6.arpa PTR records pointing the addresses of
+// records at their host. They are shared records without the cache flush bit, as the hostnames
+// of all ingresses of a LoadBalancer share its addresses
+func (records instanceRecords) reverseRecords() []dns.RR {
+	reverse := []dns.RR{}
+	for _, address := range records.addresses {
+		var ip net.IP
+		switch record := address.(type) {
+		case *dns.A:
+			ip = record.A
+		case *dns.AAAA:
+			ip = record.AAAA
+		}
+		name, err := dns.ReverseAddr(ip.String())
+		if err != nil {
+			continue
+		}
+		header := address.Header()
+		reverse = append(reverse, &dns.PTR{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: header.Ttl}, Ptr: header.Name})
+	}
+	return reverse
+}
+
+// escapeLabel Escapes an instance name, which may contain dots and spaces, into a single
+// label the way miekg/dns presents names it unpacks
+func escapeLabel(label string) string {
+	escaped := make([]byte, 0, len(label))
+	for _, b := range []byte(label) {
+		switch {
+		case strings.IndexByte(".();@\" \\", b) >= 0:
+			escaped = append(escaped, '\\', b)
+		case b < ' ' || b > '~':
+			escaped = append(escaped, fmt.Sprintf("\\%03d", b)...)
+		default:
+			escaped = append(escaped, b)
+		}
+	}
+	return string(escaped)
+}
+
+// newResponse Returns an unsolicited response with the given answers
+func newResponse(answers []dns.RR) *dns.Msg {
+	response := new(dns.Msg)
+	response.Response = true
+	response.Authoritative = true
+	response.Answer = answers
+	return response
+}
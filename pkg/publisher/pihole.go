@@ -0,0 +1,249 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// piholeTimeout Bounds every request to the Pi-hole admin API
+	piholeTimeout = time.Second * 10
+	// piholeRetryInterval How long to wait before retrying failed updates
+	piholeRetryInterval = time.Second * 10
+)
+
+// piholeRecord A local DNS record of Pi-hole, which maps a domain to one address
+type piholeRecord struct {
+	domain string
+	ip     string
+}
+
+// PiholePublisher Keeps the local DNS records of a Pi-hole in line with the published hostnames
+// through its admin API, in the background so that the registry is not held up by it. Only
+// records added by this process, or by a previous run read from the state file, are removed
+type PiholePublisher struct {
+	mutex     sync.Mutex
+	client    *http.Client
+	endpoint  string
+	token     string
+	instances map[string]ServiceInstance
+	// pushed The records Pi-hole is believed to hold on behalf of this process
+	pushed  map[piholeRecord]bool
+	changed chan struct{}
+	stop    chan struct{}
+	// done Is closed once the sync loop returned
+	done chan struct{}
+}
+
+// NewPiholePublisher Uses the admin API at baseURL, e.g. http://pi.hole, authenticating with the
+// API token read from tokenFile
+func NewPiholePublisher(baseURL string, tokenFile string) (*PiholePublisher, error) {
+	token := ""
+	if tokenFile != "" {
+		content, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading Pi-hole token: %+v", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, fmt.Errorf("parsing Pi-hole url %v: %+v", baseURL, err)
+	}
+	publisher := &PiholePublisher{
+		client:    &http.Client{Timeout: piholeTimeout},
+		endpoint:  strings.TrimSuffix(baseURL, "/") + "/admin/api.php",
+		token:     token,
+		instances: map[string]ServiceInstance{},
+		pushed:    map[piholeRecord]bool{},
+		changed:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go publisher.syncLoop()
+	return publisher, nil
+}
+
+func (p *PiholePublisher) Publish(instance ServiceInstance) error {
+	p.mutex.Lock()
+	p.instances[instance.key()] = instance
+	p.mutex.Unlock()
+	p.notify()
+	return nil
+}
+
+// Unpublish Removes the records of instance, those of a previous run included
+func (p *PiholePublisher) Unpublish(instance ServiceInstance) {
+	p.mutex.Lock()
+	if _, exists := p.instances[instance.key()]; exists {
+		delete(p.instances, instance.key())
+	} else {
+		for _, record := range piholeRecords(instance) {
+			p.pushed[record] = true
+		}
+	}
+	p.mutex.Unlock()
+	p.notify()
+}
+
+// SetInterfaces Does nothing, the records do not depend on the interfaces
+func (p *PiholePublisher) SetInterfaces(ifaces []net.Interface) error {
+	return nil
+}
+
+// Close Removes the records of the instances unpublished before, which shutting down
+// unregisters, then stops updating Pi-hole
+func (p *PiholePublisher) Close() {
+	close(p.stop)
+	<-p.done
+}
+
+func (p *PiholePublisher) notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+func piholeRecords(instance ServiceInstance) []piholeRecord {
+	records := []piholeRecord{}
+	for _, ip := range instance.IPs {
+		records = append(records, piholeRecord{domain: instance.Hostname + "." + instance.Domain, ip: ip})
+	}
+	return records
+}
+
+// syncLoop Adds and removes records whenever the published instances changed until close is
+// called, and a last time then
+func (p *PiholePublisher) syncLoop() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.stop:
+			p.flush()
+			return
+		case <-p.changed:
+		}
+		for !p.sync() {
+			log.Errorf("Failed to update Pi-hole, retrying in %v", piholeRetryInterval)
+			select {
+			case <-p.stop:
+				p.flush()
+				return
+			case <-time.After(piholeRetryInterval):
+			}
+		}
+	}
+}
+
+// flush Brings the records of Pi-hole in line with the instances once more, without retrying
+func (p *PiholePublisher) flush() {
+	if !p.sync() {
+		log.Errorf("Failed to update Pi-hole before closing")
+	}
+}
+
+// sync Brings the records of Pi-hole in line with the instances, reports whether all updates succeeded
+func (p *PiholePublisher) sync() bool {
+	p.mutex.Lock()
+	desired := map[piholeRecord]bool{}
+	for _, instance := range p.instances {
+		for _, record := range piholeRecords(instance) {
+			desired[record] = true
+		}
+	}
+	added, removed := []piholeRecord{}, []piholeRecord{}
+	for record := range desired {
+		if !p.pushed[record] {
+			added = append(added, record)
+		}
+	}
+	for record := range p.pushed {
+		if !desired[record] {
+			removed = append(removed, record)
+		}
+	}
+	p.mutex.Unlock()
+
+	succeeded := true
+	for _, record := range sortedPiholeRecords(removed) {
+		if err := p.call("delete", record); err != nil {
+			log.Errorf("Failed to remove %v %v from Pi-hole: %+v", record.domain, record.ip, err)
+			succeeded = false
+			continue
+		}
+		log.Debugf("Removed %v %v from Pi-hole", record.domain, record.ip)
+		p.mutex.Lock()
+		delete(p.pushed, record)
+		p.mutex.Unlock()
+	}
+	for _, record := range sortedPiholeRecords(added) {
+		if err := p.call("add", record); err != nil {
+			log.Errorf("Failed to add %v %v to Pi-hole: %+v", record.domain, record.ip, err)
+			succeeded = false
+			continue
+		}
+		log.Debugf("Added %v %v to Pi-hole", record.domain, record.ip)
+		p.mutex.Lock()
+		p.pushed[record] = true
+		p.mutex.Unlock()
+	}
+	return succeeded
+}
+
+func sortedPiholeRecords(records []piholeRecord) []piholeRecord {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].domain != records[j].domain {
+			return records[i].domain < records[j].domain
+		}
+		return records[i].ip < records[j].ip
+	})
+	return records
+}
+
+// call Runs a customdns action of the admin API, adding what exists or removing what does
+// not is no error
+func (p *PiholePublisher) call(action string, record piholeRecord) error {
+	query := url.Values{}
+	query.Set("customdns", "")
+	query.Set("action", action)
+	query.Set("domain", record.domain)
+	query.Set("ip", record.ip)
+	query.Set("auth", p.token)
+	response, err := p.client.Get(p.endpoint + "?" + query.Encode())
+	if err != nil {
+		// The url of the error holds the token, which is not to end up in the logs
+		if urlErr, ok := err.(*url.Error); ok {
+			return fmt.Errorf("%v %v: %+v", urlErr.Op, p.endpoint, urlErr.Err)
+		}
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Pi-hole answered %v", response.Status)
+	}
+	var result struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding Pi-hole response: %+v", err)
+	}
+	if result.Success {
+		return nil
+	}
+	message := strings.ToLower(result.Message)
+	if (action == "add" && strings.Contains(message, "already")) || (action == "delete" && strings.Contains(message, "does not exist")) {
+		return nil
+	}
+	return fmt.Errorf("Pi-hole refused to %v: %v", action, result.Message)
+}
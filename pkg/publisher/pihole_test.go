@@ -0,0 +1,112 @@
+package publisher
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+)
+
+const testPiholeToken = "0123456789abcdef"
+
+func newTestPiholePublisher(t *testing.T, baseURL string) *PiholePublisher {
+	t.Helper()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte(testPiholeToken+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write the token file: %+v", err)
+	}
+	publisher, err := NewPiholePublisher(baseURL, tokenFile)
+	if err != nil {
+		t.Fatalf("NewPiholePublisher() = %+v", err)
+	}
+	return publisher
+}
+
+func TestPiholeCall(t *testing.T) {
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{"success": true, "message": ""}`))
+	}))
+	defer server.Close()
+	publisher := newTestPiholePublisher(t, server.URL)
+	defer publisher.Close()
+	if err := publisher.call("add", piholeRecord{domain: "grafana.local", ip: "10.0.0.1"}); err != nil {
+		t.Fatalf("call() = %+v", err)
+	}
+	for key, want := range map[string]string{"action": "add", "domain": "grafana.local", "ip": "10.0.0.1", "auth": testPiholeToken} {
+		if got := query[key]; len(got) != 1 || got[0] != want {
+			t.Errorf("call() sent %v=%v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestPiholeCallErrorWithoutToken(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	publisher := newTestPiholePublisher(t, server.URL)
+	defer publisher.Close()
+	err := publisher.call("add", piholeRecord{domain: "grafana.local", ip: "10.0.0.1"})
+	if err == nil {
+		t.Fatalf("call() to a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), testPiholeToken) {
+		t.Errorf("call() = %v, which holds the token", err)
+	}
+	if !strings.Contains(err.Error(), publisher.endpoint) {
+		t.Errorf("call() = %v, want the endpoint %v in it", err, publisher.endpoint)
+	}
+}
+
+func TestPiholeShutdown(t *testing.T) {
+	var mutex sync.Mutex
+	records := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		mutex.Lock()
+		defer mutex.Unlock()
+		record := query.Get("domain") + " " + query.Get("ip")
+		if query.Get("action") == "add" {
+			records[record] = true
+		} else {
+			delete(records, record)
+		}
+		w.Write([]byte(`{"success": true, "message": ""}`))
+	}))
+	defer server.Close()
+	piholeRecords := func() map[string]bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		copied := map[string]bool{}
+		for record := range records {
+			copied[record] = true
+		}
+		return copied
+	}
+	pihole := newTestPiholePublisher(t, server.URL)
+	registry := NewRegistry(nil, pihole)
+	ips := []net.IP{net.ParseIP("10.0.0.1")}
+	if err := registry.Register("ingress default/grafana", nil, []hostname.LocalHostname{{Hostname: "grafana"}}, ips); err != nil {
+		t.Fatalf("Register() = %+v", err)
+	}
+	published := map[string]bool{"grafana.local 10.0.0.1": true}
+	for deadline := time.Now().Add(time.Second * 5); !reflect.DeepEqual(piholeRecords(), published); time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Pi-hole records = %v, want %v", piholeRecords(), published)
+		}
+	}
+
+	registry.UnregisterAll()
+	pihole.Close()
+	if got := piholeRecords(); len(got) != 0 {
+		t.Errorf("Pi-hole records after closing = %v, want none", got)
+	}
+}
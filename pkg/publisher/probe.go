@@ -0,0 +1,285 @@
+package publisher
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// probeCount and probeInterval follow the three probes 250ms apart of RFC 6762 section 8.1,
+	// which are sent after a random delay of up to probeInterval
+	probeCount    = 3
+	probeInterval = time.Millisecond * 250
+	// probeDeferral How long a probe that lost the tiebreak against the simultaneous probe of
+	// another host waits before probing again, RFC 6762 section 8.2
+	probeDeferral = time.Second
+	// probeMaxDeferrals How often a probe defers before the name counts as in use, a host
+	// winning the tiebreaks but never announcing the name would otherwise hold it back forever
+	probeMaxDeferrals = 10
+	// probeConflictBackoff How long a hostname found in use is not probed again
+	probeConflictBackoff = time.Minute * 5
+	// probeRenameAttempts How many numbered alternatives like host-2 are probed when renaming
+	probeRenameAttempts = 9
+)
+
+const (
+	// ProbeOff Publishes hostnames without probing
+	ProbeOff = "off"
+	// ProbeSkip Does not publish hostnames another responder answers for
+	ProbeSkip = "skip"
+	// ProbeRename Publishes hostnames another responder answers for as host-2, host-3, ...
+	ProbeRename = "rename"
+)
+
+// MDNSProber Probes for hostnames from port 5353 as RFC 6762 section 8 describes, sending
+// queries with the unicast-response bit set and the proposed address records in the authority
+// section, so that other hosts probing for the same name at the same time break the tie
+type MDNSProber struct {
+	mutex sync.Mutex
+	// responderConns Returns the sockets of the responder the probes are sent over and
+	// whose packets it hands to receive, nil without a responder
+	responderConns func() []*multicastConn
+	// probes The probes running
+	probes map[*hostnameProbe]bool
+}
+
+// hostnameProbe A probe for a name, updated by receive
+type hostnameProbe struct {
+	fqdn string
+	// proposed The address records of the name, sorted for the tiebreak
+	proposed []dns.RR
+	own      []net.IP
+	// conflicting The addresses other responders answered for the name with
+	conflicting []net.IP
+	// lost Set when a simultaneous probe of another host won the tiebreak
+	lost bool
+}
+
+// NewMDNSProber Returns a prober joining the mDNS groups for the duration of each probe, for
+// when another daemon such as avahi-daemon holds port 5353 and answers for the hostnames
+func NewMDNSProber() *MDNSProber {
+	return &MDNSProber{probes: map[*hostnameProbe]bool{}}
+}
+
+// Probe Probes for fqdn on ifaces, proposing to publish it with ips, and returns the
+// addresses other responders answer for it with. Addresses in own are ours and not a
+// conflict. A probe losing the tiebreak against that of another host defers and probes again
+func (p *MDNSProber) Probe(ifaces []net.Interface, fqdn string, ips []net.IP, own []net.IP) []net.IP {
+	probe := &hostnameProbe{fqdn: dns.Fqdn(fqdn), proposed: probeRecords(dns.Fqdn(fqdn), ips), own: own, conflicting: []net.IP{}}
+	query := new(dns.Msg)
+	query.Question = []dns.Question{{Name: probe.fqdn, Qtype: dns.TypeANY, Qclass: dns.ClassINET | cacheFlushBit}}
+	query.Ns = probe.proposed
+	packed, err := query.Pack()
+	if err != nil {
+		log.Errorf("Failed to pack probe for %v: %+v", fqdn, err)
+		return nil
+	}
+
+	var conns []*multicastConn
+	if p.responderConns != nil {
+		conns = p.responderConns()
+	}
+	if len(conns) == 0 {
+		if conns, err = listenMulticastGroups(ifaces, mdnsGroupIPv4, mdnsGroupIPv6); err != nil {
+			log.Warnf("Not probing for %v: %+v", fqdn, err)
+			return nil
+		}
+		defer func() {
+			for _, conn := range conns {
+				conn.conn.Close()
+			}
+		}()
+		for _, conn := range conns {
+			go p.serve(conn)
+		}
+	}
+
+	p.mutex.Lock()
+	p.probes[probe] = true
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.probes, probe)
+		p.mutex.Unlock()
+	}()
+	time.Sleep(time.Duration(rand.Int63n(int64(probeInterval))))
+	for deferrals := 0; ; deferrals++ {
+		for i := 0; i < probeCount && !p.hasLost(probe); i++ {
+			for _, conn := range conns {
+				for j := range ifaces {
+					if err := conn.send(packed, &ifaces[j]); err != nil {
+						log.Debugf("Failed to send probe for %v on %v: %+v", fqdn, ifaces[j].Name, err)
+					}
+				}
+			}
+			time.Sleep(probeInterval)
+		}
+		p.mutex.Lock()
+		lost := probe.lost
+		probe.lost = false
+		conflicting := append([]net.IP{}, probe.conflicting...)
+		p.mutex.Unlock()
+		if !lost || len(conflicting) > 0 {
+			return conflicting
+		}
+		if deferrals == probeMaxDeferrals {
+			log.Warnf("Another host keeps probing for %v without announcing it, counting it as in use", fqdn)
+			return []net.IP{}
+		}
+		log.Debugf("Another host is probing for %v too, probing again in %v", fqdn, probeDeferral)
+		time.Sleep(probeDeferral)
+	}
+}
+
+func (p *MDNSProber) hasLost(probe *hostnameProbe) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return probe.lost
+}
+
+// serve Hands the packets arriving on conn to receive until it is closed
+func (p *MDNSProber) serve(conn *multicastConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, _, _, err := conn.read(buf)
+		if err != nil {
+			return
+		}
+		msg := new(dns.Msg)
+		if err := msg.Unpack(buf[:n]); err == nil {
+			p.receive(msg)
+		}
+	}
+}
+
+// receive Records the addresses a response answers for a probed name with, and the probes of
+// other hosts for it that win the tiebreak
+func (p *MDNSProber) receive(msg *dns.Msg) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for probe := range p.probes {
+		if msg.Response {
+			probe.addConflicting(append(msg.Answer, msg.Extra...))
+		} else if isProbeFor(msg, probe.fqdn) && compareProbeRecords(probe.proposed, namedRecords(msg.Ns, probe.fqdn)) < 0 {
+			probe.lost = true
+		}
+	}
+}
+
+// addConflicting Adds the addresses of the A and AAAA records for the probed name that are not ours
+func (probe *hostnameProbe) addConflicting(records []dns.RR) {
+	for _, record := range namedRecords(records, probe.fqdn) {
+		var ip net.IP
+		switch address := record.(type) {
+		case *dns.A:
+			ip = address.A
+		case *dns.AAAA:
+			ip = address.AAAA
+		default:
+			continue
+		}
+		if !containsIP(probe.own, ip) && !containsIP(probe.conflicting, ip) {
+			probe.conflicting = append(probe.conflicting, ip)
+		}
+	}
+}
+
+// isProbeFor Reports whether query is a probe for fqdn, a query for it proposing records in the
+// authority section
+func isProbeFor(query *dns.Msg, fqdn string) bool {
+	if len(namedRecords(query.Ns, fqdn)) == 0 {
+		return false
+	}
+	for _, question := range query.Question {
+		if strings.EqualFold(question.Name, fqdn) {
+			return true
+		}
+	}
+	return false
+}
+
+// namedRecords Returns the records named fqdn
+func namedRecords(records []dns.RR, fqdn string) []dns.RR {
+	named := []dns.RR{}
+	for _, record := range records {
+		if strings.EqualFold(record.Header().Name, fqdn) {
+			named = append(named, record)
+		}
+	}
+	return named
+}
+
+// probeRecords Returns the address records proposed for fqdn in probes, sorted for the tiebreak
+func probeRecords(fqdn string, ips []net.IP) []dns.RR {
+	records := []dns.RR{}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			records = append(records, &dns.A{Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: addressRecordTTL}, A: ip.To4()})
+		} else {
+			records = append(records, &dns.AAAA{Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: addressRecordTTL}, AAAA: ip})
+		}
+	}
+	return sortProbeRecords(records)
+}
+
+// sortProbeRecords Sorts records by class, type and data, the order of the tiebreak
+func sortProbeRecords(records []dns.RR) []dns.RR {
+	sort.SliceStable(records, func(i, j int) bool {
+		return compareProbeRecord(records[i], records[j]) < 0
+	})
+	return records
+}
+
+// compareProbeRecords Breaks the tie between our proposed records and those of a simultaneous
+// probe of another host, RFC 6762 section 8.2. They are compared in order, by class without
+// the cache-flush bit, type and data, and the host with the lexicographically later records
+// wins. Returns a negative number when ours lose, 0 when they are the same, e.g. for our own
+// probe looped back, and a positive number when ours win
+func compareProbeRecords(ours []dns.RR, theirs []dns.RR) int {
+	theirs = sortProbeRecords(append([]dns.RR{}, theirs...))
+	for i := 0; i < len(ours) && i < len(theirs); i++ {
+		if compared := compareProbeRecord(ours[i], theirs[i]); compared != 0 {
+			return compared
+		}
+	}
+	return len(ours) - len(theirs)
+}
+
+// compareProbeRecord Compares two records by class without the cache-flush bit, type and the
+// raw data without name compression
+func compareProbeRecord(a dns.RR, b dns.RR) int {
+	if classA, classB := a.Header().Class&^cacheFlushBit, b.Header().Class&^cacheFlushBit; classA != classB {
+		return int(classA) - int(classB)
+	}
+	if a.Header().Rrtype != b.Header().Rrtype {
+		return int(a.Header().Rrtype) - int(b.Header().Rrtype)
+	}
+	return bytes.Compare(rdata(a), rdata(b))
+}
+
+// rdata Returns the data of record packed without name compression, nil when it does not pack
+func rdata(record dns.RR) []byte {
+	packed := make([]byte, dns.Len(record))
+	off, err := dns.PackRR(record, packed, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	return packed[off-int(record.Header().Rdlength) : off]
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
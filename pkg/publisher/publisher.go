@@ -0,0 +1,94 @@
+// Package publisher Keeps the registry of hostnames claimed by their owners and publishes them
+// over mDNS and DNS-SD, through avahi or systemd-resolved, or the other supported backends
+package publisher
+
+import (
+	"net"
+	"strings"
+)
+
+const (
+	// PublisherMDNS Publishes through the built-in mDNS responder
+	PublisherMDNS = "mdns"
+	// PublisherAvahi Publishes through the avahi-daemon of the host
+	PublisherAvahi = "avahi"
+	// PublisherResolved Publishes the services through systemd-resolved on the host
+	PublisherResolved = "resolved"
+)
+
+// ServiceInstance A published DNS-SD instance, as handed to the publisher and persisted in the state file
+type ServiceInstance struct {
+	Instance    string   `json:"instance"`
+	ServiceType string   `json:"serviceType"`
+	Domain      string   `json:"domain"`
+	Hostname    string   `json:"hostname"`
+	Port        int      `json:"port"`
+	Priority    uint16   `json:"priority"`
+	Weight      uint16   `json:"weight"`
+	Text        []string `json:"txt"`
+	IPs         []string `json:"ips"`
+	// TTL The TTL of the PTR, SRV and TXT records, AddressTTL that of the A and AAAA records
+	TTL        uint32 `json:"ttl"`
+	AddressTTL uint32 `json:"addressTTL"`
+	// SSDPDescription The path of the UPnP device description, if the instance is advertised over SSDP
+	SSDPDescription string `json:"ssdpDescription,omitempty"`
+	// WSDiscoveryTypes The space separated types, if the instance is advertised over WS-Discovery
+	WSDiscoveryTypes string `json:"wsDiscoveryTypes,omitempty"`
+}
+
+func (instance ServiceInstance) key() string {
+	return instance.Instance + "." + instance.ServiceType + "." + instance.Domain
+}
+
+// hostKey Returns the lower cased host name, which mDNS compares case insensitively
+func (instance ServiceInstance) hostKey() string {
+	return strings.ToLower(instance.Hostname + "." + instance.Domain)
+}
+
+// Publisher Makes service instances discoverable on the network
+type Publisher interface {
+	// Publish Announces instance and answers for it until it is unpublished, publishing
+	// an instance with the key of a published one replaces it
+	Publish(instance ServiceInstance) error
+	// Unpublish Withdraws instance, which need not have been published by this process
+	Unpublish(instance ServiceInstance)
+	// SetInterfaces Moves the published instances onto other interfaces
+	SetInterfaces(ifaces []net.Interface) error
+	// Close Stops answering for the published instances, which Unpublish withdraws first
+	Close()
+}
+
+// Publishers Publishes through each of several publishers, returning the first error
+type Publishers []Publisher
+
+func (p Publishers) Publish(instance ServiceInstance) error {
+	var first error
+	for _, publisher := range p {
+		if err := publisher.Publish(instance); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (p Publishers) Unpublish(instance ServiceInstance) {
+	for _, publisher := range p {
+		publisher.Unpublish(instance)
+	}
+}
+
+func (p Publishers) SetInterfaces(ifaces []net.Interface) error {
+	var first error
+	for _, publisher := range p {
+		if err := publisher.SetInterfaces(ifaces); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (p Publishers) Close() {
+	for _, publisher := range p {
+		publisher.Close()
+	}
+}
@@ -0,0 +1,192 @@
+package publisher
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// reflectedPacketMemory How long a relayed packet is remembered, the copy of it that comes
+	// back on the interface it was relayed to is not relayed again
+	reflectedPacketMemory = time.Millisecond * 500
+	// localAddressRefresh How often the addresses of this host are looked up again
+	localAddressRefresh = time.Second * 30
+)
+
+// MDNSReflector Relays mDNS packets between the interfaces of separate network segments, e.g. an
+// IoT or guest VLAN, so their clients discover the published hostnames. It relays the
+// responses sent from this host and the queries they answer, or every mDNS packet with all
+type MDNSReflector struct {
+	mutex  sync.Mutex
+	ifaces []net.Interface
+	all    bool
+	// answers Reports whether this host answers for a name, the queries for other names are
+	// not relayed without all. Every query is relayed when it is nil
+	answers func(name string) bool
+	conns   []*multicastConn
+	// relayed When the packets relayed within reflectedPacketMemory were sent
+	relayed map[relayedPacket]time.Time
+	// local The addresses of this host, looked up at localRefreshed
+	local          []net.IP
+	localRefreshed time.Time
+}
+
+// relayedPacket Identifies a relayed packet by its contents and the socket of its address
+// family, which relays the same responses as the socket of the other family
+type relayedPacket struct {
+	conn   *multicastConn
+	packet string
+}
+
+// NewMDNSReflector Joins the mDNS groups on ifaces and relays between them, every packet with all.
+// answers reports whether this host answers for a name, such as MDNSResponder.Answers, nil when
+// it cannot tell, e.g. as another daemon publishes the records
+func NewMDNSReflector(ifaces []net.Interface, all bool, answers func(name string) bool) (*MDNSReflector, error) {
+	conns, err := listenMulticastGroups(ifaces, mdnsGroupIPv4, mdnsGroupIPv6)
+	if err != nil {
+		return nil, err
+	}
+	reflector := &MDNSReflector{ifaces: ifaces, all: all, answers: answers, conns: conns, relayed: map[relayedPacket]time.Time{}}
+	for _, conn := range conns {
+		go reflector.serve(conn)
+	}
+	return reflector, nil
+}
+
+// serve Relays the packets arriving on conn until it is closed
+func (r *MDNSReflector) serve(conn *multicastConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, ifIndex, src, err := conn.read(buf)
+		if err != nil {
+			if !r.isOpen(conn) {
+				return
+			}
+			log.Debugf("Failed to read mDNS packet to reflect: %+v", err)
+			continue
+		}
+		r.mutex.Lock()
+		r.relay(conn, ifIndex, src, buf[:n])
+		r.mutex.Unlock()
+	}
+}
+
+func (r *MDNSReflector) isOpen(conn *multicastConn) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, open := range r.conns {
+		if open == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// relay Sends packet, received on the interface at ifIndex from src, on the other interfaces.
+// Without all only the responses of this host and the queries it answers are relayed.
+// Queries lose their unicast-response bits, as unicast responses to this host would not be
+// relayed back. Legacy queries from ports other than 5353 are not relayed for the same reason,
+// and neither are packets relayed before. The caller holds the mutex
+func (r *MDNSReflector) relay(conn *multicastConn, ifIndex int, src net.Addr, packet []byte) {
+	from, ok := src.(*net.UDPAddr)
+	if !ok || from.Port != mdnsGroupIPv4.Port || !r.isReflected(ifIndex) {
+		return
+	}
+	now := time.Now()
+	for key, sent := range r.relayed {
+		if now.Sub(sent) >= reflectedPacketMemory {
+			delete(r.relayed, key)
+		}
+	}
+	if _, relayed := r.relayed[relayedPacket{conn, string(packet)}]; relayed {
+		return
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(packet); err != nil {
+		log.Debugf("Not reflecting malformed mDNS packet from %v: %+v", src, err)
+		return
+	}
+	if msg.Response && !r.all && !r.isLocal(from.IP) {
+		return
+	}
+	if !msg.Response && !r.all && !r.isAnswered(msg) {
+		return
+	}
+	if !msg.Response {
+		for i := range msg.Question {
+			msg.Question[i].Qclass &^= cacheFlushBit
+		}
+		packed, err := msg.Pack()
+		if err != nil {
+			log.Debugf("Failed to pack mDNS query to reflect: %+v", err)
+			return
+		}
+		packet = packed
+	}
+	r.relayed[relayedPacket{conn, string(packet)}] = now
+	for i := range r.ifaces {
+		if r.ifaces[i].Index == ifIndex {
+			continue
+		}
+		if err := conn.send(packet, &r.ifaces[i]); err != nil {
+			log.Debugf("Failed to reflect mDNS packet from %v onto %v: %+v", src, r.ifaces[i].Name, err)
+		}
+	}
+}
+
+// isAnswered Reports whether this host answers a question of query
+func (r *MDNSReflector) isAnswered(query *dns.Msg) bool {
+	if r.answers == nil {
+		return true
+	}
+	for _, question := range query.Question {
+		if r.answers(question.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReflected Reports whether the interface at ifIndex is one relayed between
+func (r *MDNSReflector) isReflected(ifIndex int) bool {
+	for _, iface := range r.ifaces {
+		if iface.Index == ifIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocal Reports whether ip is an address of this host, which the responses of the responder
+// come from. The caller holds the mutex
+func (r *MDNSReflector) isLocal(ip net.IP) bool {
+	if time.Since(r.localRefreshed) >= localAddressRefresh {
+		r.local = []net.IP{}
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok {
+					r.local = append(r.local, ipNet.IP)
+				}
+			}
+		} else {
+			log.Warnf("Failed to list the addresses of this host: %+v", err)
+		}
+		r.localRefreshed = time.Now()
+	}
+	return containsIP(r.local, ip)
+}
+
+// Close Stops relaying
+func (r *MDNSReflector) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	conns := r.conns
+	r.conns = nil
+	for _, conn := range conns {
+		conn.conn.Close()
+	}
+}
@@ -0,0 +1,873 @@
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// CollisionFirst Keeps publishing the first claim of a hostname, later conflicting claims wait
+	CollisionFirst = "first"
+	// CollisionLast Publishes the latest new or changed claim of a hostname
+	CollisionLast = "last"
+	// CollisionQualify Publishes conflicting claims from other namespaces as <hostname>.<namespace>
+	CollisionQualify = "qualify"
+)
+
+// Registry Keeps track of all registered hostnames and publishes their instances
+// through publisher, it is shared by the watch loops and safe for concurrent use
+type Registry struct {
+	// lockedAt When the mutex was locked in UnixNano, 0 while it is not held. It comes first
+	// for the 64-bit alignment atomic needs on 32-bit platforms
+	lockedAt int64
+	mutex    sync.Mutex
+	// interfacesMutex Guards broadcastInterfaces along with the mutex, which is held for writes
+	// too, so that the up interfaces are counted without waiting for the mutex
+	interfacesMutex     sync.Mutex
+	broadcastInterfaces []net.Interface
+	publisher           Publisher
+	registrations       map[string]*registration
+	// Domain The domain the hostnames are published in, hostname.DefaultDomain unless set
+	Domain          string
+	DefaultPriority uint16
+	DefaultWeight   uint16
+	// RecordTTL Overrides the default record TTLs when set
+	RecordTTL uint32
+	// TLSHTTPServiceType Also publishes TLS hosts without a custom service type under _http._tcp
+	TLSHTTPServiceType bool
+	// AllowHostnames and DenyHostnames are matched against the fully qualified hostname
+	AllowHostnames []*regexp.Regexp
+	DenyHostnames  []*regexp.Regexp
+	// CollisionPolicy Decides between owners claiming the same hostname differently
+	CollisionPolicy string
+	// ProbePolicy Decides what happens to hostnames another responder on the network answers for
+	ProbePolicy string
+	// HTTPCheck Holds back HTTP and HTTPS hostnames until the ingress serves them, when set
+	HTTPCheck *HTTPCheck
+	// stateFile Persists the published instances across restarts when set, which happens
+	// once the previous state was withdrawn so that it is not lost before
+	stateFile string
+	// closed Set once everything was unregistered on shutdown
+	closed bool
+	// Owns Reports whether this replica announces a hostname when set, for sharding the
+	// hostnames between nodes
+	Owns func(hostname string) bool
+	// Recorder Records Events about the registrations and collision decisions on the owners, when set
+	Recorder record.EventRecorder
+	// Status Writes the hostnames published for each ingress to its status annotation, when set
+	Status *IngressStatusWriter
+	// skipped The owners and hostnames a HostnameSkipped Event was recorded for, which is not
+	// repeated on every resync
+	skipped map[string]bool
+	// Prober Sends the probes for the hostnames, by default one joining the mDNS groups for each
+	// probe. The responder of --publisher=mdns probes over its own sockets instead
+	Prober *MDNSProber
+}
+
+// NewRegistry Returns an empty registry publishing through publisher on broadcastInterfaces
+func NewRegistry(broadcastInterfaces []net.Interface, publisher Publisher) *Registry {
+	return &Registry{
+		broadcastInterfaces: broadcastInterfaces,
+		publisher:           publisher,
+		registrations:       map[string]*registration{},
+		Domain:              hostname.DefaultDomain,
+		skipped:             map[string]bool{},
+		Prober:              NewMDNSProber(),
+	}
+}
+
+// registration A registered DNS-SD instance, which can be claimed by several owners such as
+// two ingresses declaring the same host. The claim of owner is published unless published
+// is false, when it could not be published
+type registration struct {
+	owners    map[string]claim
+	owner     string
+	local     hostname.LocalHostname
+	ips       []net.IP
+	published bool
+	// hostname Replaces the hostname of local when probing renamed it
+	hostname string
+	// inUseUntil Another responder answered for the hostname, it is not probed again until then
+	inUseUntil time.Time
+	// preparing The claim is being probed for or checked, or waits to be checked again, it is
+	// published once that passes
+	preparing bool
+	// generation Counts the claims entry was activated with, a probe of an earlier one is dropped
+	generation uint64
+	// unserved Why the HTTP check failed the last time, empty once it passed
+	unserved string
+	// announced When the records were last handed to the publisher
+	announced time.Time
+}
+
+// publishedHostname Returns the hostname the address records are published under
+func (entry *registration) publishedHostname() string {
+	if entry.hostname != "" {
+		return entry.hostname
+	}
+	return entry.local.Hostname
+}
+
+// claim The hostname and addresses an owner registered, ref refers to the owner for Events
+type claim struct {
+	local hostname.LocalHostname
+	ips   []net.IP
+	ref   *v1.ObjectReference
+}
+
+func (entry *registration) hasOwner(owner string) bool {
+	_, exists := entry.owners[owner]
+	return exists
+}
+
+func (c claim) equal(other claim) bool {
+	return hostname.IPsEqual(c.ips, other.ips) && reflect.DeepEqual(c.local, other.local)
+}
+
+func (c claim) namespace() string {
+	if c.ref == nil {
+		return ""
+	}
+	return c.ref.Namespace
+}
+
+// Register Publishes hostnames of owner with the given addresses, an A or AAAA record for each of them.
+// When another owner already published a hostname differently the collision policy decides, ref is
+// the object the Events explaining the decision are recorded on and may be nil. Hostnames are
+// published once probing for them and the HTTP check pass. Returns an error when hostnames owner won could not
+// be published, registering them again retries
+func (r *Registry) Register(owner string, ref *v1.ObjectReference, hostnames []hostname.LocalHostname, ips []net.IP) error {
+	r.lock()
+	defer r.unlock()
+	if r.closed {
+		return nil
+	}
+	if len(ips) == 0 {
+		if len(hostnames) > 0 {
+			log.WithFields(OwnerFields(owner)).Debugf("Not registering %v hostnames of %v, there is no address to advertise yet", len(hostnames), owner)
+			r.skip(owner, "", ref, "%v hostnames are not published, there is no address to advertise yet", len(hostnames))
+		}
+		return nil
+	}
+	delete(r.skipped, owner+" ")
+	for _, local := range r.withServiceTypes(hostnames) {
+		r.claim(owner, claim{local, ips, ref}, true)
+	}
+	r.saveState()
+	r.reportStatus()
+	return r.unpublished(owner)
+}
+
+// unpublished Returns an error naming the hostnames owner won that should be published but are
+// not, those waiting for another responder to release them or being probed for aside. The
+// caller holds the mutex
+func (r *Registry) unpublished(owner string) error {
+	failed := []string{}
+	for _, entry := range r.registrations {
+		if entry.owner == owner && !entry.published && !entry.preparing && r.publishable(entry) && !time.Now().Before(entry.inUseUntil) {
+			failed = append(failed, entry.publishedHostname())
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("publishing %v failed", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// claim Adds the claim of owner to the registration of its hostname, qualify allows
+// falling back to the namespace qualified hostname
+func (r *Registry) claim(owner string, claimed claim, qualify bool) {
+	local := claimed.local
+	if !r.isAllowed(local.Hostname + "." + r.Domain) {
+		log.WithFields(r.claimFields(owner, local, claimed.ips)).Infof("Not registering %v, it is excluded by the allowed/denied hostnames", local.Hostname)
+		r.skip(owner, local.Hostname, claimed.ref, "%v.%v is not published, it is excluded by the allowed/denied hostnames", local.Hostname, r.Domain)
+		return
+	}
+	entry, exists := r.registrations[local.Key()]
+	if !exists {
+		entry = &registration{owners: map[string]claim{}}
+		r.registrations[local.Key()] = entry
+	}
+	if entry.owner == "" || entry.owner == owner {
+		entry.owners[owner] = claimed
+		r.activate(entry, owner)
+		return
+	}
+	published := entry.owners[entry.owner]
+	if published.equal(claimed) {
+		// Shared by several owners, it stays published until the last one unregisters it
+		entry.owners[owner] = claimed
+		return
+	}
+	// Resyncs repeat unchanged claims, only new or changed ones are decided on
+	previous, known := entry.owners[owner]
+	changed := !known || !previous.equal(claimed)
+	switch {
+	case r.CollisionPolicy == CollisionLast:
+		entry.owners[owner] = claimed
+		if changed {
+			r.event(published.ref, v1.EventTypeWarning, "HostnameTakenOver", "%v is now published for %v", local.InstanceName(), owner)
+			r.event(claimed.ref, v1.EventTypeNormal, "HostnameTakenOver", "%v was published for %v and is now published for this object", local.InstanceName(), entry.owner)
+			r.activate(entry, owner)
+		}
+	case r.CollisionPolicy == CollisionQualify && qualify && claimed.namespace() != "" && claimed.namespace() != published.namespace():
+		qualified := claimed
+		qualified.local = qualifiedHostname(local, claimed.namespace())
+		if existing, exists := r.registrations[qualified.local.Key()]; !exists || !existing.hasOwner(owner) {
+			r.event(claimed.ref, v1.EventTypeWarning, "HostnameQualified", "%v is already published for %v, publishing %v instead", local.InstanceName(), entry.owner, qualified.local.InstanceName())
+		}
+		r.claim(owner, qualified, false)
+	default:
+		// The claim is kept and takes over when the published owner unregisters the hostname
+		entry.owners[owner] = claimed
+		if changed {
+			log.WithFields(r.claimFields(owner, local, claimed.ips)).Warnf("%v of %v differs from the registration of %v, keeping the latter", local.InstanceName(), owner, entry.owner)
+			r.event(claimed.ref, v1.EventTypeWarning, "HostnameConflict", "%v is already published differently for %v", local.InstanceName(), entry.owner)
+		}
+	}
+}
+
+// qualifiedHostname Returns local as <hostname>.<namespace>
+func qualifiedHostname(local hostname.LocalHostname, namespace string) hostname.LocalHostname {
+	local.Hostname = local.Hostname + "." + namespace
+	if local.Instance != "" {
+		local.Instance = local.Instance + "." + namespace
+	}
+	return local
+}
+
+// skip Records a HostnameSkipped Event on ref once for owner and hostname
+func (r *Registry) skip(owner string, hostname string, ref *v1.ObjectReference, messageFmt string, args ...interface{}) {
+	key := owner + " " + hostname
+	if r.skipped[key] {
+		return
+	}
+	r.skipped[key] = true
+	r.event(ref, v1.EventTypeNormal, "HostnameSkipped", messageFmt, args...)
+}
+
+// Skip Logs a warning and records a HostnameSkipped Event on ref for the hostnames a source
+// does not register for owner, once until owner registers addresses or is unregistered
+func (r *Registry) Skip(owner string, ref *v1.ObjectReference, messageFmt string, args ...interface{}) {
+	r.lock()
+	defer r.unlock()
+	if r.skipped[owner+" "] {
+		return
+	}
+	log.WithFields(OwnerFields(owner)).Warnf(messageFmt, args...)
+	r.skip(owner, "", ref, messageFmt, args...)
+}
+
+func (r *Registry) event(ref *v1.ObjectReference, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil && ref != nil {
+		r.Recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+	}
+}
+
+// activate Publishes the claim of owner, replacing the records of a different claim
+func (r *Registry) activate(entry *registration, owner string) {
+	claimed := entry.owners[owner]
+	unchanged := entry.owner == owner && hostname.IPsEqual(entry.ips, claimed.ips) && reflect.DeepEqual(entry.local, claimed.local)
+	if unchanged && (entry.published || entry.preparing || !r.publishable(entry) || time.Now().Before(entry.inUseUntil)) {
+		return
+	}
+	if entry.owner == "" {
+		log.WithFields(r.claimFields(owner, claimed.local, claimed.ips)).Infof("Registering %v", claimed.local.InstanceName())
+	} else {
+		// The advertised addresses, settings or owner changed, replace the stale records
+		log.WithFields(r.claimFields(owner, claimed.local, claimed.ips)).Infof("Re-registering %v of %v with %v", claimed.local.InstanceName(), owner, hostname.IPStrings(claimed.ips))
+	}
+	if entry.published && entry.owner == owner && reflect.DeepEqual(entry.local, claimed.local) && r.publishable(entry) {
+		if r.HTTPCheck == nil || !r.HTTPCheck.applies(r.instance(entry)) {
+			r.readdress(entry, claimed)
+			return
+		}
+	}
+	reason := "HostnameRegistered"
+	if entry.owner != "" {
+		reason = "HostnameReregistered"
+	}
+	r.unpublish(entry)
+	entry.owner, entry.local, entry.ips = owner, claimed.local, claimed.ips
+	if r.publishable(entry) {
+		r.publishClaim(entry, reason)
+	}
+}
+
+// readdress Replaces the published instance of entry with one advertising the addresses of
+// claimed, without withdrawing it first. The hostname stays ours, so it is not probed again,
+// and the replacement announces the new addresses with the cache-flush bit, which makes the
+// caches on the network drop the stale ones right away
+func (r *Registry) readdress(entry *registration, claimed claim) {
+	entry.ips = claimed.ips
+	if err := r.publish(entry); err != nil {
+		log.WithFields(r.claimFields(entry.owner, claimed.local, claimed.ips)).Errorf("Failed to register hostname %v: %+v", claimed.local.Hostname, err)
+		r.event(claimed.ref, v1.EventTypeWarning, "HostnameRegisterFailed", "Failed to publish %v.%v: %+v", entry.publishedHostname(), r.Domain, err)
+		r.unpublish(entry)
+		return
+	}
+	r.event(claimed.ref, v1.EventTypeNormal, "HostnameReregistered", "Published %v.%v with %v", entry.publishedHostname(), r.Domain, hostname.IPStrings(claimed.ips))
+}
+
+// publishable Reports whether this replica publishes entry, which it does unless another
+// node owns the hostname
+func (r *Registry) publishable(entry *registration) bool {
+	return r.Owns == nil || r.Owns(entry.local.Hostname)
+}
+
+// publishClaim Publishes the claim entry was activated with, recording reason on success. Unless
+// probing is off the hostname is probed for first, and HTTP and HTTPS hostnames are checked
+// to be served with an HTTPCheck. Both happen in the background without holding the mutex,
+// which publishes the claim once they pass
+func (r *Registry) publishClaim(entry *registration, reason string) {
+	entry.generation++
+	entry.hostname, entry.unserved = "", ""
+	probed := r.ProbePolicy != ProbeOff && len(r.upInterfaces()) > 0
+	checked := r.HTTPCheck != nil && r.HTTPCheck.applies(r.instance(entry))
+	if !probed && !checked {
+		r.publishPrepared(entry, reason)
+		return
+	}
+	entry.preparing = true
+	go r.prepare(entry, entry.generation, reason, 0)
+}
+
+// publishPrepared Publishes the claim entry was activated with once its hostname was probed for
+// and checked, recording reason on success
+func (r *Registry) publishPrepared(entry *registration, reason string) {
+	claimed := entry.owners[entry.owner]
+	if err := r.publish(entry); err != nil {
+		log.WithFields(r.claimFields(entry.owner, claimed.local, claimed.ips)).Errorf("Failed to register hostname %v: %+v", claimed.local.Hostname, err)
+		r.event(claimed.ref, v1.EventTypeWarning, "HostnameRegisterFailed", "Failed to publish %v.%v: %+v", entry.publishedHostname(), r.Domain, err)
+		return
+	}
+	r.event(claimed.ref, v1.EventTypeNormal, reason, "Published %v.%v with %v", entry.publishedHostname(), r.Domain, hostname.IPStrings(claimed.ips))
+}
+
+// Reshard Publishes the registrations this node owns now and withdraws those it does not own anymore
+func (r *Registry) Reshard() {
+	r.lock()
+	defer r.unlock()
+	for _, entry := range r.registrations {
+		if entry.owner == "" {
+			continue
+		}
+		if !r.publishable(entry) {
+			r.unpublish(entry)
+		} else if !entry.published && !entry.preparing {
+			r.publishClaim(entry, "HostnameRegistered")
+		}
+	}
+	r.saveState()
+	r.reportStatus()
+}
+
+// prepare Probes for the hostname of entry and runs the HTTP check against it, then publishes
+// entry unless the claim of generation was replaced meanwhile. It does not hold the mutex while
+// probing, which takes probeCount * probeInterval per hostname, or while checking. A hostname
+// that is not served is checked again after a delay growing with the failed attempts
+func (r *Registry) prepare(entry *registration, generation uint64, reason string, attempt int) {
+	r.lock()
+	name, instance, check := entry.local.Hostname, r.instance(entry), r.HTTPCheck
+	ifaces := []net.Interface{}
+	if r.ProbePolicy != ProbeOff {
+		ifaces = r.upInterfaces()
+	}
+	rename, prober, ips := r.ProbePolicy == ProbeRename, r.Prober, entry.ips
+	own := r.ownAddresses(name)
+	r.unlock()
+	candidate, conflicting := name, []net.IP{}
+	if len(ifaces) > 0 {
+		candidate, conflicting = r.probe(prober, ifaces, name, ips, own, rename)
+	}
+	var unserved error
+	if candidate != "" && check != nil && check.applies(instance) {
+		instance.Hostname = candidate
+		unserved = check.check(instance)
+	}
+
+	r.lock()
+	defer r.unlock()
+	if r.closed || r.registrations[entry.local.Key()] != entry || entry.generation != generation {
+		// Withdrawn or activated with another claim meanwhile, which is prepared anew
+		return
+	}
+	ref := entry.owners[entry.owner].ref
+	switch {
+	case candidate == "":
+		log.WithFields(r.claimFields(entry.owner, entry.local, entry.ips)).Warnf("Not publishing %v, it is already in use by %v, probing again in %v", name, hostname.IPStrings(conflicting), probeConflictBackoff)
+		r.event(ref, v1.EventTypeWarning, "HostnameInUse", "%v.%v is already in use by %v on the network", name, r.Domain, hostname.IPStrings(conflicting))
+		entry.preparing = false
+		entry.inUseUntil = time.Now().Add(probeConflictBackoff)
+		return
+	case candidate != name && candidate != entry.hostname:
+		log.WithFields(r.claimFields(entry.owner, entry.local, entry.ips)).Warnf("%v is already in use by %v, publishing %v instead", name, hostname.IPStrings(conflicting), candidate)
+		r.event(ref, v1.EventTypeWarning, "HostnameRenamed", "%v.%v is already in use by %v on the network, publishing %v.%v instead", name, r.Domain, hostname.IPStrings(conflicting), candidate, r.Domain)
+	}
+	if candidate != name {
+		entry.hostname = candidate
+	}
+	if unserved != nil {
+		delay := httpCheckRetryDelay(attempt)
+		log.WithFields(r.claimFields(entry.owner, entry.local, entry.ips)).Warnf("Not publishing %v yet, it is not served, checking again in %v: %+v", instance.Hostname, delay, unserved)
+		r.skip(entry.owner, entry.local.Hostname, ref, "%v.%v is not published until it is served: %+v", instance.Hostname, r.Domain, unserved)
+		entry.unserved = unserved.Error()
+		time.AfterFunc(delay, func() {
+			r.lock()
+			defer r.unlock()
+			if !r.closed && r.registrations[entry.local.Key()] == entry && entry.generation == generation {
+				go r.prepare(entry, generation, reason, attempt+1)
+			}
+		})
+		return
+	}
+	entry.preparing = false
+	entry.unserved = ""
+	delete(r.skipped, entry.owner+" "+entry.local.Hostname)
+	r.publishPrepared(entry, reason)
+	r.saveState()
+	r.reportStatus()
+}
+
+// probe Checks with prober that no other responder on ifaces answers for name, proposed with ips
+// and whose addresses in own are ours, and tries the numbered alternatives with rename. Returns
+// the hostname to publish, empty when all are in use, and the addresses the others answered
+// for name with
+func (r *Registry) probe(prober *MDNSProber, ifaces []net.Interface, name string, ips []net.IP, own []net.IP, rename bool) (string, []net.IP) {
+	conflicting := prober.Probe(ifaces, name+"."+r.Domain, ips, own)
+	if len(conflicting) == 0 {
+		return name, conflicting
+	}
+	if rename {
+		for i := 2; i < 2+probeRenameAttempts; i++ {
+			candidate := fmt.Sprintf("%v-%v", name, i)
+			r.lock()
+			taken := len(r.ownAddresses(candidate)) > 0
+			r.unlock()
+			if !taken && len(prober.Probe(ifaces, candidate+"."+r.Domain, ips, nil)) == 0 {
+				return candidate, conflicting
+			}
+		}
+	}
+	return "", conflicting
+}
+
+// instance Returns the instance published for a registration
+func (r *Registry) instance(entry *registration) ServiceInstance {
+	local := entry.local
+	local.Hostname = entry.publishedHostname()
+	priority, weight := r.DefaultPriority, r.DefaultWeight
+	if local.Priority != nil {
+		priority = *local.Priority
+	}
+	if local.Weight != nil {
+		weight = *local.Weight
+	}
+	return ServiceInstance{
+		Instance:         local.InstanceName(),
+		ServiceType:      local.AdvertisedServiceType(),
+		Domain:           r.Domain,
+		Hostname:         local.Hostname,
+		Port:             local.AdvertisedPort(),
+		Priority:         priority,
+		Weight:           weight,
+		Text:             local.TextRecords(),
+		IPs:              hostname.IPStrings(entry.ips),
+		TTL:              r.ttl(local),
+		AddressTTL:       r.addressTTL(),
+		SSDPDescription:  local.SSDPDescription,
+		WSDiscoveryTypes: local.WSDiscoveryTypes,
+	}
+}
+
+// instances Returns the currently published instances
+func (r *Registry) instances() []ServiceInstance {
+	instances := []ServiceInstance{}
+	for _, entry := range r.registrations {
+		if entry.published {
+			instances = append(instances, r.instance(entry))
+		}
+	}
+	return instances
+}
+
+// Registration A registered instance as reported by Registrations. Owner is the owner whose
+// claim is published, Owners all owners claiming the instance
+type Registration struct {
+	ServiceInstance
+	Owner     string   `json:"owner"`
+	Owners    []string `json:"owners"`
+	Published bool     `json:"published"`
+	// Pending Why the registration is not published, if it is not
+	Pending string `json:"pending,omitempty"`
+	// Announced When the records were last announced by the registry, on publishing them
+	// or moving onto other interfaces
+	Announced *time.Time `json:"announced,omitempty"`
+}
+
+// Registrations Returns every registration sorted by instance, including those that are
+// not published because another node owns them or another responder uses the hostname
+func (r *Registry) Registrations() []Registration {
+	r.lock()
+	defer r.unlock()
+	registrations := []Registration{}
+	for _, entry := range r.registrations {
+		if entry.owner == "" {
+			continue
+		}
+		registration := Registration{
+			ServiceInstance: r.instance(entry),
+			Owner:           entry.owner,
+			Owners:          []string{},
+			Published:       entry.published,
+		}
+		for owner := range entry.owners {
+			registration.Owners = append(registration.Owners, owner)
+		}
+		sort.Strings(registration.Owners)
+		switch {
+		case entry.published:
+		case !r.publishable(entry):
+			registration.Pending = "another node owns the hostname"
+		case time.Now().Before(entry.inUseUntil):
+			registration.Pending = fmt.Sprintf("another responder uses the hostname, probing again at %v", entry.inUseUntil.Format(time.RFC3339))
+		case entry.unserved != "":
+			registration.Pending = fmt.Sprintf("the hostname is not served yet, retrying: %v", entry.unserved)
+		case entry.preparing:
+			registration.Pending = "probing for and checking the hostname"
+		default:
+			registration.Pending = "publishing failed, retrying"
+		}
+		if !entry.announced.IsZero() {
+			announced := entry.announced
+			registration.Announced = &announced
+		}
+		registrations = append(registrations, registration)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].key() < registrations[j].key()
+	})
+	return registrations
+}
+
+// saveState Writes the published instances to the state file, if there is one
+func (r *Registry) saveState() {
+	if r.stateFile == "" {
+		return
+	}
+	if err := saveState(r.stateFile, r.instances()); err != nil {
+		log.Errorf("Failed to write state file %v: %+v", r.stateFile, err)
+	}
+}
+
+// ingressStatuses Returns the hostnames published for each ingress claiming one, keyed by
+// namespace/name. The caller holds the mutex of the registry
+func (r *Registry) ingressStatuses() map[string]ingressStatus {
+	statuses := map[string]ingressStatus{}
+	for _, entry := range r.registrations {
+		for owner, claimed := range entry.owners {
+			if claimed.ref == nil || claimed.ref.Kind != "Ingress" {
+				continue
+			}
+			key := claimed.ref.Namespace + "/" + claimed.ref.Name
+			status, exists := statuses[key]
+			if !exists {
+				status = ingressStatus{ref: *claimed.ref, hostnames: []string{}, ips: []string{}}
+			}
+			if entry.owner == owner && entry.published {
+				status.hostnames = append(status.hostnames, entry.publishedHostname()+"."+r.Domain)
+				status.ips = append(status.ips, hostname.IPStrings(entry.ips)...)
+			}
+			statuses[key] = status
+		}
+	}
+	return statuses
+}
+
+// reportStatus Hands the ingress statuses to the status writer, when set. The caller holds the mutex
+func (r *Registry) reportStatus() {
+	if r.Status != nil {
+		r.Status.update(r.ingressStatuses())
+	}
+}
+
+// WithdrawStale Withdraws the instances of a previous run that are not published anymore,
+// which the previous run could not withdraw when it crashed, and keeps stateFile up to
+// date from then on
+func (r *Registry) WithdrawStale(stateFile string, previous []ServiceInstance) {
+	r.lock()
+	defer r.unlock()
+	r.stateFile = stateFile
+	published := map[string]bool{}
+	for _, instance := range r.instances() {
+		published[instance.key()] = true
+	}
+	for _, instance := range previous {
+		if !published[instance.key()] {
+			log.Infof("Withdrawing %v left over from a previous run", instance.key())
+			r.publisher.Unpublish(instance)
+		}
+	}
+	r.saveState()
+}
+
+// ownAddresses Returns the addresses published under hostname by any registration
+func (r *Registry) ownAddresses(hostname string) []net.IP {
+	ips := []net.IP{}
+	for _, entry := range r.registrations {
+		if entry.published && strings.EqualFold(entry.publishedHostname(), hostname) {
+			ips = append(ips, entry.ips...)
+		}
+	}
+	return ips
+}
+
+// Reconfigure Applies configure to the settings of the registry and re-publishes only the
+// registrations whose records changed with them, those the allowed and denied hostnames
+// exclude now are withdrawn. Their owners register them again once they are allowed
+func (r *Registry) Reconfigure(configure func(r *Registry)) {
+	r.lock()
+	defer r.unlock()
+	if r.closed {
+		return
+	}
+	previous := map[*registration]ServiceInstance{}
+	for _, entry := range r.registrations {
+		if entry.published {
+			previous[entry] = r.instance(entry)
+		}
+	}
+	configure(r)
+	for key, entry := range r.registrations {
+		instance, published := previous[entry]
+		if !r.isAllowed(entry.local.Hostname + "." + r.Domain) {
+			log.WithFields(r.claimFields(entry.owner, entry.local, entry.ips)).Infof("Unregistering %v, it is excluded by the allowed/denied hostnames now", entry.local.InstanceName())
+			if published {
+				r.publisher.Unpublish(instance)
+				r.event(entry.owners[entry.owner].ref, v1.EventTypeNormal, "HostnameUnregistered", "Withdrew %v.%v, it is excluded by the allowed/denied hostnames now", entry.publishedHostname(), r.Domain)
+			}
+			delete(r.registrations, key)
+			continue
+		}
+		if published && !reflect.DeepEqual(instance, r.instance(entry)) {
+			log.WithFields(r.claimFields(entry.owner, entry.local, entry.ips)).Infof("Re-registering %v with the new settings", entry.local.InstanceName())
+			r.publisher.Unpublish(instance)
+			entry.published = false
+			r.publishClaim(entry, "HostnameReregistered")
+		}
+	}
+	r.saveState()
+	r.reportStatus()
+}
+
+// lock Locks the mutex, remembering since when it is held
+func (r *Registry) lock() {
+	r.mutex.Lock()
+	atomic.StoreInt64(&r.lockedAt, time.Now().UnixNano())
+}
+
+func (r *Registry) unlock() {
+	atomic.StoreInt64(&r.lockedAt, 0)
+	r.mutex.Unlock()
+}
+
+// CheckLive Returns an error when the registry has been held by one update for more than
+// timeout, as a wedged process has. Updates waiting for each other in turn pass, however long
+// the queue is
+func (r *Registry) CheckLive(timeout time.Duration) error {
+	lockedAt := atomic.LoadInt64(&r.lockedAt)
+	if lockedAt == 0 {
+		return nil
+	}
+	if locked := time.Since(time.Unix(0, lockedAt)); locked > timeout {
+		return fmt.Errorf("registry locked by one update for %v", locked.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// UpInterfaceCount Returns how many broadcast interfaces are up, without waiting for the updates
+func (r *Registry) UpInterfaceCount() int {
+	r.interfacesMutex.Lock()
+	defer r.interfacesMutex.Unlock()
+	return len(r.upInterfaces())
+}
+
+// InterfacesState Describes the broadcast interfaces with their flags and addresses
+func (r *Registry) InterfacesState() string {
+	r.lock()
+	defer r.unlock()
+	return interfacesState(r.broadcastInterfaces)
+}
+
+// upInterfaces Returns the broadcast interfaces that are up
+func (r *Registry) upInterfaces() []net.Interface {
+	return UpInterfaces(r.broadcastInterfaces)
+}
+
+// qualifiedRegistration Returns the registration of the namespace qualified local claimed by owner
+func (r *Registry) qualifiedRegistration(owner string, local hostname.LocalHostname) *registration {
+	for key, entry := range r.registrations {
+		claimed, exists := entry.owners[owner]
+		if exists && key == qualifiedHostname(local, claimed.namespace()).Key() {
+			return entry
+		}
+	}
+	return nil
+}
+
+// withServiceTypes Adds an _http._tcp copy of TLS hostnames when TLSHTTPServiceType is set
+func (r *Registry) withServiceTypes(hostnames []hostname.LocalHostname) []hostname.LocalHostname {
+	if !r.TLSHTTPServiceType {
+		return hostnames
+	}
+	expanded := []hostname.LocalHostname{}
+	for _, local := range hostnames {
+		expanded = append(expanded, local)
+		if local.TLS && local.ServiceType == "" {
+			local.ServiceType = hostname.ServiceTypeHTTP
+			expanded = append(expanded, local)
+		}
+	}
+	return expanded
+}
+
+// publish Publishes the instance of a registration
+func (r *Registry) publish(entry *registration) error {
+	if err := r.publisher.Publish(r.instance(entry)); err != nil {
+		return err
+	}
+	entry.published = true
+	entry.announced = time.Now()
+	return nil
+}
+
+// unpublish Withdraws the instance of a registration, if it is published, and drops the
+// probe of its claim, if one is underway
+func (r *Registry) unpublish(entry *registration) {
+	entry.generation++
+	entry.preparing = false
+	if entry.published {
+		r.publisher.Unpublish(r.instance(entry))
+		entry.published = false
+	}
+}
+
+// ttl Returns the TTL of the PTR, SRV and TXT records of local
+func (r *Registry) ttl(local hostname.LocalHostname) uint32 {
+	if local.TTL != 0 {
+		return local.TTL
+	}
+	if r.RecordTTL != 0 {
+		return r.RecordTTL
+	}
+	return defaultRecordTTL
+}
+
+// addressTTL Returns the TTL of the A and AAAA records
+func (r *Registry) addressTTL() uint32 {
+	if r.RecordTTL != 0 {
+		return r.RecordTTL
+	}
+	return addressRecordTTL
+}
+
+// setInterfaces Moves the publisher onto new broadcast interfaces, which re-announces everything
+func (r *Registry) setInterfaces(broadcastInterfaces []net.Interface) {
+	r.lock()
+	defer r.unlock()
+	r.interfacesMutex.Lock()
+	r.broadcastInterfaces = broadcastInterfaces
+	r.interfacesMutex.Unlock()
+	if err := r.publisher.SetInterfaces(r.upInterfaces()); err != nil {
+		log.Errorf("Failed to move onto interfaces %v: %+v", interfacesState(broadcastInterfaces), err)
+		return
+	}
+	for _, entry := range r.registrations {
+		if entry.published {
+			entry.announced = time.Now()
+		}
+	}
+}
+
+// isAllowed Reports whether hostname matches an allowed expression, if any are
+// configured, and none of the denied expressions
+func (r *Registry) isAllowed(hostname string) bool {
+	for _, deny := range r.DenyHostnames {
+		if deny.MatchString(hostname) {
+			return false
+		}
+	}
+	if len(r.AllowHostnames) == 0 {
+		return true
+	}
+	for _, allow := range r.AllowHostnames {
+		if allow.MatchString(hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unregister Drops the claims of owner on hostnames, the records are removed with the last owner
+func (r *Registry) Unregister(owner string, hostnames []hostname.LocalHostname) {
+	r.lock()
+	defer r.unlock()
+	delete(r.skipped, owner+" ")
+	for _, local := range r.withServiceTypes(hostnames) {
+		delete(r.skipped, owner+" "+local.Hostname)
+		entry, exists := r.registrations[local.Key()]
+		if !exists || !entry.hasOwner(owner) {
+			// The hostname may have been qualified with the namespace of owner
+			if entry = r.qualifiedRegistration(owner, local); entry == nil {
+				continue
+			}
+			local = entry.owners[owner].local
+		}
+		ref := entry.owners[owner].ref
+		delete(entry.owners, owner)
+		if len(entry.owners) == 0 {
+			log.WithFields(r.claimFields(owner, local, entry.ips)).Infof("Unregistering %v", local.InstanceName())
+			if entry.published {
+				r.event(ref, v1.EventTypeNormal, "HostnameUnregistered", "Withdrew %v.%v", entry.publishedHostname(), r.Domain)
+			}
+			r.unpublish(entry)
+			delete(r.registrations, local.Key())
+		} else if entry.owner == owner {
+			owners := []string{}
+			for remaining := range entry.owners {
+				owners = append(owners, remaining)
+			}
+			sort.Strings(owners)
+			log.WithFields(r.claimFields(owner, local, entry.ips)).Infof("%v was removed from %v, %v still registers it", local.InstanceName(), owner, owners[0])
+			r.activate(entry, owners[0])
+		}
+	}
+	r.saveState()
+	r.reportStatus()
+}
+
+// UnregisterAll Sends goodbye packets for all registrations on shutdown, nothing is registered afterwards
+func (r *Registry) UnregisterAll() {
+	r.lock()
+	defer r.unlock()
+	r.closed = true
+	for key, entry := range r.registrations {
+		log.WithFields(r.claimFields(entry.owner, entry.local, entry.ips)).Infof("Unregistering %v", key)
+		r.unpublish(entry)
+		delete(r.registrations, key)
+	}
+	r.saveState()
+}
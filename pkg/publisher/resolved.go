@@ -0,0 +1,131 @@
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	resolvedService = "org.freedesktop.resolve1"
+	resolvedManager = resolvedService + ".Manager"
+)
+
+// ResolvedPublisher Registers instances as DNS-SD services with systemd-resolved on the host.
+// resolved only publishes services under the hostname and addresses of the node itself,
+// so the hostnames and addresses of the instances are not published
+type ResolvedPublisher struct {
+	mutex    sync.Mutex
+	conn     *dbus.Conn
+	manager  dbus.BusObject
+	services map[string]dbus.ObjectPath
+	// registered Counts the registrations, resolved identifies each by a unique name
+	registered int
+}
+
+// NewResolvedPublisher Connects to systemd-resolved over the system D-Bus
+func NewResolvedPublisher() (*ResolvedPublisher, error) {
+	conn, err := dbus.SystemBusPrivate()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the system bus: %+v", err)
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("authenticating on the system bus: %+v", err)
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("greeting the system bus: %+v", err)
+	}
+	manager := conn.Object(resolvedService, "/org/freedesktop/resolve1")
+	multicastDNS, err := manager.GetProperty(resolvedManager + ".MulticastDNS")
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reaching systemd-resolved: %+v", err)
+	}
+	if mode, _ := multicastDNS.Value().(string); mode != "yes" {
+		log.Warnf("systemd-resolved has MulticastDNS=%v, services are only announced with MulticastDNS=yes", multicastDNS.Value())
+	}
+	log.Warnf("systemd-resolved publishes services under the hostname and addresses of the node, not their own")
+	return &ResolvedPublisher{
+		conn:     conn,
+		manager:  manager,
+		services: map[string]dbus.ObjectPath{},
+	}, nil
+}
+
+func (p *ResolvedPublisher) Publish(instance ServiceInstance) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.unregister(instance.key())
+	p.registered++
+	var path dbus.ObjectPath
+	// The name template expands specifiers such as %H, which a literal % must not start
+	err := p.manager.Call(resolvedManager+".RegisterService", 0,
+		fmt.Sprintf("ingress-frontend-zeroconf-%v", p.registered),
+		strings.Replace(instance.Instance, "%", "%%", -1),
+		instance.ServiceType,
+		uint16(instance.Port),
+		instance.Priority,
+		instance.Weight,
+		[]map[string][]byte{resolvedText(instance.Text)},
+	).Store(&path)
+	if err != nil {
+		return fmt.Errorf("registering %v with systemd-resolved: %+v", instance.key(), err)
+	}
+	p.services[instance.key()] = path
+	return nil
+}
+
+// resolvedText Converts key=value TXT entries into the dictionary resolved expects
+func resolvedText(text []string) map[string][]byte {
+	data := map[string][]byte{}
+	for _, entry := range text {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 2 {
+			data[parts[0]] = []byte(parts[1])
+		} else {
+			data[parts[0]] = nil
+		}
+	}
+	return data
+}
+
+// Unpublish Unregisters instance, resolved already dropped the services of previous runs
+// when their connection closed
+func (p *ResolvedPublisher) Unpublish(instance ServiceInstance) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.unregister(instance.key())
+}
+
+// SetInterfaces Is left to resolved, which publishes on every link with mDNS enabled
+func (p *ResolvedPublisher) SetInterfaces(ifaces []net.Interface) error {
+	log.Debugf("systemd-resolved picks the links to publish on, ignoring interfaces %v", interfacesState(ifaces))
+	return nil
+}
+
+func (p *ResolvedPublisher) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for key := range p.services {
+		p.unregister(key)
+	}
+	p.conn.Close()
+}
+
+// unregister Unregisters the service of key if there is one, the caller holds the mutex
+func (p *ResolvedPublisher) unregister(key string) {
+	path, exists := p.services[key]
+	if !exists {
+		return
+	}
+	delete(p.services, key)
+	if err := p.manager.Call(resolvedManager+".UnregisterService", 0, path).Err; err != nil {
+		log.Errorf("Failed to unregister %v from systemd-resolved: %+v", key, err)
+	}
+}
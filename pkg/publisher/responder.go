@@ -0,0 +1,885 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// announceInterval Instances are announced twice, this far apart, following RFC 6762 section 8.3
+const announceInterval = time.Second
+
+const (
+	// minMulticastInterval How long a record is not multicast again on an interface in answer
+	// to queries, RFC 6762 section 6
+	minMulticastInterval = time.Second
+	// responseWarnInterval How often dropping responses beyond the ResponseRate is logged
+	responseWarnInterval = time.Minute
+)
+
+// mdnsQuestions and mdnsAnswers Count the mDNS questions answered with the records of each
+// published hostname and those records sent, served on /metrics of --metrics-listen
+var (
+	mdnsQuestions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "zeroconf_mdns_questions_total",
+		Help: "mDNS questions received that the records of a published hostname answer",
+	}, []string{"hostname"})
+	mdnsAnswers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "zeroconf_mdns_answers_total",
+		Help: "Records of a published hostname sent in answer to mDNS queries",
+	}, []string{"hostname"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(mdnsQuestions, mdnsAnswers)
+}
+
+// MDNSResponder Answers mDNS queries for all published instances, sharing one socket per
+// address family between them
+type MDNSResponder struct {
+	mutex     sync.Mutex
+	ifaces    []net.Interface
+	instances map[string]ServiceInstance
+	conns     []*multicastConn
+	options   MDNSOptions
+	// multicastAt When each record was last multicast, keyed by multicastKey
+	multicastAt map[string]time.Time
+	// responses The responses to queries sent in the current second, by interface index
+	responses map[int]*responseWindow
+	// prober Probes over the sockets of the responder, which hands it the packets they receive
+	prober *MDNSProber
+}
+
+// responseWindow Counts the responses to queries sent on an interface within a second
+type responseWindow struct {
+	start   time.Time
+	count   int
+	dropped int
+	// warned When the last warning about dropped responses was logged
+	warned time.Time
+}
+
+// MDNSOptions What the responder publishes and answers beyond the records of the instances
+type MDNSOptions struct {
+	// ReverseRecords Also publishes PTR records in in-addr.arpa and ip6.arpa pointing the
+	// addresses at their hostnames, for reverse lookups
+	ReverseRecords bool
+	// ResponseRate The most responses to queries sent per second on an interface, queries
+	// beyond it are dropped to keep a misbehaving client from flooding the LAN. 0 is unlimited
+	ResponseRate int
+}
+
+// multicastConn A socket that joined the multicast group of one address family
+type multicastConn struct {
+	conn *net.UDPConn
+	// read Reads a packet along with the index of the interface it arrived on and its sender
+	read func(buf []byte) (int, int, net.Addr, error)
+	// send Multicasts a packet to the group on an interface
+	send func(packet []byte, iface *net.Interface) error
+}
+
+// NewMDNSResponder Listens for mDNS queries on ifaces
+func NewMDNSResponder(ifaces []net.Interface, options MDNSOptions) (*MDNSResponder, error) {
+	responder := &MDNSResponder{instances: map[string]ServiceInstance{}, options: options, multicastAt: map[string]time.Time{}, responses: map[int]*responseWindow{}}
+	responder.prober = NewMDNSProber()
+	responder.prober.responderConns = func() []*multicastConn {
+		responder.mutex.Lock()
+		defer responder.mutex.Unlock()
+		return responder.conns
+	}
+	if err := responder.listen(ifaces); err != nil {
+		return nil, err
+	}
+	return responder, nil
+}
+
+// listen Joins the mDNS groups on ifaces and starts answering queries, the caller holds the mutex
+func (r *MDNSResponder) listen(ifaces []net.Interface) error {
+	r.ifaces = ifaces
+	if len(ifaces) == 0 {
+		return nil
+	}
+	conns, err := listenMulticastGroups(ifaces, mdnsGroupIPv4, mdnsGroupIPv6)
+	if err != nil {
+		return err
+	}
+	r.conns = conns
+	for _, conn := range r.conns {
+		go r.serve(conn)
+	}
+	return nil
+}
+
+// listenMulticastGroups Joins the IPv4 and the IPv6 group on ifaces, failing only when neither could be joined
+func listenMulticastGroups(ifaces []net.Interface, group4 *net.UDPAddr, group6 *net.UDPAddr) ([]*multicastConn, error) {
+	conns := []*multicastConn{}
+	failures := []string{}
+	if conn, err := listenMulticast4(ifaces, group4); err == nil {
+		conns = append(conns, conn)
+	} else {
+		failures = append(failures, fmt.Sprintf("IPv4: %+v", err))
+	}
+	if conn, err := listenMulticast6(ifaces, group6); err == nil {
+		conns = append(conns, conn)
+	} else {
+		failures = append(failures, fmt.Sprintf("IPv6: %+v", err))
+	}
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("Failed to join %v or %v: %v", group4.IP, group6.IP, strings.Join(failures, ", "))
+	}
+	for _, failure := range failures {
+		log.Debugf("Not listening on %v/%v over %v", group4.IP, group6.IP, failure)
+	}
+	return conns, nil
+}
+
+func listenMulticast4(ifaces []net.Interface, group *net.UDPAddr) (*multicastConn, error) {
+	conn, err := listenMulticastPort("udp4", group)
+	if err != nil {
+		return nil, err
+	}
+	packetConn := ipv4.NewPacketConn(conn)
+	joined := 0
+	for i := range ifaces {
+		if err := packetConn.JoinGroup(&ifaces[i], group); err != nil {
+			log.Warnf("Failed to join %v on %v: %+v", group.IP, ifaces[i].Name, err)
+			continue
+		}
+		joined++
+	}
+	if joined == 0 {
+		conn.Close()
+		return nil, fmt.Errorf("No interface joined %v", group.IP)
+	}
+	if err := packetConn.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = packetConn.SetMulticastTTL(255)
+	return &multicastConn{
+		conn: conn,
+		read: func(buf []byte) (int, int, net.Addr, error) {
+			n, cm, src, err := packetConn.ReadFrom(buf)
+			if err != nil || cm == nil {
+				return n, 0, src, err
+			}
+			return n, cm.IfIndex, src, nil
+		},
+		send: func(packet []byte, iface *net.Interface) error {
+			if err := packetConn.SetMulticastInterface(iface); err != nil {
+				return err
+			}
+			_, err := conn.WriteTo(packet, group)
+			return err
+		},
+	}, nil
+}
+
+func listenMulticast6(ifaces []net.Interface, group *net.UDPAddr) (*multicastConn, error) {
+	joinable := []net.Interface{}
+	for _, iface := range ifaces {
+		if HasIPv6Address(iface) {
+			joinable = append(joinable, iface)
+		}
+	}
+	if len(joinable) == 0 {
+		return nil, fmt.Errorf("No interface has an IPv6 address")
+	}
+	conn, err := listenMulticastPort("udp6", group)
+	if err != nil {
+		return nil, err
+	}
+	packetConn := ipv6.NewPacketConn(conn)
+	joined := 0
+	for i := range joinable {
+		if err := packetConn.JoinGroup(&joinable[i], group); err != nil {
+			log.Warnf("Failed to join %v on %v: %+v", group.IP, joinable[i].Name, err)
+			continue
+		}
+		joined++
+	}
+	if joined == 0 {
+		conn.Close()
+		return nil, fmt.Errorf("No interface joined %v", group.IP)
+	}
+	if err := packetConn.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = packetConn.SetMulticastHopLimit(255)
+	return &multicastConn{
+		conn: conn,
+		read: func(buf []byte) (int, int, net.Addr, error) {
+			n, cm, src, err := packetConn.ReadFrom(buf)
+			if err != nil || cm == nil {
+				return n, 0, src, err
+			}
+			return n, cm.IfIndex, src, nil
+		},
+		send: func(packet []byte, iface *net.Interface) error {
+			if !HasIPv6Address(*iface) {
+				return nil
+			}
+			_, err := conn.WriteTo(packet, &net.UDPAddr{IP: group.IP, Port: group.Port, Zone: iface.Name})
+			return err
+		},
+	}, nil
+}
+
+// listenMulticastPort Opens a socket on the port of group with SO_REUSEADDR and SO_REUSEPORT set,
+// which shares the port with other responders on the node such as avahi-daemon. The kernel hands
+// every multicast to all sockets sharing the port but a unicast datagram to only one of them, so
+// the responder must not rely on receiving unicast queries
+func listenMulticastPort(network string, group *net.UDPAddr) (*net.UDPConn, error) {
+	config := net.ListenConfig{Control: func(_, _ string, raw syscall.RawConn) error {
+		var optErr error
+		err := raw.Control(func(fd uintptr) {
+			if optErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); optErr == nil {
+				optErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return optErr
+	}}
+	conn, err := config.ListenPacket(context.Background(), network, group.String())
+	if err != nil {
+		if errors.Is(err, unix.EADDRINUSE) {
+			log.Warnf("Another responder holds port %v exclusively, configure it to share the port (avahi-daemon does) or stop it", group.Port)
+		}
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// serve Answers the queries arriving on conn until it is closed
+func (r *MDNSResponder) serve(conn *multicastConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, ifIndex, src, err := conn.read(buf)
+		if err != nil {
+			if !r.isOpen(conn) {
+				return
+			}
+			log.Debugf("Failed to read mDNS packet: %+v", err)
+			continue
+		}
+		query := new(dns.Msg)
+		if err := query.Unpack(buf[:n]); err != nil {
+			log.Debugf("Ignoring malformed mDNS packet: %+v", err)
+			continue
+		}
+		r.prober.receive(query)
+		if query.Response || query.Opcode != dns.OpcodeQuery {
+			continue
+		}
+		r.mutex.Lock()
+		r.answer(conn, ifIndex, src, query)
+		r.mutex.Unlock()
+	}
+}
+
+// Prober Returns the prober sending probes from port 5353 over the sockets of the responder,
+// which receive the responses to them
+func (r *MDNSResponder) Prober() *MDNSProber {
+	return r.prober
+}
+
+func (r *MDNSResponder) isOpen(conn *multicastConn) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, open := range r.conns {
+		if open == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// answer Multicasts the answers to query on the interface it arrived on, queries from
+// interfaces other than ours are ignored. Queries whose questions all ask for a unicast
+// response are answered to src, unless an answer was not multicast within a quarter of its
+// TTL (RFC 6762 section 5.4). Legacy queries from a port other than 5353 always are.
+// Answers multicast on the interface within the last second are left out, except in
+// answer to probes (RFC 6762 section 6). The caller holds the mutex
+func (r *MDNSResponder) answer(conn *multicastConn, ifIndex int, src net.Addr, query *dns.Msg) {
+	var iface *net.Interface
+	for i := range r.ifaces {
+		if r.ifaces[i].Index == ifIndex {
+			iface = &r.ifaces[i]
+		}
+	}
+	if iface == nil {
+		return
+	}
+	answers, extras := []dns.RR{}, []dns.RR{}
+	answered := [][]dns.RR{}
+	for _, question := range query.Question {
+		questionAnswers, questionExtras := answerQuestion(r.instances, question)
+		if r.options.ReverseRecords {
+			questionAnswers = append(questionAnswers, answerReverseQuestion(r.instances, question)...)
+		}
+		if len(questionAnswers) == 0 && question.Qtype != dns.TypeANY {
+			// Asserts that a name of ours has no record of the type, RFC 6762 section 6.1
+			if nsec := nsecRecord(r.instances, question.Name); nsec != nil {
+				questionAnswers = append(questionAnswers, nsec)
+			}
+		}
+		answers = append(answers, questionAnswers...)
+		extras = append(extras, questionExtras...)
+		answered = append(answered, questionAnswers)
+	}
+	if len(answers) == 0 {
+		return
+	}
+	names := r.recordHostnames()
+	for _, questionAnswers := range answered {
+		for _, name := range answerHostnames(names, questionAnswers) {
+			mdnsQuestions.WithLabelValues(name).Inc()
+		}
+	}
+	if answers = withoutKnownAnswers(answers, query.Answer); len(answers) == 0 {
+		return
+	}
+	response := newResponse(uniqueRecords(answers, nil))
+	extras = append(extras, nsecRecords(r.instances, append(response.Answer, extras...))...)
+	response.Extra = uniqueRecords(extras, response.Answer)
+	legacy := isLegacyQuery(src)
+	unicast := legacy || isUnicastQuery(query) && r.recentlyMulticast(iface.Index, response.Answer)
+	if !unicast && len(query.Ns) == 0 {
+		if response.Answer = r.withoutThrottled(iface.Index, response.Answer); len(response.Answer) == 0 {
+			return
+		}
+	}
+	if !r.allowResponse(iface) {
+		return
+	}
+	for _, record := range response.Answer {
+		if name, exists := names[recordOwner(record)]; exists {
+			mdnsAnswers.WithLabelValues(name).Inc()
+		}
+	}
+	switch {
+	case legacy:
+		r.unicast(legacyResponse(query, response), conn, src, iface)
+	case unicast:
+		r.unicast(response, conn, src, iface)
+	default:
+		r.multicast(response, []*multicastConn{conn}, []net.Interface{*iface})
+	}
+}
+
+// recordHostnames Returns the published hostnames, e.g. grafana.local, by the lower cased
+// names of their address records and instances. The caller holds the mutex
+func (r *MDNSResponder) recordHostnames() map[string]string {
+	names := map[string]string{}
+	for _, instance := range r.instances {
+		name := instance.Hostname + "." + instance.Domain
+		names[strings.ToLower(name+".")] = name
+		names[strings.ToLower(escapeLabel(instance.Instance)+"."+instance.ServiceType+"."+instance.Domain+".")] = name
+	}
+	return names
+}
+
+// recordOwner Returns the lower cased name a record is about, the instance or hostname a PTR
+// record points at and the name of the others
+func recordOwner(record dns.RR) string {
+	if ptr, ok := record.(*dns.PTR); ok {
+		return strings.ToLower(ptr.Ptr)
+	}
+	return strings.ToLower(record.Header().Name)
+}
+
+// answerHostnames Returns the hostnames in names that records are about, each once
+func answerHostnames(names map[string]string, records []dns.RR) []string {
+	hostnames := []string{}
+	seen := map[string]bool{}
+	for _, record := range records {
+		if name, exists := names[recordOwner(record)]; exists && !seen[name] {
+			seen[name] = true
+			hostnames = append(hostnames, name)
+		}
+	}
+	return hostnames
+}
+
+// withoutThrottled Returns records without those multicast on the interface at ifIndex
+// within minMulticastInterval. The caller holds the mutex
+func (r *MDNSResponder) withoutThrottled(ifIndex int, records []dns.RR) []dns.RR {
+	allowed := []dns.RR{}
+	for _, record := range records {
+		if at, exists := r.multicastAt[multicastKey(ifIndex, record)]; exists && time.Since(at) < minMulticastInterval {
+			continue
+		}
+		allowed = append(allowed, record)
+	}
+	return allowed
+}
+
+// allowResponse Counts a response to a query on iface and reports whether it stays within
+// the ResponseRate, warning at most once per responseWarnInterval about those dropped. The
+// caller holds the mutex
+func (r *MDNSResponder) allowResponse(iface *net.Interface) bool {
+	if r.options.ResponseRate <= 0 {
+		return true
+	}
+	now := time.Now()
+	window, exists := r.responses[iface.Index]
+	if !exists {
+		window = &responseWindow{}
+		r.responses[iface.Index] = window
+	}
+	if now.Sub(window.start) >= time.Second {
+		window.start, window.count = now, 0
+	}
+	if window.count < r.options.ResponseRate {
+		window.count++
+		return true
+	}
+	window.dropped++
+	if now.Sub(window.warned) >= responseWarnInterval {
+		log.Warnf("Dropped %v mDNS responses on %v beyond %v per second, a client may be flooding it with queries", window.dropped, iface.Name, r.options.ResponseRate)
+		window.warned, window.dropped = now, 0
+	}
+	return false
+}
+
+// isUnicastQuery Reports whether every question of query has the unicast-response bit set
+func isUnicastQuery(query *dns.Msg) bool {
+	for _, question := range query.Question {
+		if question.Qclass&cacheFlushBit == 0 {
+			return false
+		}
+	}
+	return len(query.Question) > 0
+}
+
+// isLegacyQuery Reports whether a query from src is a one-shot query of a resolver that is
+// not an mDNS responder, sent from a port other than 5353 (RFC 6762 section 6.7)
+func isLegacyQuery(src net.Addr) bool {
+	addr, ok := src.(*net.UDPAddr)
+	return ok && addr.Port != mdnsGroupIPv4.Port
+}
+
+// legacyResponse Returns response in the form legacy resolvers expect it, with the ID and
+// questions of query and without cache-flush bits. TTLs are capped at legacyRecordTTL, as
+// these resolvers do not see the goodbyes of records that change
+func legacyResponse(query *dns.Msg, response *dns.Msg) *dns.Msg {
+	legacy := newResponse(legacyRecords(response.Answer))
+	legacy.Id = query.Id
+	legacy.Question = query.Question
+	legacy.Extra = legacyRecords(response.Extra)
+	return legacy
+}
+
+// legacyRecords Returns copies of records for a legacyResponse
+func legacyRecords(records []dns.RR) []dns.RR {
+	copies := make([]dns.RR, 0, len(records))
+	for _, record := range records {
+		record = dns.Copy(record)
+		record.Header().Class &^= cacheFlushBit
+		if record.Header().Ttl > legacyRecordTTL {
+			record.Header().Ttl = legacyRecordTTL
+		}
+		copies = append(copies, record)
+	}
+	return copies
+}
+
+// recentlyMulticast Reports whether every record was multicast on the interface at ifIndex
+// within a quarter of its TTL, so that the other hosts on the link have it cached. The
+// caller holds the mutex
+func (r *MDNSResponder) recentlyMulticast(ifIndex int, records []dns.RR) bool {
+	for _, record := range records {
+		at, exists := r.multicastAt[multicastKey(ifIndex, record)]
+		if !exists || time.Since(at) > time.Duration(record.Header().Ttl)*time.Second/4 {
+			return false
+		}
+	}
+	return true
+}
+
+// unicast Sends response to src over conn, the address of a querier on iface. The caller
+// holds the mutex
+func (r *MDNSResponder) unicast(response *dns.Msg, conn *multicastConn, src net.Addr, iface *net.Interface) {
+	packed, err := response.Pack()
+	if err != nil {
+		log.Errorf("Failed to pack mDNS response: %+v", err)
+		return
+	}
+	if addr, ok := src.(*net.UDPAddr); ok && addr.IP.IsLinkLocalUnicast() && addr.Zone == "" {
+		src = &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: iface.Name}
+	}
+	if _, err := conn.conn.WriteTo(packed, src); err != nil {
+		log.Debugf("Failed to send mDNS response to %v: %+v", src, err)
+	}
+}
+
+// answerQuestion Returns the records of instances answering question and the additional
+// records that save the querier follow-up queries
+func answerQuestion(instances map[string]ServiceInstance, question dns.Question) ([]dns.RR, []dns.RR) {
+	answers, extras := []dns.RR{}, []dns.RR{}
+	for _, instance := range instances {
+		records := newInstanceRecords(instance, instance.TTL)
+		host := records.srv.(*dns.SRV).Target
+		switch {
+		case strings.EqualFold(question.Name, "_services._dns-sd._udp."+instance.Domain+"."):
+			enumeration := &dns.PTR{Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: instance.TTL}, Ptr: records.ptr.Header().Name}
+			answers = append(answers, matchingRecords([]dns.RR{enumeration}, question.Qtype)...)
+		case strings.EqualFold(question.Name, records.ptr.Header().Name):
+			if matched := matchingRecords([]dns.RR{records.ptr}, question.Qtype); len(matched) > 0 {
+				answers = append(answers, matched...)
+				extras = append(extras, records.srv, records.txt)
+				extras = append(extras, records.addresses...)
+			}
+		case strings.EqualFold(question.Name, records.srv.Header().Name):
+			answers = append(answers, matchingRecords([]dns.RR{records.srv, records.txt}, question.Qtype)...)
+			if question.Qtype == dns.TypeSRV || question.Qtype == dns.TypeANY {
+				extras = append(extras, records.addresses...)
+			}
+		case strings.EqualFold(question.Name, host):
+			answers = append(answers, matchingRecords(records.addresses, question.Qtype)...)
+		}
+	}
+	return answers, extras
+}
+
+// answerReverseQuestion Returns the reverse PTR records of instances answering question
+func answerReverseQuestion(instances map[string]ServiceInstance, question dns.Question) []dns.RR {
+	name := strings.ToLower(question.Name)
+	if !strings.HasSuffix(name, ".in-addr.arpa.") && !strings.HasSuffix(name, ".ip6.arpa.") {
+		return nil
+	}
+	answers := []dns.RR{}
+	for _, instance := range instances {
+		for _, record := range newInstanceRecords(instance, instance.TTL).reverseRecords() {
+			if strings.EqualFold(record.Header().Name, name) {
+				answers = append(answers, matchingRecords([]dns.RR{record}, question.Qtype)...)
+			}
+		}
+	}
+	return answers
+}
+
+// Answers Reports whether the responder has records named name, e.g. a published hostname
+// or service, in which case it answers the queries asking for it
+func (r *MDNSResponder) Answers(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	question := dns.Question{Name: name, Qtype: dns.TypeANY, Qclass: dns.ClassINET}
+	if answers, _ := answerQuestion(r.instances, question); len(answers) > 0 {
+		return true
+	}
+	return r.options.ReverseRecords && len(answerReverseQuestion(r.instances, question)) > 0
+}
+
+// nsecRecord Returns the NSEC record listing the types of the records of instances named name,
+// nil when no host or instance of ours has that name
+func nsecRecord(instances map[string]ServiceInstance, name string) dns.RR {
+	var nsec *dns.NSEC
+	for _, instance := range instances {
+		records := newInstanceRecords(instance, instance.TTL)
+		named := []dns.RR{}
+		if strings.EqualFold(records.srv.Header().Name, name) {
+			named = append(named, records.srv, records.txt)
+		} else if len(records.addresses) > 0 && strings.EqualFold(records.addresses[0].Header().Name, name) {
+			named = append(named, records.addresses...)
+		}
+		for _, record := range named {
+			header := record.Header()
+			if nsec == nil {
+				nsec = &dns.NSEC{Hdr: dns.RR_Header{Name: header.Name, Rrtype: dns.TypeNSEC, Class: header.Class, Ttl: header.Ttl}, NextDomain: header.Name}
+			}
+			nsec.TypeBitMap = appendType(nsec.TypeBitMap, header.Rrtype)
+		}
+	}
+	if nsec == nil {
+		return nil
+	}
+	return nsec
+}
+
+// nsecRecords Returns the NSEC records of the hosts and instances of the records, which save
+// queriers asking for the address family or records they do not have
+func nsecRecords(instances map[string]ServiceInstance, records []dns.RR) []dns.RR {
+	nsecs := []dns.RR{}
+	seen := map[string]bool{}
+	for _, record := range records {
+		switch record.Header().Rrtype {
+		case dns.TypeA, dns.TypeAAAA, dns.TypeSRV, dns.TypeTXT:
+		default:
+			continue
+		}
+		name := strings.ToLower(record.Header().Name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if nsec := nsecRecord(instances, name); nsec != nil {
+			nsecs = append(nsecs, nsec)
+		}
+	}
+	return nsecs
+}
+
+// appendType Adds rrtype to the sorted types unless they have it
+func appendType(types []uint16, rrtype uint16) []uint16 {
+	i := sort.Search(len(types), func(i int) bool { return types[i] >= rrtype })
+	if i < len(types) && types[i] == rrtype {
+		return types
+	}
+	types = append(types, 0)
+	copy(types[i+1:], types[i:])
+	types[i] = rrtype
+	return types
+}
+
+// records Returns the records multicast for instance with ttl, along with the reverse
+// records when they are published
+func (r *MDNSResponder) records(instance ServiceInstance, ttl uint32) []dns.RR {
+	records := newInstanceRecords(instance, ttl)
+	if !r.options.ReverseRecords {
+		return records.all()
+	}
+	return append(records.all(), records.reverseRecords()...)
+}
+
+// withoutKnownAnswers Drops the answers the querier listed as known with at least half of their
+// TTL left, known-answer suppression of RFC 6762 section 7.1
+func withoutKnownAnswers(answers []dns.RR, known []dns.RR) []dns.RR {
+	if len(known) == 0 {
+		return answers
+	}
+	knownTTLs := map[string]uint32{}
+	for _, record := range known {
+		knownTTLs[recordKey(record)] = record.Header().Ttl
+	}
+	unknown := []dns.RR{}
+	for _, record := range answers {
+		if ttl, exists := knownTTLs[recordKey(record)]; exists && ttl >= record.Header().Ttl/2 {
+			continue
+		}
+		unknown = append(unknown, record)
+	}
+	return unknown
+}
+
+// recordKey Returns the name, type and data of record, regardless of its TTL and cache flush bit
+func recordKey(record dns.RR) string {
+	key := dns.Copy(record)
+	key.Header().Ttl = 0
+	key.Header().Class &^= cacheFlushBit
+	key.Header().Name = strings.ToLower(key.Header().Name)
+	return key.String()
+}
+
+// matchingRecords Returns the records of type qtype, all of them for ANY
+func matchingRecords(records []dns.RR, qtype uint16) []dns.RR {
+	matched := []dns.RR{}
+	for _, record := range records {
+		if qtype == dns.TypeANY || record.Header().Rrtype == qtype {
+			matched = append(matched, record)
+		}
+	}
+	return matched
+}
+
+// uniqueRecords Drops duplicate records and those already in excluded
+func uniqueRecords(records []dns.RR, excluded []dns.RR) []dns.RR {
+	seen := map[string]bool{}
+	for _, record := range excluded {
+		seen[record.String()] = true
+	}
+	unique := []dns.RR{}
+	for _, record := range records {
+		if !seen[record.String()] {
+			seen[record.String()] = true
+			unique = append(unique, record)
+		}
+	}
+	return unique
+}
+
+// multicast Sends response on each of conns and ifaces, the caller holds the mutex
+func (r *MDNSResponder) multicast(response *dns.Msg, conns []*multicastConn, ifaces []net.Interface) {
+	packed, err := response.Pack()
+	if err != nil {
+		log.Errorf("Failed to pack mDNS response: %+v", err)
+		return
+	}
+	for _, conn := range conns {
+		for i := range ifaces {
+			if err := conn.send(packed, &ifaces[i]); err != nil {
+				log.Debugf("Failed to send mDNS response on %v: %+v", ifaces[i].Name, err)
+			}
+		}
+	}
+	now := time.Now()
+	for _, records := range [][]dns.RR{response.Answer, response.Extra} {
+		for _, record := range records {
+			for i := range ifaces {
+				if record.Header().Ttl == 0 {
+					delete(r.multicastAt, multicastKey(ifaces[i].Index, record))
+				} else {
+					r.multicastAt[multicastKey(ifaces[i].Index, record)] = now
+				}
+			}
+		}
+	}
+}
+
+// multicastKey Identifies record multicast on the interface at ifIndex in multicastAt
+func multicastKey(ifIndex int, record dns.RR) string {
+	return strconv.Itoa(ifIndex) + " " + recordKey(record)
+}
+
+// announce Multicasts the records of instance and repeats them after announceInterval,
+// unless the instance changed in the meantime. The caller holds the mutex
+func (r *MDNSResponder) announce(instance ServiceInstance) {
+	r.multicast(newResponse(r.records(instance, instance.TTL)), r.conns, r.ifaces)
+	time.AfterFunc(announceInterval, func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if current, exists := r.instances[instance.key()]; exists && reflect.DeepEqual(current, instance) {
+			r.multicast(newResponse(r.records(instance, instance.TTL)), r.conns, r.ifaces)
+		}
+	})
+}
+
+func (r *MDNSResponder) Publish(instance ServiceInstance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	previous, replaced := r.instances[instance.key()]
+	r.instances[instance.key()] = instance
+	if replaced && !reflect.DeepEqual(previous, instance) {
+		r.withdrawStale(previous)
+	}
+	r.announce(instance)
+	return nil
+}
+
+// withdrawStale Sends goodbyes for the records of previous, a replaced instance, that no
+// published instance has anymore. Its announced replacement sets the cache-flush bit, so
+// caches drop stale addresses they received too recently for the goodbyes to reach them
+// first. The caller holds the mutex
+func (r *MDNSResponder) withdrawStale(previous ServiceInstance) {
+	published := map[string]bool{}
+	for _, instance := range r.instances {
+		for _, record := range r.records(instance, instance.TTL) {
+			published[recordKey(record)] = true
+		}
+	}
+	stale := []dns.RR{}
+	for _, record := range r.records(previous, 0) {
+		if !published[recordKey(record)] {
+			stale = append(stale, record)
+		}
+	}
+	if len(stale) > 0 {
+		r.multicast(newResponse(stale), r.conns, r.ifaces)
+	}
+}
+
+// Unpublish Sends goodbyes for the records of instance, address records that another
+// instance of the same host still publishes are kept
+func (r *MDNSResponder) Unpublish(instance ServiceInstance) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.instances, instance.key())
+	stillPublished := map[string]bool{}
+	hostPublished := false
+	for _, other := range r.instances {
+		if other.hostKey() == instance.hostKey() {
+			hostPublished = true
+			for _, ip := range other.IPs {
+				stillPublished[ip] = true
+			}
+		}
+	}
+	if !hostPublished {
+		// Withdrawn hostnames leave the metrics, which would otherwise grow with every one published
+		mdnsQuestions.DeleteLabelValues(instance.Hostname + "." + instance.Domain)
+		mdnsAnswers.DeleteLabelValues(instance.Hostname + "." + instance.Domain)
+	}
+	withdrawn := instance
+	withdrawn.IPs = []string{}
+	for _, ip := range instance.IPs {
+		if !stillPublished[ip] {
+			withdrawn.IPs = append(withdrawn.IPs, ip)
+		}
+	}
+	r.multicast(newResponse(r.records(withdrawn, 0)), r.conns, r.ifaces)
+}
+
+// SetInterfaces Rejoins the mDNS groups on ifaces and announces every instance there
+func (r *MDNSResponder) SetInterfaces(ifaces []net.Interface) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closeConns()
+	if err := r.listen(ifaces); err != nil {
+		return err
+	}
+	for _, instance := range r.instances {
+		r.announce(instance)
+	}
+	return nil
+}
+
+// reannounce Multicasts the records of every instance once more
+func (r *MDNSResponder) reannounce() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.instances) == 0 {
+		return
+	}
+	log.Debugf("Re-announcing %v instances", len(r.instances))
+	for _, instance := range r.instances {
+		r.multicast(newResponse(r.records(instance, instance.TTL)), r.conns, r.ifaces)
+	}
+}
+
+// ReannounceEvery Re-announces all published instances every interval until ctx is done
+func (r *MDNSResponder) ReannounceEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reannounce()
+		}
+	}
+}
+
+func (r *MDNSResponder) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closeConns()
+}
+
+// closeConns Closes the sockets, which stops their serve loops. The caller holds the mutex
+func (r *MDNSResponder) closeConns() {
+	conns := r.conns
+	r.conns = nil
+	for _, conn := range conns {
+		conn.conn.Close()
+	}
+}
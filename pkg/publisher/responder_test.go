@@ -0,0 +1,461 @@
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testInterface The interface the test responders answer on. It need not exist, as their
+// multicasts are captured
+var testInterface = net.Interface{Index: 42, Name: "test0", Flags: net.FlagUp | net.FlagMulticast}
+
+// testResponder An MDNSResponder on testInterface capturing its multicasts, with the sockets of
+// an mDNS querier on port 5353 and of a legacy querier on the loopback interface
+type testResponder struct {
+	*MDNSResponder
+	conn       *multicastConn
+	multicasts []*dns.Msg
+	mdns       *net.UDPConn
+	legacy     *net.UDPConn
+}
+
+func newTestResponder(t *testing.T, options MDNSOptions, instances ...ServiceInstance) *testResponder {
+	t.Helper()
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	mdns, err := listenMulticastPort("udp4", &net.UDPAddr{IP: loopback.IP, Port: mdnsGroupIPv4.Port})
+	if err != nil {
+		t.Skipf("Port %v of the loopback interface is not available: %+v", mdnsGroupIPv4.Port, err)
+	}
+	t.Cleanup(func() { mdns.Close() })
+	sock, err := net.ListenUDP("udp4", loopback)
+	if err != nil {
+		t.Fatalf("Failed to open the socket of the responder: %+v", err)
+	}
+	t.Cleanup(func() { sock.Close() })
+	legacy, err := net.ListenUDP("udp4", loopback)
+	if err != nil {
+		t.Fatalf("Failed to open the socket of the legacy querier: %+v", err)
+	}
+	t.Cleanup(func() { legacy.Close() })
+	responder := &testResponder{mdns: mdns, legacy: legacy}
+	responder.conn = &multicastConn{conn: sock, send: func(packet []byte, iface *net.Interface) error {
+		msg := new(dns.Msg)
+		if err := msg.Unpack(packet); err != nil {
+			t.Errorf("Multicast a malformed packet: %+v", err)
+		}
+		responder.multicasts = append(responder.multicasts, msg)
+		return nil
+	}}
+	responder.MDNSResponder = &MDNSResponder{
+		ifaces:      []net.Interface{testInterface},
+		instances:   map[string]ServiceInstance{},
+		conns:       []*multicastConn{responder.conn},
+		options:     options,
+		multicastAt: map[string]time.Time{},
+		responses:   map[int]*responseWindow{},
+		prober:      NewMDNSProber(),
+	}
+	for _, instance := range instances {
+		responder.instances[instance.key()] = instance
+	}
+	return responder
+}
+
+// ask Hands query from the querier to the responder on the interface at ifIndex and returns
+// its response, multicast or unicast to the querier, nil when it does not respond
+func (r *testResponder) ask(t *testing.T, ifIndex int, from *net.UDPConn, query *dns.Msg) (*dns.Msg, string) {
+	t.Helper()
+	r.multicasts = nil
+	r.mutex.Lock()
+	r.answer(r.conn, ifIndex, from.LocalAddr(), query)
+	r.mutex.Unlock()
+	if len(r.multicasts) > 0 {
+		return r.multicasts[0], "multicast"
+	}
+	buf := make([]byte, 65536)
+	_ = from.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	n, err := from.Read(buf)
+	if err != nil {
+		return nil, ""
+	}
+	response := new(dns.Msg)
+	if err := response.Unpack(buf[:n]); err != nil {
+		t.Fatalf("Unicast a malformed packet: %+v", err)
+	}
+	return response, "unicast"
+}
+
+func testInstance(name string, serviceType string, ips ...string) ServiceInstance {
+	return ServiceInstance{Instance: name, ServiceType: serviceType, Domain: "local", Hostname: name, Port: 80, Text: []string{"path=/"}, IPs: ips, TTL: defaultRecordTTL}
+}
+
+func newQuery(questions ...dns.Question) *dns.Msg {
+	query := new(dns.Msg)
+	query.Id = dns.Id()
+	query.Question = questions
+	return query
+}
+
+func question(name string, qtype uint16) dns.Question {
+	return dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET}
+}
+
+// unicastQuestion Returns a question with the unicast-response bit set
+func unicastQuestion(name string, qtype uint16) dns.Question {
+	return dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET | cacheFlushBit}
+}
+
+func addressRecord(name string, ip string, ttl uint32) dns.RR {
+	return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: ttl}, A: net.ParseIP(ip)}
+}
+
+// summarize Returns the type, name, TTL and cache-flush bit of records, and the types an NSEC
+// record lists, sorted
+func summarize(records []dns.RR) []string {
+	summaries := []string{}
+	for _, record := range records {
+		header := record.Header()
+		summary := fmt.Sprintf("%v %v %v", dns.TypeToString[header.Rrtype], header.Name, header.Ttl)
+		if header.Class&cacheFlushBit != 0 {
+			summary += " cache-flush"
+		}
+		if nsec, ok := record.(*dns.NSEC); ok {
+			for _, rrtype := range nsec.TypeBitMap {
+				summary += " " + dns.TypeToString[rrtype]
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Strings(summaries)
+	return summaries
+}
+
+func TestAnswer(t *testing.T) {
+	instances := []ServiceInstance{testInstance("grafana", "_http._tcp", "10.0.0.1"), testInstance("prometheus", "_https._tcp", "10.0.0.2")}
+	instanceRecords := []string{
+		"NSEC grafana._http._tcp.local. 3200 cache-flush TXT SRV",
+		"SRV grafana._http._tcp.local. 3200 cache-flush",
+		"TXT grafana._http._tcp.local. 3200 cache-flush",
+	}
+	hostRecords := []string{"A grafana.local. 120 cache-flush", "NSEC grafana.local. 120 cache-flush A"}
+	tests := []struct {
+		name       string
+		options    MDNSOptions
+		questions  []dns.Question
+		known      []dns.RR
+		wantAnswer []string
+		wantExtra  []string
+	}{
+		{"address", MDNSOptions{}, []dns.Question{question("grafana.local.", dns.TypeA)}, nil,
+			[]string{"A grafana.local. 120 cache-flush"}, []string{"NSEC grafana.local. 120 cache-flush A"}},
+		{"address in other case", MDNSOptions{}, []dns.Question{question("GRAFANA.local.", dns.TypeA)}, nil,
+			[]string{"A grafana.local. 120 cache-flush"}, []string{"NSEC grafana.local. 120 cache-flush A"}},
+		{"missing address family", MDNSOptions{}, []dns.Question{question("grafana.local.", dns.TypeAAAA)}, nil,
+			[]string{"NSEC grafana.local. 120 cache-flush A"}, []string{}},
+		{"address and missing address family", MDNSOptions{}, []dns.Question{question("grafana.local.", dns.TypeA), question("grafana.local.", dns.TypeAAAA)}, nil,
+			[]string{"A grafana.local. 120 cache-flush", "NSEC grafana.local. 120 cache-flush A"}, []string{}},
+		{"service", MDNSOptions{}, []dns.Question{question("_http._tcp.local.", dns.TypePTR)}, nil,
+			[]string{"PTR _http._tcp.local. 3200"}, append(append([]string{}, hostRecords...), instanceRecords...)},
+		{"instance", MDNSOptions{}, []dns.Question{question("grafana._http._tcp.local.", dns.TypeSRV)}, nil,
+			[]string{"SRV grafana._http._tcp.local. 3200 cache-flush"}, append(append([]string{}, hostRecords...), instanceRecords[0])},
+		{"any record of the instance", MDNSOptions{}, []dns.Question{question("grafana._http._tcp.local.", dns.TypeANY)}, nil,
+			instanceRecords[1:], append(append([]string{}, hostRecords...), instanceRecords[0])},
+		{"missing record of the instance", MDNSOptions{}, []dns.Question{question("grafana._http._tcp.local.", dns.TypeA)}, nil,
+			instanceRecords[:1], []string{}},
+		{"service enumeration", MDNSOptions{}, []dns.Question{question("_services._dns-sd._udp.local.", dns.TypePTR)}, nil,
+			[]string{"PTR _services._dns-sd._udp.local. 3200", "PTR _services._dns-sd._udp.local. 3200"}, []string{}},
+		{"unknown name", MDNSOptions{}, []dns.Question{question("loki.local.", dns.TypeA)}, nil, nil, nil},
+		{"any record of an unknown name", MDNSOptions{}, []dns.Question{question("loki.local.", dns.TypeANY)}, nil, nil, nil},
+		{"reverse lookup", MDNSOptions{ReverseRecords: true}, []dns.Question{question("1.0.0.10.in-addr.arpa.", dns.TypePTR)}, nil,
+			[]string{"PTR 1.0.0.10.in-addr.arpa. 120"}, []string{}},
+		{"reverse lookup without reverse records", MDNSOptions{}, []dns.Question{question("1.0.0.10.in-addr.arpa.", dns.TypePTR)}, nil, nil, nil},
+		{"known answer", MDNSOptions{}, []dns.Question{question("grafana.local.", dns.TypeA)}, []dns.RR{addressRecord("grafana.local.", "10.0.0.1", 120)}, nil, nil},
+		{"known answer past half of its TTL", MDNSOptions{}, []dns.Question{question("grafana.local.", dns.TypeA)}, []dns.RR{addressRecord("grafana.local.", "10.0.0.1", 59)},
+			[]string{"A grafana.local. 120 cache-flush"}, []string{"NSEC grafana.local. 120 cache-flush A"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responder := newTestResponder(t, test.options, instances...)
+			query := newQuery(test.questions...)
+			query.Answer = test.known
+			response, delivery := responder.ask(t, testInterface.Index, responder.mdns, query)
+			if test.wantAnswer == nil {
+				if response != nil {
+					t.Fatalf("answer() sent %v %+v, want no response", delivery, response)
+				}
+				return
+			}
+			if delivery != "multicast" {
+				t.Fatalf("answer() sent %q, want a multicast", delivery)
+			}
+			if !response.Response || !response.Authoritative || response.Id != 0 || len(response.Question) != 0 {
+				t.Errorf("answer() header = %+v, want an authoritative response without ID and questions", response.MsgHdr)
+			}
+			if got := summarize(response.Answer); !reflect.DeepEqual(got, test.wantAnswer) {
+				t.Errorf("answer() answers = %q, want %q", got, test.wantAnswer)
+			}
+			sort.Strings(test.wantExtra)
+			if got := summarize(response.Extra); !reflect.DeepEqual(got, test.wantExtra) {
+				t.Errorf("answer() additional records = %q, want %q", got, test.wantExtra)
+			}
+		})
+	}
+}
+
+func TestAnswerDelivery(t *testing.T) {
+	address := question("grafana.local.", dns.TypeA)
+	instance := question("grafana._http._tcp.local.", dns.TypeSRV)
+	probe := newQuery(question("grafana.local.", dns.TypeANY))
+	probe.Ns = []dns.RR{addressRecord("grafana.local.", "10.0.0.9", 120)}
+	tests := []struct {
+		name string
+		// before The queries of the mDNS querier answered first
+		before  []*dns.Msg
+		ifIndex int
+		legacy  bool
+		query   *dns.Msg
+		want    string
+	}{
+		{"multicast", nil, testInterface.Index, false, newQuery(address), "multicast"},
+		{"other interface", nil, testInterface.Index + 1, false, newQuery(address), ""},
+		{"multicast within a second", []*dns.Msg{newQuery(address)}, testInterface.Index, false, newQuery(address), ""},
+		{"other record within a second", []*dns.Msg{newQuery(address)}, testInterface.Index, false, newQuery(instance), "multicast"},
+		{"probe within a second", []*dns.Msg{newQuery(address)}, testInterface.Index, false, probe, "multicast"},
+		{"unicast question not multicast recently", nil, testInterface.Index, false, newQuery(unicastQuestion("grafana.local.", dns.TypeA)), "multicast"},
+		{"unicast question multicast recently", []*dns.Msg{newQuery(address)}, testInterface.Index, false, newQuery(unicastQuestion("grafana.local.", dns.TypeA)), "unicast"},
+		{"unicast and multicast questions", []*dns.Msg{newQuery(address)}, testInterface.Index, false, newQuery(unicastQuestion("grafana.local.", dns.TypeA), instance), "multicast"},
+		{"legacy", nil, testInterface.Index, true, newQuery(address), "unicast"},
+		{"legacy within a second", []*dns.Msg{newQuery(address)}, testInterface.Index, true, newQuery(address), "unicast"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responder := newTestResponder(t, MDNSOptions{}, testInstance("grafana", "_http._tcp", "10.0.0.1"))
+			for _, query := range test.before {
+				if _, delivery := responder.ask(t, testInterface.Index, responder.mdns, query); delivery != "multicast" {
+					t.Fatalf("answer() sent %q before, want a multicast", delivery)
+				}
+			}
+			from := responder.mdns
+			if test.legacy {
+				from = responder.legacy
+			}
+			if _, delivery := responder.ask(t, test.ifIndex, from, test.query); delivery != test.want {
+				t.Errorf("answer() sent %q, want %q", delivery, test.want)
+			}
+		})
+	}
+}
+
+func TestAnswerLegacyQuery(t *testing.T) {
+	responder := newTestResponder(t, MDNSOptions{}, testInstance("grafana", "_http._tcp", "10.0.0.1"))
+	query := newQuery(question("grafana._http._tcp.local.", dns.TypeSRV))
+	response, delivery := responder.ask(t, testInterface.Index, responder.legacy, query)
+	if delivery != "unicast" {
+		t.Fatalf("answer() sent %q, want a unicast", delivery)
+	}
+	if response.Id != query.Id || !reflect.DeepEqual(response.Question, query.Question) {
+		t.Errorf("answer() ID and questions = %v %v, want %v %v", response.Id, response.Question, query.Id, query.Question)
+	}
+	if got, want := summarize(response.Answer), []string{"SRV grafana._http._tcp.local. 10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("answer() answers = %q, want %q", got, want)
+	}
+	want := []string{"A grafana.local. 10", "NSEC grafana._http._tcp.local. 10 TXT SRV", "NSEC grafana.local. 10 A"}
+	if got := summarize(response.Extra); !reflect.DeepEqual(got, want) {
+		t.Errorf("answer() additional records = %q, want %q", got, want)
+	}
+}
+
+func TestLegacyResponse(t *testing.T) {
+	tests := []struct {
+		ttl     uint32
+		wantTTL uint32
+	}{
+		{defaultRecordTTL, legacyRecordTTL},
+		{addressRecordTTL, legacyRecordTTL},
+		{legacyRecordTTL, legacyRecordTTL},
+		{5, 5},
+		{0, 0},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.ttl), func(t *testing.T) {
+			record := addressRecord("grafana.local.", "10.0.0.1", test.ttl)
+			query := newQuery(question("grafana.local.", dns.TypeA))
+			response := newResponse([]dns.RR{record})
+			response.Extra = []dns.RR{dns.Copy(record)}
+			legacy := legacyResponse(query, response)
+			if legacy.Id != query.Id || !reflect.DeepEqual(legacy.Question, query.Question) || !legacy.Response || !legacy.Authoritative {
+				t.Errorf("legacyResponse() header = %+v %v, want the ID and questions of the query", legacy.MsgHdr, legacy.Question)
+			}
+			for _, records := range [][]dns.RR{legacy.Answer, legacy.Extra} {
+				if header := records[0].Header(); header.Ttl != test.wantTTL || header.Class != dns.ClassINET {
+					t.Errorf("legacyResponse() TTL and class = %v %v, want %v %v", header.Ttl, header.Class, test.wantTTL, dns.ClassINET)
+				}
+			}
+			if header := record.Header(); header.Ttl != test.ttl || header.Class != dns.ClassINET|cacheFlushBit {
+				t.Errorf("legacyResponse() changed the record of the response to %v", record)
+			}
+		})
+	}
+}
+
+func TestAllowResponse(t *testing.T) {
+	other := net.Interface{Index: testInterface.Index + 1, Name: "test1"}
+	tests := []struct {
+		name  string
+		rate  int
+		calls []*net.Interface
+		// elapsed How far the windows are moved back before the last call
+		elapsed time.Duration
+		want    []bool
+	}{
+		{"unlimited", 0, []*net.Interface{&testInterface, &testInterface, &testInterface}, 0, []bool{true, true, true}},
+		{"within the rate", 2, []*net.Interface{&testInterface, &testInterface}, 0, []bool{true, true}},
+		{"beyond the rate", 2, []*net.Interface{&testInterface, &testInterface, &testInterface, &testInterface}, 0, []bool{true, true, false, false}},
+		{"per interface", 1, []*net.Interface{&testInterface, &other, &testInterface}, 0, []bool{true, true, false}},
+		{"next second", 1, []*net.Interface{&testInterface, &testInterface, &testInterface}, time.Second, []bool{true, false, true}},
+		{"within the second", 1, []*net.Interface{&testInterface, &testInterface, &testInterface}, time.Millisecond * 900, []bool{true, false, false}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responder := &MDNSResponder{options: MDNSOptions{ResponseRate: test.rate}, responses: map[int]*responseWindow{}}
+			got := []bool{}
+			for i, iface := range test.calls {
+				if i == len(test.calls)-1 {
+					for _, window := range responder.responses {
+						window.start = window.start.Add(-test.elapsed)
+					}
+				}
+				got = append(got, responder.allowResponse(iface))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("allowResponse() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAnswerResponseRate(t *testing.T) {
+	responder := newTestResponder(t, MDNSOptions{ResponseRate: 1}, testInstance("grafana", "_http._tcp", "10.0.0.1"))
+	if _, delivery := responder.ask(t, testInterface.Index, responder.mdns, newQuery(question("grafana.local.", dns.TypeA))); delivery != "multicast" {
+		t.Fatalf("answer() sent %q, want a multicast", delivery)
+	}
+	if _, delivery := responder.ask(t, testInterface.Index, responder.legacy, newQuery(question("grafana.local.", dns.TypeA))); delivery != "" {
+		t.Errorf("answer() sent %q beyond the response rate, want no response", delivery)
+	}
+}
+
+func TestWithoutKnownAnswers(t *testing.T) {
+	answer := addressRecord("grafana.local.", "10.0.0.1", addressRecordTTL)
+	tests := []struct {
+		name  string
+		known []dns.RR
+		want  int
+	}{
+		{"no known answers", nil, 1},
+		{"known", []dns.RR{addressRecord("grafana.local.", "10.0.0.1", addressRecordTTL)}, 0},
+		{"known with half of its TTL", []dns.RR{addressRecord("grafana.local.", "10.0.0.1", addressRecordTTL/2)}, 0},
+		{"known with less than half of its TTL", []dns.RR{addressRecord("grafana.local.", "10.0.0.1", addressRecordTTL/2-1)}, 1},
+		{"known in other case without cache-flush bit", []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "Grafana.Local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: addressRecordTTL}, A: net.ParseIP("10.0.0.1")}}, 0},
+		{"other address known", []dns.RR{addressRecord("grafana.local.", "10.0.0.2", addressRecordTTL)}, 1},
+		{"other name known", []dns.RR{addressRecord("prometheus.local.", "10.0.0.1", addressRecordTTL)}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := withoutKnownAnswers([]dns.RR{answer}, test.known); len(got) != test.want {
+				t.Errorf("withoutKnownAnswers() = %v, want %v records", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewInstanceRecords(t *testing.T) {
+	instance := testInstance("grafana", "_http._tcp", "10.0.0.1", "fd00::1")
+	tests := []struct {
+		name       string
+		addressTTL uint32
+		ttl        uint32
+		want       []string
+	}{
+		{"published", 0, defaultRecordTTL, []string{
+			"A grafana.local. 120 cache-flush",
+			"AAAA grafana.local. 120 cache-flush",
+			"PTR _http._tcp.local. 3200",
+			"SRV grafana._http._tcp.local. 3200 cache-flush",
+			"TXT grafana._http._tcp.local. 3200 cache-flush",
+		}},
+		{"address TTL", 60, defaultRecordTTL, []string{
+			"A grafana.local. 60 cache-flush",
+			"AAAA grafana.local. 60 cache-flush",
+			"PTR _http._tcp.local. 3200",
+			"SRV grafana._http._tcp.local. 3200 cache-flush",
+			"TXT grafana._http._tcp.local. 3200 cache-flush",
+		}},
+		{"goodbye", 60, 0, []string{
+			"A grafana.local. 0",
+			"AAAA grafana.local. 0",
+			"PTR _http._tcp.local. 0",
+			"SRV grafana._http._tcp.local. 0",
+			"TXT grafana._http._tcp.local. 0",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance.AddressTTL = test.addressTTL
+			if got := summarize(newInstanceRecords(instance, test.ttl).all()); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("newInstanceRecords() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAnswerMetrics(t *testing.T) {
+	mdnsQuestions.Reset()
+	mdnsAnswers.Reset()
+	grafana := testInstance("grafana", "_http._tcp", "10.0.0.1")
+	responder := newTestResponder(t, MDNSOptions{}, grafana, testInstance("prometheus", "_https._tcp", "10.0.0.2"))
+	known := newQuery(question("grafana.local.", dns.TypeA))
+	known.Answer = []dns.RR{addressRecord("grafana.local.", "10.0.0.1", addressRecordTTL)}
+	tests := []struct {
+		name          string
+		legacy        bool
+		query         *dns.Msg
+		wantQuestions float64
+		wantAnswers   float64
+	}{
+		{"address", false, newQuery(question("grafana.local.", dns.TypeA)), 1, 1},
+		{"instance", false, newQuery(question("grafana._http._tcp.local.", dns.TypeTXT)), 2, 2},
+		{"service", false, newQuery(question("_http._tcp.local.", dns.TypePTR)), 3, 3},
+		{"missing address family", true, newQuery(question("grafana.local.", dns.TypeAAAA)), 4, 4},
+		{"multicast within a second", false, newQuery(question("grafana.local.", dns.TypeA)), 5, 4},
+		{"known answer", false, known, 6, 4},
+		{"other hostname", false, newQuery(question("prometheus.local.", dns.TypeA)), 6, 4},
+		{"unknown name", false, newQuery(question("loki.local.", dns.TypeA)), 6, 4},
+		{"every question", true, newQuery(question("grafana.local.", dns.TypeA), question("grafana._http._tcp.local.", dns.TypeSRV)), 8, 6},
+	}
+	for _, test := range tests {
+		from := responder.mdns
+		if test.legacy {
+			from = responder.legacy
+		}
+		responder.ask(t, testInterface.Index, from, test.query)
+		questions := testutil.ToFloat64(mdnsQuestions.WithLabelValues("grafana.local"))
+		answers := testutil.ToFloat64(mdnsAnswers.WithLabelValues("grafana.local"))
+		if questions != test.wantQuestions || answers != test.wantAnswers {
+			t.Errorf("After %v the metrics of grafana.local count %v questions and %v answers, want %v and %v", test.name, questions, answers, test.wantQuestions, test.wantAnswers)
+		}
+	}
+	if got := testutil.ToFloat64(mdnsAnswers.WithLabelValues("prometheus.local")); got != 1 {
+		t.Errorf("The metrics of prometheus.local count %v answers, want 1", got)
+	}
+	responder.Unpublish(grafana)
+	if got := testutil.CollectAndCount(mdnsQuestions); got != 1 {
+		t.Errorf("After unpublishing grafana the questions count %v hostnames, want 1", got)
+	}
+}
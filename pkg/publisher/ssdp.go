@@ -0,0 +1,265 @@
+package publisher
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ssdpMaxAge How long control points cache an advertisement, it is repeated at half of that
+	ssdpMaxAge = time.Minute * 30
+	ssdpServer = "Linux UPnP/1.1 ingress-frontend-zeroconf"
+)
+
+var ssdpGroup = &net.UDPAddr{IP: net.ParseIP("239.255.255.250"), Port: 1900}
+
+// ssdpDevice The root device advertised for an instance with a device description
+type ssdpDevice struct {
+	uuid     string
+	location string
+}
+
+// SSDPAnnouncer Advertises the instances with a device description over SSDP, for smart TVs and
+// DLNA apps that only discover devices that way. The description is served by the backend of the
+// instance, the announcer points control points at it through the ingress
+type SSDPAnnouncer struct {
+	mutex     sync.Mutex
+	ifaces    []net.Interface
+	instances map[string]ServiceInstance
+	conns     []*multicastConn
+	stop      chan struct{}
+}
+
+// NewSSDPAnnouncer Listens for SSDP searches on ifaces
+func NewSSDPAnnouncer(ifaces []net.Interface) (*SSDPAnnouncer, error) {
+	announcer := &SSDPAnnouncer{instances: map[string]ServiceInstance{}, stop: make(chan struct{})}
+	if err := announcer.listen(ifaces); err != nil {
+		return nil, err
+	}
+	go announcer.advertiseEvery(ssdpMaxAge / 2)
+	return announcer, nil
+}
+
+// ssdpDeviceOf Returns the device advertised for instance, the uuid is derived from the location so
+// that it does not change across restarts
+func ssdpDeviceOf(instance ServiceInstance) ssdpDevice {
+	scheme := "http"
+	if instance.ServiceType == hostname.ServiceTypeHTTPS {
+		scheme = "https"
+	}
+	location := fmt.Sprintf("%v://%v.%v:%v%v", scheme, instance.Hostname, instance.Domain, instance.Port, instance.SSDPDescription)
+	return ssdpDevice{uuid: nameUUID(location), location: location}
+}
+
+// nameUUID Returns a name based version 5 UUID, which stays the same across restarts
+func nameUUID(name string) string {
+	sum := sha1.Sum([]byte(name))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// devices Returns the distinct devices of the instances, the caller holds the mutex
+func (a *SSDPAnnouncer) devices() []ssdpDevice {
+	devices := []ssdpDevice{}
+	seen := map[string]bool{}
+	for _, instance := range a.instances {
+		device := ssdpDeviceOf(instance)
+		if !seen[device.uuid] {
+			seen[device.uuid] = true
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// listen Joins the SSDP group on ifaces and starts answering searches, the caller holds the mutex
+func (a *SSDPAnnouncer) listen(ifaces []net.Interface) error {
+	a.ifaces = ifaces
+	if len(ifaces) == 0 {
+		return nil
+	}
+	conn, err := listenMulticast4(ifaces, ssdpGroup)
+	if err != nil {
+		return err
+	}
+	a.conns = []*multicastConn{conn}
+	go a.serve(conn)
+	return nil
+}
+
+// serve Answers the M-SEARCH requests arriving on conn until it is closed
+func (a *SSDPAnnouncer) serve(conn *multicastConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, ifIndex, src, err := conn.read(buf)
+		if err != nil {
+			if !a.isOpen(conn) {
+				return
+			}
+			log.Debugf("Failed to read SSDP packet: %+v", err)
+			continue
+		}
+		request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || request.Method != "M-SEARCH" || request.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		a.mutex.Lock()
+		responses := a.searchResponses(ifIndex, request.Header.Get("ST"))
+		a.mutex.Unlock()
+		for _, response := range responses {
+			if _, err := conn.conn.WriteTo(response, src); err != nil {
+				log.Debugf("Failed to answer SSDP search of %v: %+v", src, err)
+			}
+		}
+	}
+}
+
+func (a *SSDPAnnouncer) isOpen(conn *multicastConn) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, open := range a.conns {
+		if open == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// searchResponses Returns the responses to a search for target arriving on the interface
+// ifIndex, none for interfaces other than ours. The caller holds the mutex
+func (a *SSDPAnnouncer) searchResponses(ifIndex int, target string) [][]byte {
+	ours := false
+	for _, iface := range a.ifaces {
+		ours = ours || iface.Index == ifIndex
+	}
+	responses := [][]byte{}
+	if !ours {
+		return responses
+	}
+	for _, device := range a.devices() {
+		for _, nt := range []string{"upnp:rootdevice", "uuid:" + device.uuid} {
+			if target != "ssdp:all" && target != nt {
+				continue
+			}
+			responses = append(responses, []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%v\r\nEXT:\r\nLOCATION: %v\r\nSERVER: %v\r\nST: %v\r\nUSN: %v\r\n\r\n",
+				int(ssdpMaxAge/time.Second), device.location, ssdpServer, nt, ssdpUSN(device, nt))))
+		}
+	}
+	return responses
+}
+
+func ssdpUSN(device ssdpDevice, nt string) string {
+	if strings.HasPrefix(nt, "uuid:") {
+		return nt
+	}
+	return "uuid:" + device.uuid + "::" + nt
+}
+
+// notify Multicasts ssdp:alive or ssdp:byebye for device on every interface, the caller holds the mutex
+func (a *SSDPAnnouncer) notify(device ssdpDevice, nts string) {
+	for _, nt := range []string{"upnp:rootdevice", "uuid:" + device.uuid} {
+		message := fmt.Sprintf("NOTIFY * HTTP/1.1\r\nHOST: %v\r\nNT: %v\r\nNTS: %v\r\nUSN: %v\r\n", ssdpGroup, nt, nts, ssdpUSN(device, nt))
+		if nts == "ssdp:alive" {
+			message += fmt.Sprintf("CACHE-CONTROL: max-age=%v\r\nLOCATION: %v\r\nSERVER: %v\r\n", int(ssdpMaxAge/time.Second), device.location, ssdpServer)
+		}
+		message += "\r\n"
+		for _, conn := range a.conns {
+			for i := range a.ifaces {
+				if err := conn.send([]byte(message), &a.ifaces[i]); err != nil {
+					log.Debugf("Failed to send SSDP notification on %v: %+v", a.ifaces[i].Name, err)
+				}
+			}
+		}
+	}
+}
+
+// advertiseEvery Repeats the ssdp:alive notifications of all devices every interval until close is called
+func (a *SSDPAnnouncer) advertiseEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		a.mutex.Lock()
+		for _, device := range a.devices() {
+			a.notify(device, "ssdp:alive")
+		}
+		a.mutex.Unlock()
+	}
+}
+
+// Publish Advertises instance if it has a device description, other instances are ignored
+func (a *SSDPAnnouncer) Publish(instance ServiceInstance) error {
+	if instance.SSDPDescription == "" {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.instances[instance.key()] = instance
+	a.notify(ssdpDeviceOf(instance), "ssdp:alive")
+	return nil
+}
+
+// Unpublish Sends ssdp:byebye for the device of instance unless another instance still advertises it
+func (a *SSDPAnnouncer) Unpublish(instance ServiceInstance) {
+	if instance.SSDPDescription == "" {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.instances, instance.key())
+	device := ssdpDeviceOf(instance)
+	for _, remaining := range a.devices() {
+		if remaining.uuid == device.uuid {
+			return
+		}
+	}
+	a.notify(device, "ssdp:byebye")
+}
+
+func (a *SSDPAnnouncer) SetInterfaces(ifaces []net.Interface) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.closeConns()
+	if err := a.listen(ifaces); err != nil {
+		return err
+	}
+	for _, device := range a.devices() {
+		a.notify(device, "ssdp:alive")
+	}
+	return nil
+}
+
+// Close Sends ssdp:byebye for every device and stops advertising
+func (a *SSDPAnnouncer) Close() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, device := range a.devices() {
+		a.notify(device, "ssdp:byebye")
+	}
+	close(a.stop)
+	a.closeConns()
+}
+
+// closeConns Closes the sockets, which stops their serve loops. The caller holds the mutex
+func (a *SSDPAnnouncer) closeConns() {
+	conns := a.conns
+	a.conns = nil
+	for _, conn := range conns {
+		conn.conn.Close()
+	}
+}
@@ -0,0 +1,55 @@
+package publisher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LoadState Reads the instances published by a previous run, there are none without a state file
+func LoadState(path string) ([]ServiceInstance, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	instances := []ServiceInstance{}
+	if err := json.Unmarshal(content, &instances); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// saveState Replaces the state file with instances
+func saveState(path string, instances []ServiceInstance) error {
+	content, err := json.Marshal(instances)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, content, 0600)
+}
+
+// replaceFile Replaces the file at path through a rename, so that a crash never
+// leaves a partially written file behind and readers never see one
+func replaceFile(path string, content []byte, mode os.FileMode) error {
+	temporary, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Chmod(mode); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporary.Name(), path)
+}
@@ -0,0 +1,153 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// annotationStatus Written with --write-status, lists the hostnames published for the ingress
+const annotationStatus = hostname.AnnotationPrefix + "status"
+
+// statusRetryInterval How long to wait before retrying failed status updates
+const statusRetryInterval = time.Second * 10
+
+// ingressStatus The hostnames published for an ingress and their addresses
+type ingressStatus struct {
+	ref       v1.ObjectReference
+	hostnames []string
+	ips       []string
+}
+
+// value Returns the status annotation, e.g. published=grafana.local,prometheus.local; ip=192.168.1.50
+func (status ingressStatus) value() string {
+	return fmt.Sprintf("published=%v; ip=%v", strings.Join(uniqueSortedStrings(status.hostnames), ","), strings.Join(uniqueSortedStrings(status.ips), ","))
+}
+
+// IngressStatusWriter Writes the hostnames published for each ingress to its status annotation,
+// in the background so that the registry is not held up by the API. The annotation is removed
+// once an ingress does not claim any hostname anymore
+type IngressStatusWriter struct {
+	mutex   sync.Mutex
+	client  dynamic.Interface
+	desired map[string]ingressStatus
+	changed chan struct{}
+	stop    chan struct{}
+}
+
+// NewIngressStatusWriter Starts writing status annotations through client, for Registry.Status
+func NewIngressStatusWriter(client dynamic.Interface) *IngressStatusWriter {
+	writer := &IngressStatusWriter{
+		client:  client,
+		desired: map[string]ingressStatus{},
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	go writer.syncLoop()
+	return writer
+}
+
+// update Replaces the statuses to write, keyed by namespace/name of the ingress
+func (w *IngressStatusWriter) update(statuses map[string]ingressStatus) {
+	w.mutex.Lock()
+	w.desired = statuses
+	w.mutex.Unlock()
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Close Stops writing, the annotations are left in place
+func (w *IngressStatusWriter) Close() {
+	close(w.stop)
+}
+
+// syncLoop Patches the annotations whenever the statuses changed until close is called
+func (w *IngressStatusWriter) syncLoop() {
+	written := map[string]ingressStatus{}
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.changed:
+		}
+		for !w.sync(written) {
+			log.Errorf("Failed to write ingress status annotations, retrying in %v", statusRetryInterval)
+			select {
+			case <-w.stop:
+				return
+			case <-time.After(statusRetryInterval):
+			}
+		}
+	}
+}
+
+// sync Patches the annotations that differ from written, reports whether all patches succeeded
+func (w *IngressStatusWriter) sync(written map[string]ingressStatus) bool {
+	w.mutex.Lock()
+	desired := w.desired
+	w.mutex.Unlock()
+
+	succeeded := true
+	for key, status := range desired {
+		if previous, exists := written[key]; exists && previous.value() == status.value() {
+			continue
+		}
+		if err := w.patch(status.ref, status.value()); err != nil {
+			log.WithFields(log.Fields{"namespace": status.ref.Namespace, "ingress": status.ref.Name}).Errorf("Failed to write status of ingress %v: %+v", key, err)
+			succeeded = false
+			continue
+		}
+		written[key] = status
+	}
+	for key, status := range written {
+		if _, exists := desired[key]; exists {
+			continue
+		}
+		if err := w.patch(status.ref, ""); err != nil {
+			log.WithFields(log.Fields{"namespace": status.ref.Namespace, "ingress": status.ref.Name}).Errorf("Failed to remove status of ingress %v: %+v", key, err)
+			succeeded = false
+			continue
+		}
+		delete(written, key)
+	}
+	return succeeded
+}
+
+// patch Sets the status annotation of the ingress ref refers to, an empty value removes it.
+// Deleted ingresses are no error
+func (w *IngressStatusWriter) patch(ref v1.ObjectReference, value string) error {
+	groupVersion, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return err
+	}
+	var annotation interface{}
+	if value != "" {
+		annotation = value
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{annotationStatus: annotation}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.client.Resource(groupVersion.WithResource("ingresses")).Namespace(ref.Namespace).
+		Patch(context.TODO(), ref.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
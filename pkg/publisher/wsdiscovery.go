@@ -0,0 +1,373 @@
+package publisher
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	wsdActionHello          = "http://schemas.xmlsoap.org/ws/2005/04/discovery/Hello"
+	wsdActionBye            = "http://schemas.xmlsoap.org/ws/2005/04/discovery/Bye"
+	wsdActionProbeMatches   = "http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches"
+	wsdActionResolveMatches = "http://schemas.xmlsoap.org/ws/2005/04/discovery/ResolveMatches"
+	wsdTo                   = "urn:schemas-xmlsoap-org:ws:2005:04:discovery"
+	wsdAnonymous            = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+)
+
+var (
+	wsdGroupIPv4 = &net.UDPAddr{IP: net.ParseIP("239.255.255.250"), Port: 3702}
+	wsdGroupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::c"), Port: 3702}
+	// wsdNamespaces The prefixes the types of the ws-discovery annotation may use
+	wsdNamespaces = map[string]string{
+		"dn":   "http://www.onvif.org/ver10/network/wsdl",
+		"tds":  "http://www.onvif.org/ver10/device/wsdl",
+		"wsdp": "http://schemas.xmlsoap.org/ws/2006/02/devprof",
+		"pub":  "http://schemas.microsoft.com/windows/pub/2005/07",
+	}
+)
+
+// WSDTypes Normalizes the space separated types of the ws-discovery annotation, reports whether
+// all of them have a known prefix
+func WSDTypes(annotated string) (string, bool) {
+	types := strings.Fields(annotated)
+	for _, qname := range types {
+		parts := strings.SplitN(qname, ":", 2)
+		if len(parts) != 2 || parts[1] == "" || wsdNamespaces[parts[0]] == "" {
+			return "", false
+		}
+	}
+	return strings.Join(types, " "), len(types) > 0
+}
+
+// wsdEndpoint The target service advertised for an instance with WS-Discovery types
+type wsdEndpoint struct {
+	address string
+	types   string
+	xaddr   string
+}
+
+// WSDAnnouncer Advertises the instances with WS-Discovery types over SOAP-over-UDP, for the
+// Network folder of Windows and ONVIF clients, and answers their Probe and Resolve messages
+type WSDAnnouncer struct {
+	mutex     sync.Mutex
+	ifaces    []net.Interface
+	instances map[string]ServiceInstance
+	conns     []*multicastConn
+	// instanceID and messageNumber make up the AppSequence of the sent messages
+	instanceID    int64
+	messageNumber int
+}
+
+// NewWSDAnnouncer Listens for WS-Discovery probes on ifaces
+func NewWSDAnnouncer(ifaces []net.Interface) (*WSDAnnouncer, error) {
+	announcer := &WSDAnnouncer{instances: map[string]ServiceInstance{}, instanceID: time.Now().Unix()}
+	if err := announcer.listen(ifaces); err != nil {
+		return nil, err
+	}
+	return announcer, nil
+}
+
+// wsdEndpointOf Returns the endpoint advertised for instance, reachable through the ingress at
+// the path of its TXT record
+func wsdEndpointOf(instance ServiceInstance) wsdEndpoint {
+	scheme := "http"
+	if instance.ServiceType == hostname.ServiceTypeHTTPS {
+		scheme = "https"
+	}
+	path := "/"
+	for _, entry := range instance.Text {
+		if strings.HasPrefix(entry, "path=") {
+			path = strings.TrimPrefix(entry, "path=")
+		}
+	}
+	xaddr := fmt.Sprintf("%v://%v.%v:%v%v", scheme, instance.Hostname, instance.Domain, instance.Port, path)
+	return wsdEndpoint{address: "urn:uuid:" + nameUUID(xaddr), types: instance.WSDiscoveryTypes, xaddr: xaddr}
+}
+
+// endpoints Returns the distinct endpoints of the instances, the caller holds the mutex
+func (a *WSDAnnouncer) endpoints() []wsdEndpoint {
+	endpoints := []wsdEndpoint{}
+	seen := map[string]bool{}
+	for _, instance := range a.instances {
+		endpoint := wsdEndpointOf(instance)
+		if !seen[endpoint.address] {
+			seen[endpoint.address] = true
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// listen Joins the WS-Discovery groups on ifaces and starts answering probes, the caller holds the mutex
+func (a *WSDAnnouncer) listen(ifaces []net.Interface) error {
+	a.ifaces = ifaces
+	if len(ifaces) == 0 {
+		return nil
+	}
+	conns, err := listenMulticastGroups(ifaces, wsdGroupIPv4, wsdGroupIPv6)
+	if err != nil {
+		return err
+	}
+	a.conns = conns
+	for _, conn := range a.conns {
+		go a.serve(conn)
+	}
+	return nil
+}
+
+// wsdRequest The parts of a Probe or Resolve message the announcer looks at
+type wsdRequest struct {
+	action    string
+	messageID string
+	// types The local names of the probed types, address the resolved endpoint
+	types   []string
+	address string
+}
+
+// parseWSDRequest Picks the message id and the probed types or the resolved address out of a
+// SOAP envelope, the body element names the request
+func parseWSDRequest(packet []byte) (wsdRequest, error) {
+	request := wsdRequest{}
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	path := []string{}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if len(path) == 0 && request.action != "" {
+				return request, nil
+			}
+			return request, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			path = append(path, element.Name.Local)
+			if len(path) == 3 && path[1] == "Body" {
+				request.action = element.Name.Local
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			text := strings.TrimSpace(string(element))
+			switch {
+			case len(path) == 0:
+			case path[len(path)-1] == "MessageID":
+				request.messageID = text
+			case path[len(path)-1] == "Types" && request.action == "Probe":
+				for _, qname := range strings.Fields(text) {
+					request.types = append(request.types, qname[strings.Index(qname, ":")+1:])
+				}
+			case path[len(path)-1] == "Address" && request.action == "Resolve":
+				request.address = text
+			}
+		}
+	}
+}
+
+// matches Reports whether endpoint has every type probed for, only local names are compared
+func (endpoint wsdEndpoint) matches(types []string) bool {
+	for _, probed := range types {
+		found := false
+		for _, qname := range strings.Fields(endpoint.types) {
+			found = found || qname[strings.Index(qname, ":")+1:] == probed
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// serve Answers the Probe and Resolve messages arriving on conn until it is closed
+func (a *WSDAnnouncer) serve(conn *multicastConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, ifIndex, src, err := conn.read(buf)
+		if err != nil {
+			if !a.isOpen(conn) {
+				return
+			}
+			log.Debugf("Failed to read WS-Discovery packet: %+v", err)
+			continue
+		}
+		request, err := parseWSDRequest(buf[:n])
+		if err != nil || (request.action != "Probe" && request.action != "Resolve") {
+			continue
+		}
+		a.mutex.Lock()
+		responses := a.responses(ifIndex, request)
+		a.mutex.Unlock()
+		for _, response := range responses {
+			if _, err := conn.conn.WriteTo(response, src); err != nil {
+				log.Debugf("Failed to answer WS-Discovery %v of %v: %+v", request.action, src, err)
+			}
+		}
+	}
+}
+
+func (a *WSDAnnouncer) isOpen(conn *multicastConn) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, open := range a.conns {
+		if open == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// responses Returns a ProbeMatches or ResolveMatches message for each matching endpoint, none
+// for messages arriving on interfaces other than ours. The caller holds the mutex
+func (a *WSDAnnouncer) responses(ifIndex int, request wsdRequest) [][]byte {
+	ours := false
+	for _, iface := range a.ifaces {
+		ours = ours || iface.Index == ifIndex
+	}
+	responses := [][]byte{}
+	if !ours {
+		return responses
+	}
+	for _, endpoint := range a.endpoints() {
+		switch {
+		case request.action == "Probe" && endpoint.matches(request.types):
+			responses = append(responses, a.message(wsdActionProbeMatches, wsdAnonymous, request.messageID,
+				"<wsd:ProbeMatches><wsd:ProbeMatch>"+endpoint.body()+"</wsd:ProbeMatch></wsd:ProbeMatches>"))
+		case request.action == "Resolve" && endpoint.address == request.address:
+			responses = append(responses, a.message(wsdActionResolveMatches, wsdAnonymous, request.messageID,
+				"<wsd:ResolveMatches><wsd:ResolveMatch>"+endpoint.body()+"</wsd:ResolveMatch></wsd:ResolveMatches>"))
+		}
+	}
+	return responses
+}
+
+// body Returns the endpoint reference, types, transport address and metadata version of endpoint
+func (endpoint wsdEndpoint) body() string {
+	return fmt.Sprintf("<wsa:EndpointReference><wsa:Address>%v</wsa:Address></wsa:EndpointReference><wsd:Types>%v</wsd:Types><wsd:XAddrs>%v</wsd:XAddrs><wsd:MetadataVersion>1</wsd:MetadataVersion>",
+		xmlEscape(endpoint.address), xmlEscape(endpoint.types), xmlEscape(endpoint.xaddr))
+}
+
+// message Returns a SOAP envelope with the given action, relatesTo may be empty. The caller holds the mutex
+func (a *WSDAnnouncer) message(action string, to string, relatesTo string, body string) []byte {
+	a.messageNumber++
+	var envelope bytes.Buffer
+	envelope.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	envelope.WriteString(`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:wsd="http://schemas.xmlsoap.org/ws/2005/04/discovery"`)
+	prefixes := []string{}
+	for prefix := range wsdNamespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&envelope, ` xmlns:%v="%v"`, prefix, wsdNamespaces[prefix])
+	}
+	envelope.WriteString(`><soap:Header>`)
+	fmt.Fprintf(&envelope, `<wsa:To>%v</wsa:To><wsa:Action>%v</wsa:Action><wsa:MessageID>urn:uuid:%v</wsa:MessageID>`, to, action, randomUUID())
+	if relatesTo != "" {
+		fmt.Fprintf(&envelope, `<wsa:RelatesTo>%v</wsa:RelatesTo>`, xmlEscape(relatesTo))
+	}
+	fmt.Fprintf(&envelope, `<wsd:AppSequence InstanceId="%v" MessageNumber="%v"/>`, a.instanceID, a.messageNumber)
+	envelope.WriteString(`</soap:Header><soap:Body>`)
+	envelope.WriteString(body)
+	envelope.WriteString(`</soap:Body></soap:Envelope>`)
+	return envelope.Bytes()
+}
+
+func xmlEscape(text string) string {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+func randomUUID() string {
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+	random[6] = random[6]&0x0f | 0x40
+	random[8] = random[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", random[0:4], random[4:6], random[6:8], random[8:10], random[10:16])
+}
+
+// multicast Sends a Hello or Bye for endpoint on every interface, the caller holds the mutex
+func (a *WSDAnnouncer) multicast(endpoint wsdEndpoint, action string) {
+	body := "<wsd:Hello>" + endpoint.body() + "</wsd:Hello>"
+	if action == wsdActionBye {
+		body = fmt.Sprintf("<wsd:Bye><wsa:EndpointReference><wsa:Address>%v</wsa:Address></wsa:EndpointReference></wsd:Bye>", xmlEscape(endpoint.address))
+	}
+	message := a.message(action, wsdTo, "", body)
+	for _, conn := range a.conns {
+		for i := range a.ifaces {
+			if err := conn.send(message, &a.ifaces[i]); err != nil {
+				log.Debugf("Failed to send WS-Discovery message on %v: %+v", a.ifaces[i].Name, err)
+			}
+		}
+	}
+}
+
+// Publish Sends a Hello for instance if it has WS-Discovery types, other instances are ignored
+func (a *WSDAnnouncer) Publish(instance ServiceInstance) error {
+	if instance.WSDiscoveryTypes == "" {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.instances[instance.key()] = instance
+	a.multicast(wsdEndpointOf(instance), wsdActionHello)
+	return nil
+}
+
+// Unpublish Sends a Bye for the endpoint of instance unless another instance still advertises it
+func (a *WSDAnnouncer) Unpublish(instance ServiceInstance) {
+	if instance.WSDiscoveryTypes == "" {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.instances, instance.key())
+	endpoint := wsdEndpointOf(instance)
+	for _, remaining := range a.endpoints() {
+		if remaining.address == endpoint.address {
+			return
+		}
+	}
+	a.multicast(endpoint, wsdActionBye)
+}
+
+func (a *WSDAnnouncer) SetInterfaces(ifaces []net.Interface) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.closeConns()
+	if err := a.listen(ifaces); err != nil {
+		return err
+	}
+	for _, endpoint := range a.endpoints() {
+		a.multicast(endpoint, wsdActionHello)
+	}
+	return nil
+}
+
+// Close Sends a Bye for every endpoint and stops answering
+func (a *WSDAnnouncer) Close() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, endpoint := range a.endpoints() {
+		a.multicast(endpoint, wsdActionBye)
+	}
+	a.closeConns()
+}
+
+// closeConns Closes the sockets, which stops their serve loops. The caller holds the mutex
+func (a *WSDAnnouncer) closeConns() {
+	conns := a.conns
+	a.conns = nil
+	for _, conn := range conns {
+		conn.conn.Close()
+	}
+}
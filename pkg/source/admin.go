@@ -0,0 +1,66 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+)
+
+// RegistrationsPath The path the admin endpoint serves the registrations on
+const RegistrationsPath = "/registrations"
+
+// adminShutdownTimeout How long requests in flight may take when the manager stops
+const adminShutdownTimeout = time.Second * 5
+
+// adminServer Serves the registrations of the registry as JSON, on standbys too
+type adminServer struct {
+	server *http.Server
+}
+
+// AddAdminEndpoint Serves the registrations of registry as JSON on RegistrationsPath at
+// address while the manager runs
+func AddAdminEndpoint(sources *Manager, registry *publisher.Registry, address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(RegistrationsPath, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(registry.Registrations()); err != nil {
+			log.Debugf("Failed to write registrations: %+v", err)
+		}
+	})
+	return sources.manager.Add(&adminServer{server: &http.Server{Addr: address, Handler: mux}})
+}
+
+// Start Serves until ctx is done, the Manager calls it
+func (s *adminServer) Start(ctx context.Context) error {
+	errs := make(chan error, 1)
+	go func() {
+		log.Infof("Serving registrations on %v%v", s.server.Addr, RegistrationsPath)
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+		close(errs)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(shutdown)
+}
+
+// NeedLeaderElection Serves on standbys as well, which list what they would publish
+func (s *adminServer) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,58 @@
+package source
+
+import (
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annotationAliases Comma separated hostnames published with the addresses, port and records
+// of the first hostname of the object, e.g. grafana.local,dash.local
+const annotationAliases = hostname.AnnotationPrefix + "aliases"
+
+// withAliases Returns hostnames along with copies of the first of them for each alias in the
+// aliases annotation of obj of kind. mDNS has no CNAME records a client follows, so an alias
+// is published as a hostname of its own with the same addresses and DNS-SD instance
+func (o HostnameOptions) withAliases(kind string, obj metav1.Object, hostnames []hostname.LocalHostname) []hostname.LocalHostname {
+	annotated, exists := obj.GetAnnotations()[annotationAliases]
+	if !exists || len(hostnames) == 0 {
+		return hostnames
+	}
+	primary := hostnames[0].Hostname
+	taken := map[string]bool{}
+	for _, local := range hostnames {
+		taken[strings.ToLower(local.Hostname)] = true
+	}
+	aliased := append([]hostname.LocalHostname{}, hostnames...)
+	for _, alias := range strings.Split(annotated, ",") {
+		if alias = strings.TrimSpace(alias); alias == "" {
+			continue
+		}
+		name, ok := o.TrimDomain(alias)
+		if !ok {
+			log.Warnf("Ignoring alias %v of %v %v/%v, it is not in the %v domain or a mapped domain", alias, kind, obj.GetNamespace(), obj.GetName(), o.Domain)
+			continue
+		}
+		if taken[strings.ToLower(name)] {
+			continue
+		}
+		taken[strings.ToLower(name)] = true
+		for _, local := range hostnames {
+			if local.Hostname != primary {
+				continue
+			}
+			local.Hostname = name
+			if suffix := " (" + primary + ")"; strings.HasSuffix(local.Instance, suffix) {
+				// Instances per path are named "path (hostname)"
+				local.Instance = strings.TrimSuffix(local.Instance, suffix) + " (" + name + ")"
+			} else {
+				// Instance names the template gave the object would clash with the primary
+				local.Instance = ""
+			}
+			aliased = append(aliased, local)
+		}
+	}
+	return aliased
+}
@@ -0,0 +1,55 @@
+package source
+
+import (
+	"errors"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// watchErrors Counts the failed lists and watches of the API server per resource, served on
+// /metrics of --metrics-listen
+var watchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "zeroconf_apiserver_watch_errors_total",
+	Help: "Failed lists and watches of the API server, which are retried with a backoff",
+}, []string{"resource"})
+
+func init() {
+	metrics.Registry.MustRegister(watchErrors)
+}
+
+// watchErrorSetter The informers whose failed lists and watches can be handled, which must be
+// set before they start
+type watchErrorSetter interface {
+	SetWatchErrorHandler(handler cache.WatchErrorHandler) error
+}
+
+// handleWatchErrors Has the failed lists and watches of informer logged and counted as resource.
+// The reflector of the informer retries them with a backoff of up to 30 seconds, meanwhile the
+// records of the objects it listed before stay published
+func handleWatchErrors(resource string, informer interface{}) {
+	setter, ok := informer.(watchErrorSetter)
+	if !ok {
+		log.Warnf("Failed lists and watches of %v are not logged or counted, its informer %T takes no watch error handler", resource, informer)
+		return
+	}
+	if err := setter.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		switch {
+		case apierrors.IsResourceExpired(err) || apierrors.IsGone(err) || err == io.EOF:
+			// The watch ended or fell behind and is restarted, which is routine
+			log.Debugf("Restarting the watch of %v: %+v", resource, err)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			watchErrors.WithLabelValues(resource).Inc()
+			log.Infof("Watch of %v closed unexpectedly, restarting it: %+v", resource, err)
+		default:
+			watchErrors.WithLabelValues(resource).Inc()
+			log.WithField("resource", resource).Warnf("Failed to watch %v, retrying with a backoff and keeping the published records: %+v", resource, err)
+		}
+	}); err != nil {
+		log.Debugf("Not handling the watch errors of %v: %+v", resource, err)
+	}
+}
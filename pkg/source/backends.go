@@ -0,0 +1,159 @@
+package source
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// BackendSource Tracks the Endpoints of the services ingress rules route to, so that hostnames
+// whose backends have no ready address are withdrawn until one becomes ready. Changed Endpoints
+// requeue the ingresses routing to their service
+type BackendSource struct {
+	client    client.Client
+	hostnames HostnameOptions
+
+	mutex sync.Mutex
+	// routing The ingresses routing to each service, keyed by the namespace/name of both
+	routing map[types.NamespacedName]map[types.NamespacedName]bool
+}
+
+// NewBackendSource Watches the Endpoints of the watched namespaces, requeueing the objects
+// of the hostnames watched as kind that route to them
+func NewBackendSource(sources *Manager, kind string) (*BackendSource, error) {
+	backends := &BackendSource{
+		client:    sources.manager.GetClient(),
+		hostnames: sources.hostnames,
+		routing:   map[types.NamespacedName]map[types.NamespacedName]bool{},
+	}
+	if err := sources.requeueOn(kind, "endpoints", &v1.Endpoints{}, backends.routingIngresses); err != nil {
+		return nil, err
+	}
+	return backends, nil
+}
+
+// routingIngresses Returns the ingresses routing to the service of the changed Endpoints
+func (s *BackendSource) routingIngresses(endpoints client.Object) []reconcile.Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	requests := []reconcile.Request{}
+	for ingress := range s.routing[types.NamespacedName{Namespace: endpoints.GetNamespace(), Name: endpoints.GetName()}] {
+		requests = append(requests, reconcile.Request{NamespacedName: ingress})
+	}
+	return requests
+}
+
+// Filter Returns the hostnames of ingress that have a backend with a ready address. Hostnames
+// routed to resources other than services and services without Endpoints, such as those of
+// type ExternalName, are kept
+func (s *BackendSource) Filter(ingress *networkingv1.Ingress, hostnames []hostname.LocalHostname) []hostname.LocalHostname {
+	services := ingressServices(s.hostnames, ingress)
+	s.route(ingress, services)
+	served := []hostname.LocalHostname{}
+	ready := map[types.NamespacedName]bool{}
+	for _, local := range hostnames {
+		backends, routed := services[local.Hostname]
+		if !routed || s.anyReady(backends, ready) {
+			served = append(served, local)
+			continue
+		}
+		log.WithFields(ingressFields(ingress)).Debugf("Leaving out %v of ingress %v/%v, its backends have no ready endpoints", local.Hostname, ingress.Namespace, ingress.Name)
+	}
+	return served
+}
+
+// anyReady Reports whether one of the services has a ready address or no Endpoints,
+// caching the answers in ready
+func (s *BackendSource) anyReady(services []types.NamespacedName, ready map[types.NamespacedName]bool) bool {
+	for _, service := range services {
+		isReady, known := ready[service]
+		if !known {
+			isReady = s.isReady(service)
+			ready[service] = isReady
+		}
+		if isReady {
+			return true
+		}
+	}
+	return false
+}
+
+// isReady Reports whether the Endpoints of service have a ready address, true when there are none
+func (s *BackendSource) isReady(service types.NamespacedName) bool {
+	endpoints := &v1.Endpoints{}
+	if err := s.client.Get(context.TODO(), service, endpoints); apierrors.IsNotFound(err) {
+		return true
+	} else if err != nil {
+		log.Warnf("Failed to look up endpoints %v, assuming they are ready: %+v", service, err)
+		return true
+	}
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// route Records the services ingress routes to, replacing those recorded before
+func (s *BackendSource) route(ingress *networkingv1.Ingress, services map[string][]types.NamespacedName) {
+	key := types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for service, ingresses := range s.routing {
+		delete(ingresses, key)
+		if len(ingresses) == 0 {
+			delete(s.routing, service)
+		}
+	}
+	for _, backends := range services {
+		for _, service := range backends {
+			if s.routing[service] == nil {
+				s.routing[service] = map[types.NamespacedName]bool{}
+			}
+			s.routing[service][key] = true
+		}
+	}
+}
+
+// ingressServices Returns the services the rules of ingress route to, keyed by the hostnames
+// GetIngressHostnames returns for them. Rules without paths route to the default backend
+func ingressServices(options HostnameOptions, ingress *networkingv1.Ingress) map[string][]types.NamespacedName {
+	services := map[string][]types.NamespacedName{}
+	for _, rule := range ingress.Spec.Rules {
+		backends := []networkingv1.IngressBackend{}
+		if rule.HTTP != nil {
+			for _, path := range rule.HTTP.Paths {
+				backends = append(backends, path.Backend)
+			}
+		} else if ingress.Spec.DefaultBackend != nil {
+			backends = append(backends, *ingress.Spec.DefaultBackend)
+		}
+		routed := []types.NamespacedName{}
+		for _, backend := range backends {
+			if backend.Service == nil {
+				// Routed to another resource, whose readiness is unknown
+				routed = nil
+				break
+			}
+			routed = append(routed, types.NamespacedName{Namespace: ingress.Namespace, Name: backend.Service.Name})
+		}
+		if len(routed) == 0 {
+			continue
+		}
+		for _, host := range expandWildcardHost(ingress, rule.Host) {
+			if name, ok := options.TrimDomain(host); ok {
+				services[name] = append(services[name], routed...)
+			}
+		}
+	}
+	return services
+}
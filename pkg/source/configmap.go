@@ -0,0 +1,209 @@
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/yaml"
+)
+
+// staticEntry A hostname listed in the static entries ConfigMap
+type staticEntry struct {
+	local hostname.LocalHostname
+	ip    net.IP
+}
+
+func (e staticEntry) equal(other staticEntry) bool {
+	return e.ip.Equal(other.ip) && reflect.DeepEqual(e.local, other.local)
+}
+
+// WatchStaticEntries Watches the ConfigMap namespace/name, whose data maps
+// .local hostnames to "ip" or "ip:port", and keeps its entries registered
+func WatchStaticEntries(sources *Manager, configMap string, registry *publisher.Registry) error {
+	parts := strings.SplitN(configMap, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("Static entries configmap %v is not of the form namespace/name", configMap)
+	}
+	informer := sources.selectedFactory(parts[0], "", "metadata.name="+parts[1]).Core().V1().ConfigMaps().Informer()
+
+	entries := newStaticEntries(registry, sources.owner("configmap", configMap))
+	syncEntries := func(obj interface{}, newEntries map[string]staticEntry) {
+		if err := entries.sync(objectReference(obj), newEntries); err != nil {
+			log.WithFields(publisher.OwnerFields(entries.owner)).Errorf("Failed to register static entries of configmap %v, retrying: %+v", configMap, err)
+		}
+	}
+	sources.track("configmaps", informer, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			log.Debugf("Got new static entries configmap")
+			syncEntries(obj, parseStaticEntries(sources.hostnames, obj.(*v1.ConfigMap).Data))
+		},
+		DeleteFunc: func(obj interface{}) {
+			log.Debugf("Got removed static entries configmap")
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			syncEntries(obj, nil)
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			syncEntries(newObj, parseStaticEntries(sources.hostnames, newObj.(*v1.ConfigMap).Data))
+		},
+	})
+	return nil
+}
+
+// staticEntries Keeps the entries of one owner registered. Entries whose registration failed
+// are registered again with an exponential backoff until it succeeds or they are replaced
+type staticEntries struct {
+	mutex    sync.Mutex
+	registry *publisher.Registry
+	owner    string
+	ref      *v1.ObjectReference
+	entries  map[string]staticEntry
+	limiter  workqueue.RateLimiter
+	// retrying Whether a retry is scheduled
+	retrying bool
+}
+
+func newStaticEntries(registry *publisher.Registry, owner string) *staticEntries {
+	return &staticEntries{registry: registry, owner: owner, entries: map[string]staticEntry{}, limiter: newRetryRateLimiter()}
+}
+
+// sync Registers the entries that are new or changed and unregisters those that were removed
+// or changed. Failed registrations are retried in the background, their error is returned
+func (s *staticEntries) sync(ref *v1.ObjectReference, entries map[string]staticEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, old := range s.entries {
+		if entry, exists := entries[key]; !exists || !entry.equal(old) {
+			s.registry.Unregister(s.owner, []hostname.LocalHostname{old.local})
+		}
+	}
+	changed := map[string]staticEntry{}
+	for key, entry := range entries {
+		if old, exists := s.entries[key]; !exists || !entry.equal(old) {
+			changed[key] = entry
+		}
+	}
+	s.ref, s.entries = ref, entries
+	err := s.register(changed)
+	if err != nil {
+		s.retryLater()
+	}
+	return err
+}
+
+// register Registers entries. Register reports every unpublished hostname of the owner, so
+// the error of the last entry covers all of them
+func (s *staticEntries) register(entries map[string]staticEntry) error {
+	var err error
+	for _, entry := range entries {
+		err = s.registry.Register(s.owner, s.ref, []hostname.LocalHostname{entry.local}, []net.IP{entry.ip})
+	}
+	return err
+}
+
+// retryLater Schedules registering all entries again, unless a retry is scheduled already
+func (s *staticEntries) retryLater() {
+	if s.retrying {
+		return
+	}
+	s.retrying = true
+	time.AfterFunc(s.limiter.When(s.owner), s.retry)
+}
+
+// retry Registers all entries again, which is a no-op for those registered already
+func (s *staticEntries) retry() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.retrying = false
+	if err := s.register(s.entries); err != nil {
+		log.WithFields(publisher.OwnerFields(s.owner)).Errorf("Failed to register static entries of %v, retrying: %+v", s.owner, err)
+		s.retryLater()
+		return
+	}
+	s.limiter.Forget(s.owner)
+}
+
+// StaticEntriesFile Keeps the entries of a YAML file registered, which maps hostnames to "ip"
+// or "ip:port" like the static entries ConfigMap. The file is read by Load
+type StaticEntriesFile struct {
+	path      string
+	hostnames HostnameOptions
+	entries   *staticEntries
+}
+
+// NewStaticEntriesFile Returns the static entries of the file at path, whose hostnames are mapped
+// into the broadcast domain with options. Nothing is registered before Load
+func NewStaticEntriesFile(path string, options HostnameOptions, registry *publisher.Registry) *StaticEntriesFile {
+	return &StaticEntriesFile{path: path, hostnames: options.withDefaults(), entries: newStaticEntries(registry, "file "+path)}
+}
+
+// Load Reads the file and re-registers only the entries that changed since the last Load,
+// the registered entries are kept when the file cannot be read. Entries that fail to register
+// are logged and retried in the background
+func (f *StaticEntriesFile) Load() error {
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	data := map[string]string{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return fmt.Errorf("parsing %v: %+v", f.path, err)
+	}
+	if err := f.entries.sync(nil, parseStaticEntries(f.hostnames, data)); err != nil {
+		log.WithFields(publisher.OwnerFields(f.entries.owner)).Errorf("Failed to register static entries of %v, retrying: %+v", f.path, err)
+	}
+	return nil
+}
+
+// parseStaticEntries Returns the entries of the hostnames and targets in data, keyed by hostname
+func parseStaticEntries(options HostnameOptions, data map[string]string) map[string]staticEntry {
+	entries := map[string]staticEntry{}
+	for host, target := range data {
+		name, ok := options.TrimDomain(host)
+		if !ok {
+			log.Warnf("Ignoring static entry %v, it is not in the %v domain", host, options.Domain)
+			continue
+		}
+		ip, port, err := parseStaticTarget(strings.TrimSpace(target))
+		if err != nil {
+			log.Warnf("Ignoring static entry %v: %+v", host, err)
+			continue
+		}
+		local := hostname.LocalHostname{Hostname: name, Port: port}
+		entries[local.Key()] = staticEntry{local, ip}
+	}
+	return entries
+}
+
+// parseStaticTarget Parses "ip", "ip:port" or "[ipv6]:port", port is 0 when omitted
+func parseStaticTarget(target string) (net.IP, int, error) {
+	if ip := net.ParseIP(target); ip != nil {
+		return ip, 0, nil
+	}
+	host, rawPort, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v is neither an IP nor ip:port", target)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("%v is not a valid IP", host)
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, 0, fmt.Errorf("%v is not a valid port", rawPort)
+	}
+	return ip, port, nil
+}
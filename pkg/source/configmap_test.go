@@ -0,0 +1,38 @@
+package source
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+)
+
+func TestStaticEntriesRetried(t *testing.T) {
+	backend := &testPublisher{err: errors.New("publisher is down"), published: map[string]bool{}}
+	registry := publisher.NewRegistry(nil, backend)
+	entries := newStaticEntries(registry, "configmap kube-system/zeroconf-static")
+	parsed := parseStaticEntries(HostnameOptions{}.withDefaults(), map[string]string{"nas.local": "192.168.1.10", "printer.local": "192.168.1.11"})
+
+	if err := entries.sync(nil, parsed); err == nil {
+		t.Fatalf("sync() succeeded while publishing fails")
+	}
+	if !entries.retrying {
+		t.Errorf("sync() scheduled no retry after failing")
+	}
+
+	backend.err = nil
+	entries.retry()
+	if !backend.published["nas"] || !backend.published["printer"] {
+		t.Errorf("published %v after the retry, want nas and printer", backend.published)
+	}
+	if entries.retrying {
+		t.Errorf("retry() scheduled another retry after succeeding")
+	}
+
+	if err := entries.sync(nil, nil); err != nil {
+		t.Fatalf("sync() without entries = %+v", err)
+	}
+	if len(backend.published) != 0 {
+		t.Errorf("published %v after removing the entries, want none", backend.published)
+	}
+}
@@ -0,0 +1,310 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultDockerSocket The socket of the Docker engine, Podman serves a compatible API at
+	// /run/podman/podman.sock
+	DefaultDockerSocket = "/var/run/docker.sock"
+	// dockerResyncInterval How often all containers are listed again, which retries failed registrations
+	dockerResyncInterval = time.Second * 30
+	// dockerReconnectDelay How long to wait before following the events of the engine again
+	dockerReconnectDelay = time.Second * 5
+	// dockerRequestTimeout Bounds the requests listing containers, not the stream of events
+	dockerRequestTimeout = time.Second * 10
+)
+
+var (
+	// traefikRouterRule The rule label of a Traefik router, whose Host matchers are broadcast too
+	traefikRouterRule = regexp.MustCompile(`^traefik\.http\.routers\.([^.]+)\.rule$`)
+	// traefikHost A Host or Host with several hosts matcher of a Traefik rule
+	traefikHost = regexp.MustCompile("Host\\(([^)]*)\\)")
+	// traefikQuoted A backtick or double quoted host of a matcher
+	traefikQuoted = regexp.MustCompile("[`\"]([^`\"]+)[`\"]")
+)
+
+// DockerSocket Returns the socket of $DOCKER_HOST when it is a unix:// URL, DefaultDockerSocket otherwise
+func DockerSocket() string {
+	if dockerHost := os.Getenv("DOCKER_HOST"); strings.HasPrefix(dockerHost, "unix://") {
+		return strings.TrimPrefix(dockerHost, "unix://")
+	}
+	return DefaultDockerSocket
+}
+
+// dockerContainer The fields of a container listed by the engine
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []struct {
+		PublicPort int    `json:"PublicPort"`
+		Type       string `json:"Type"`
+	} `json:"Ports"`
+}
+
+// name Returns the name of the container without the leading slash, its ID without a name
+func (c dockerContainer) name() string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return c.ID
+}
+
+// DockerSource Keeps the hostnames of the running containers of a Docker or Podman engine
+// registered, for hosts that do not run Kubernetes. It follows the events of the engine and
+// lists the containers again on every event and every dockerResyncInterval
+type DockerSource struct {
+	socket    string
+	client    *http.Client
+	hostnames HostnameOptions
+	registry  *publisher.Registry
+	// hostIPs Returns the addresses of the host, which containers publish their ports on
+	hostIPs func() []net.IP
+	// registered The hostnames registered for each container, keyed by owner
+	registered map[string][]hostname.LocalHostname
+}
+
+// NewDockerSource Talks to the engine at socket, advertising the containers with hostIPs unless
+// they are labelled with target addresses. Their hostnames are mapped into the broadcast domain
+// with the domains and rewrites of options
+func NewDockerSource(socket string, options HostnameOptions, registry *publisher.Registry, hostIPs func() []net.IP) *DockerSource {
+	dialer := &net.Dialer{Timeout: dockerRequestTimeout}
+	return &DockerSource{
+		socket: socket,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+		hostnames:  options.withDefaults(),
+		registry:   registry,
+		hostIPs:    hostIPs,
+		registered: map[string][]hostname.LocalHostname{},
+	}
+}
+
+// Run Registers the hostnames of the containers until ctx is done. ready is closed once the
+// containers were listed the first time
+func (s *DockerSource) Run(ctx context.Context, ready chan<- struct{}) {
+	resync := time.NewTicker(dockerResyncInterval)
+	defer resync.Stop()
+	events := make(chan struct{}, 1)
+	go s.followEvents(ctx, events)
+	listed := false
+	for {
+		if err := s.sync(ctx); err != nil {
+			log.Errorf("Failed to list the containers of %v: %+v", s.socket, err)
+		} else if !listed {
+			listed = true
+			log.Infof("Registered the hostnames of the containers of %v", s.socket)
+			close(ready)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-resync.C:
+		case <-events:
+		}
+	}
+}
+
+// followEvents Signals events for every container event of the engine until ctx is done,
+// reconnecting after dockerReconnectDelay when the stream breaks
+func (s *DockerSource) followEvents(ctx context.Context, events chan<- struct{}) {
+	filters := url.QueryEscape(`{"type":["container"],"event":["start","die","destroy","rename","update"]}`)
+	for {
+		err := s.stream(ctx, "/events?filters="+filters, func(decoder *json.Decoder) error {
+			var event struct {
+				Action string `json:"Action"`
+				Actor  struct {
+					ID string `json:"ID"`
+				} `json:"Actor"`
+			}
+			if err := decoder.Decode(&event); err != nil {
+				return err
+			}
+			log.Debugf("Got container event %v of %v", event.Action, event.Actor.ID)
+			select {
+			case events <- struct{}{}:
+			default:
+				// A sync is pending already
+			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warnf("Following the events of %v failed, reconnecting in %v: %+v", s.socket, dockerReconnectDelay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dockerReconnectDelay):
+		}
+	}
+}
+
+// stream GETs path and hands the decoder of the body to decode until it fails
+func (s *DockerSource) stream(ctx context.Context, path string, decode func(*json.Decoder) error) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v: %v", path, response.Status)
+	}
+	decoder := json.NewDecoder(response.Body)
+	for {
+		if err := decode(decoder); err != nil {
+			return err
+		}
+	}
+}
+
+// list Returns the running containers
+func (s *DockerSource) list(ctx context.Context) ([]dockerContainer, error) {
+	ctx, cancel := context.WithTimeout(ctx, dockerRequestTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker/containers/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET /containers/json: %v", response.Status)
+	}
+	containers := []dockerContainer{}
+	if err := json.NewDecoder(response.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("parsing containers: %+v", err)
+	}
+	return containers, nil
+}
+
+// sync Registers the hostnames of the running containers and unregisters those of the
+// containers that stopped or dropped them. Registering unchanged hostnames again is a no-op
+func (s *DockerSource) sync(ctx context.Context) error {
+	containers, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+	current := map[string][]hostname.LocalHostname{}
+	for _, c := range containers {
+		owner := "container " + c.name()
+		hostnames, ips := s.getContainerHostnames(c)
+		if len(hostnames) == 0 {
+			continue
+		}
+		current[owner] = hostnames
+		if err := s.registry.Register(owner, nil, hostnames, ips); err != nil {
+			log.WithFields(publisher.OwnerFields(owner)).Errorf("Failed to register container %v, retrying: %+v", c.name(), err)
+		}
+	}
+	for owner, previous := range s.registered {
+		if removed := removedHostnames(previous, current[owner]); len(removed) > 0 {
+			log.Infof("%v stopped or changed, unregistering hostnames", owner)
+			s.registry.Unregister(owner, removed)
+		}
+	}
+	s.registered = current
+	return nil
+}
+
+// getContainerHostnames Returns the hostnames of the labels of c and the addresses to advertise
+// them with, those of the host unless it is labelled with target addresses
+func (s *DockerSource) getContainerHostnames(c dockerContainer) ([]hostname.LocalHostname, []net.IP) {
+	if c.Labels[annotationEnabled] == "false" {
+		return nil, nil
+	}
+	template := hostname.LocalHostname{}
+	for _, port := range c.Ports {
+		if port.PublicPort != 0 && port.Type == "tcp" {
+			template.Port = port.PublicPort
+			break
+		}
+	}
+	if labelled, exists := c.Labels[annotationPort]; exists {
+		if port, err := strconv.Atoi(labelled); err == nil && port > 0 && port <= 65535 {
+			template.Port = port
+		} else {
+			log.Warnf("Container %v has an invalid %v label %v, using its published port", c.name(), annotationPort, labelled)
+		}
+	}
+	if labelled, exists := c.Labels[annotationServiceType]; exists {
+		if serviceType := strings.TrimSuffix(labelled, "."); hostname.ServiceTypePattern.MatchString(serviceType) {
+			template.ServiceType = serviceType
+		} else {
+			log.Warnf("Container %v has an invalid %v label %v, using the default service type", c.name(), annotationServiceType, labelled)
+		}
+	}
+
+	hosts := map[string]bool{}
+	for _, host := range strings.Split(c.Labels[annotationHostname], ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts[host] = false
+		}
+	}
+	for label, rule := range c.Labels {
+		match := traefikRouterRule.FindStringSubmatch(label)
+		if match == nil {
+			continue
+		}
+		tls := c.Labels["traefik.http.routers."+match[1]+".tls"] == "true"
+		for _, matcher := range traefikHost.FindAllStringSubmatch(rule, -1) {
+			for _, quoted := range traefikQuoted.FindAllStringSubmatch(matcher[1], -1) {
+				hosts[quoted[1]] = hosts[quoted[1]] || tls
+			}
+		}
+	}
+	names := []string{}
+	for host := range hosts {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+	hostnames := []hostname.LocalHostname{}
+	for _, host := range names {
+		name, ok := s.hostnames.TrimDomain(host)
+		if !ok {
+			log.Debugf("Skipping host %v of container %v, it is not in the %v domain or a mapped domain", host, c.name(), s.hostnames.Domain)
+			continue
+		}
+		local := template
+		local.Hostname = name
+		local.TLS = hosts[host]
+		hostnames = append(hostnames, local)
+	}
+
+	if labelled, exists := c.Labels[annotationTargetIP]; exists {
+		if ips, ok := parseIPList(labelled); ok {
+			return hostnames, s.hostnames.SelectAddresses(hostname.SortIPs(ips))
+		}
+		log.Warnf("Container %v has an invalid %v label %v, using the addresses of the host", c.name(), annotationTargetIP, labelled)
+	}
+	return hostnames, s.hostnames.SelectAddresses(s.hostIPs())
+}
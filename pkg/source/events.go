@@ -0,0 +1,24 @@
+package source
+
+import (
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/reference"
+)
+
+// objectReference Returns a reference to a watched object for recording Events, nil when
+// the kind of obj cannot be determined
+func objectReference(obj interface{}) *v1.ObjectReference {
+	object, ok := obj.(runtime.Object)
+	if !ok {
+		return nil
+	}
+	ref, err := reference.GetReference(scheme.Scheme, object)
+	if err != nil {
+		log.Debugf("No object reference for %T: %+v", obj, err)
+		return nil
+	}
+	return ref
+}
@@ -0,0 +1,53 @@
+package source_test
+
+import (
+	"context"
+	"net"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/source"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Example Broadcasts the hostnames of the annotated services of the cluster of the
+// kubeconfig over mDNS, those in example.com under local too, as the README shows for
+// embedding the packages
+func Example() {
+	ctx := context.Background()
+	config, err := clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	iface, err := net.InterfaceByName("eth0")
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	ifaces := []net.Interface{*iface}
+
+	responder, err := publisher.NewMDNSResponder(ifaces, publisher.MDNSOptions{})
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	defer responder.Close()
+	registry := publisher.NewRegistry(ifaces, responder)
+	registry.Prober = responder.Prober()
+	hostnames := source.HostnameOptions{Options: hostname.DefaultOptions()}
+	hostnames.MappedDomains = []string{"example.com"}
+	sources, err := source.NewManager(config, clientset, source.ManagerOptions{Hostnames: hostnames})
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if err := source.WatchServiceHostnames(sources, registry); err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if err := sources.Run(ctx); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
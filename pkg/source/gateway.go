@@ -0,0 +1,227 @@
+package source
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const gatewayAPIGroup = "gateway.networking.k8s.io"
+
+// GatewayAPIPreference The Gateway API versions probed at startup, newest first
+var GatewayAPIPreference = []string{gatewayAPIGroup + "/v1", gatewayAPIGroup + "/v1beta1"}
+
+// GatewaySource Registers the .local hostnames of HTTPRoutes, advertising
+// the address of the Gateway they are attached to. It reconciles HTTPRoutes,
+// changed Gateways requeue the routes attached to them
+type GatewaySource struct {
+	client client.Client
+	gv     schema.GroupVersion
+	// hostnames Maps the hostnames of the routes into the broadcast domain
+	hostnames HostnameOptions
+}
+
+// NewGatewaySource Watches the Gateways and HTTPRoutes of the Gateway API version apiVersion
+func NewGatewaySource(sources *Manager, apiVersion string, registry *publisher.Registry) (*GatewaySource, error) {
+	gv, _ := schema.ParseGroupVersion(apiVersion)
+	gateways := &GatewaySource{
+		client:    sources.manager.GetClient(),
+		gv:        gv,
+		hostnames: sources.hostnames,
+	}
+	for _, kind := range []string{"Gateway", "HTTPRoute"} {
+		if err := sources.watchCached(strings.ToLower(kind)+"s", gateways.newObject(kind)); err != nil {
+			return nil, err
+		}
+	}
+	reconciler := newRegistrationsReconciler("httproute", sources.cluster, registry, gateways.client, gateways.newObject("HTTPRoute"), sources.ready, gateways.getRegistrations)
+	ctrl, err := sources.reconcileCached("httproute", gateways.newObject("HTTPRoute"), reconciler)
+	if err != nil {
+		return nil, err
+	}
+	return gateways, ctrl.Watch(&source.Kind{Type: gateways.newObject("Gateway")}, handler.EnqueueRequestsFromMapFunc(gateways.gatewayRoutes))
+}
+
+// newObject Returns an empty Gateway API object of kind
+func (s *GatewaySource) newObject(kind string) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{}
+	object.SetGroupVersionKind(s.gv.WithKind(kind))
+	return object
+}
+
+// get Looks up the Gateway API object of kind namespace/name, nil when it does not exist
+func (s *GatewaySource) get(ctx context.Context, kind string, key string) (*unstructured.Unstructured, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, err
+	}
+	object := s.newObject(kind)
+	if err := s.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, object); apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// gatewayRoutes Returns every HTTPRoute attached to the changed Gateway
+func (s *GatewaySource) gatewayRoutes(gateway client.Object) []reconcile.Request {
+	key, _ := cache.MetaNamespaceKeyFunc(gateway)
+	log.Debugf("Got changed gateway %v", key)
+	routes := &unstructured.UnstructuredList{}
+	routes.SetGroupVersionKind(s.gv.WithKind("HTTPRouteList"))
+	if err := s.client.List(context.TODO(), routes); err != nil {
+		log.Errorf("Failed to list httproutes of gateway %v: %+v", key, err)
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for i := range routes.Items {
+		for _, gatewayKey := range routeGatewayKeys(&routes.Items[i]) {
+			if gatewayKey == key {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: routes.Items[i].GetNamespace(), Name: routes.Items[i].GetName()}})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// getRegistrations Returns the hostnames of an HTTPRoute with the address of the first of its
+// Gateways that has one
+func (s *GatewaySource) getRegistrations(obj client.Object) ([]registration, error) {
+	route := obj.(*unstructured.Unstructured)
+	log.Debugf("Got changed httproute %v/%v", route.GetNamespace(), route.GetName())
+	registered, err := s.getRouteHostnames(context.TODO(), route)
+	if err != nil || len(registered.hostnames) == 0 {
+		return nil, err
+	}
+	return []registration{registered}, nil
+}
+
+func (s *GatewaySource) getRouteHostnames(ctx context.Context, route *unstructured.Unstructured) (registration, error) {
+	routeHostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+	for _, ref := range routeParentRefs(route) {
+		gateway, err := s.get(ctx, "Gateway", ref.gatewayKey)
+		if err != nil {
+			return registration{}, err
+		}
+		if gateway == nil {
+			continue
+		}
+		ips := getGatewayIPs(s.hostnames, gateway)
+		if len(ips) == 0 {
+			log.Debugf("Gateway %v has no address yet", ref.gatewayKey)
+			continue
+		}
+		tls := gatewayListenerTLS(gateway, ref.sectionName)
+		registered := registration{hostnames: []hostname.LocalHostname{}, ips: ips}
+		for _, routeHostname := range routeHostnames {
+			name, ok := s.hostnames.TrimDomain(routeHostname)
+			if !ok {
+				continue
+			}
+			registered.hostnames = append(registered.hostnames, hostname.LocalHostname{TLS: tls, Hostname: name})
+		}
+		registered.hostnames = s.hostnames.inNamespaceSubdomain(route.GetNamespace(), registered.hostnames)
+		registered.hostnames = s.hostnames.withInstanceNames("httproute", route, registered.hostnames)
+		registered.hostnames = s.hostnames.withAliases("httproute", route, registered.hostnames)
+		return registered, nil
+	}
+	return registration{}, nil
+}
+
+// parentRef A Gateway an HTTPRoute attaches to
+type parentRef struct {
+	gatewayKey  string
+	sectionName string
+}
+
+func routeParentRefs(route *unstructured.Unstructured) []parentRef {
+	refs := []parentRef{}
+	parents, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	for _, parent := range parents {
+		fields, ok := parent.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		group, found, _ := unstructured.NestedString(fields, "group")
+		if found && group != gatewayAPIGroup {
+			continue
+		}
+		kind, found, _ := unstructured.NestedString(fields, "kind")
+		if found && kind != "Gateway" {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(fields, "name")
+		namespace, found, _ := unstructured.NestedString(fields, "namespace")
+		if !found {
+			namespace = route.GetNamespace()
+		}
+		sectionName, _, _ := unstructured.NestedString(fields, "sectionName")
+		refs = append(refs, parentRef{namespace + "/" + name, sectionName})
+	}
+	return refs
+}
+
+func routeGatewayKeys(route *unstructured.Unstructured) []string {
+	keys := []string{}
+	for _, ref := range routeParentRefs(route) {
+		keys = append(keys, ref.gatewayKey)
+	}
+	return keys
+}
+
+// getGatewayIPs Returns the addresses to advertise from the Gateway status
+func getGatewayIPs(options HostnameOptions, gateway *unstructured.Unstructured) []net.IP {
+	ips := []net.IP{}
+	addresses, _, _ := unstructured.NestedSlice(gateway.Object, "status", "addresses")
+	for _, address := range addresses {
+		fields, ok := address.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		addressType, found, _ := unstructured.NestedString(fields, "type")
+		if found && addressType != "IPAddress" {
+			continue
+		}
+		value, _, _ := unstructured.NestedString(fields, "value")
+		if ip := net.ParseIP(value); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return options.SelectAddresses(ips)
+}
+
+// gatewayListenerTLS Reports whether the listeners a route attaches to terminate TLS,
+// sectionName limits the check to the named listener
+func gatewayListenerTLS(gateway *unstructured.Unstructured, sectionName string) bool {
+	listeners, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	for _, listener := range listeners {
+		fields, ok := listener.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(fields, "name")
+		if sectionName != "" && name != sectionName {
+			continue
+		}
+		protocol, _, _ := unstructured.NestedString(fields, "protocol")
+		if protocol == "HTTPS" {
+			return true
+		}
+	}
+	return false
+}
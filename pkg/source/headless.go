@@ -0,0 +1,133 @@
+package source
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// annotationPerPod Publishes a record for every ready pod of a headless service when set to "true"
+const annotationPerPod = hostname.AnnotationPrefix + "per-pod"
+
+// HeadlessSource Registers a hostname for every ready endpoint of the annotated headless
+// services, e.g. pod-0.myset.local, advertising the node IP of its pod. It reconciles
+// services, changed Endpoints requeue their service
+type HeadlessSource struct {
+	client client.Client
+	// hostnames Maps the hostnames of the services into the broadcast domain
+	hostnames HostnameOptions
+}
+
+// NewHeadlessSource Watches the services, their Endpoints and the pods behind them
+func NewHeadlessSource(sources *Manager, registry *publisher.Registry) (*HeadlessSource, error) {
+	headless := &HeadlessSource{
+		client:    sources.manager.GetClient(),
+		hostnames: sources.hostnames,
+	}
+	for resource, object := range map[string]client.Object{"services": &v1.Service{}, "endpoints": &v1.Endpoints{}, "pods": &v1.Pod{}} {
+		if err := sources.watchCached(resource, object); err != nil {
+			return nil, err
+		}
+	}
+	reconciler := newRegistrationsReconciler("service", sources.cluster, registry, headless.client, &v1.Service{}, sources.ready, headless.getRegistrations)
+	ctrl, err := sources.reconcileCached("headless-service", &v1.Service{}, reconciler)
+	if err != nil {
+		return nil, err
+	}
+	return headless, ctrl.Watch(&source.Kind{Type: &v1.Endpoints{}}, &handler.EnqueueRequestForObject{})
+}
+
+// getRegistrations Returns a registration for every ready endpoint of a per-pod service, none
+// for other services
+func (s *HeadlessSource) getRegistrations(obj client.Object) ([]registration, error) {
+	service := obj.(*v1.Service)
+	if !isPerPodService(service) {
+		return nil, nil
+	}
+	return s.getPodRegistrations(context.TODO(), service)
+}
+
+// isPerPodService Reports whether service is a headless service annotated to publish its pods
+func isPerPodService(service *v1.Service) bool {
+	return service.Spec.ClusterIP == v1.ClusterIPNone && service.Annotations[annotationPerPod] == "true"
+}
+
+// getPodRegistrations Returns the registrations of the ready endpoints of service sorted by
+// hostname, named <hostname of the endpoint or its pod>.<hostname of the service>. They are
+// advertised with the node IP of the pod, which is reachable for pods with a hostPort or on the
+// host network
+func (s *HeadlessSource) getPodRegistrations(ctx context.Context, service *v1.Service) ([]registration, error) {
+	records := map[string]registration{}
+	name := service.Name
+	if annotated, exists := service.Annotations[annotationHostname]; exists {
+		var ok bool
+		if name, ok = s.hostnames.TrimDomain(annotated); !ok {
+			log.Warnf("Ignoring service %v/%v, hostname %v is not in the %v domain", service.Namespace, service.Name, annotated, s.hostnames.Domain)
+			return nil, nil
+		}
+	}
+	endpoints := &v1.Endpoints{}
+	if err := s.client.Get(ctx, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, endpoints); apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	port := 0
+	if annotated, exists := service.Annotations[annotationPort]; exists {
+		if annotatedPort, err := strconv.Atoi(annotated); err == nil && annotatedPort > 0 && annotatedPort <= 65535 {
+			port = annotatedPort
+		} else {
+			log.Warnf("Service %v/%v has an invalid %v annotation %v, using the endpoint port", service.Namespace, service.Name, annotationPort, annotated)
+		}
+	}
+	for _, subset := range endpoints.Subsets {
+		subsetPort := port
+		if subsetPort == 0 && len(subset.Ports) > 0 {
+			subsetPort = int(subset.Ports[0].Port)
+		}
+		for _, address := range subset.Addresses {
+			if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+				continue
+			}
+			pod := &v1.Pod{}
+			if err := s.client.Get(ctx, types.NamespacedName{Namespace: address.TargetRef.Namespace, Name: address.TargetRef.Name}, pod); apierrors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			ip := net.ParseIP(pod.Status.HostIP)
+			if ip == nil {
+				log.Debugf("Pod %v/%v of service %v/%v has no node IP yet", pod.Namespace, pod.Name, service.Namespace, service.Name)
+				continue
+			}
+			podName := address.Hostname
+			if podName == "" {
+				podName = pod.Name
+			}
+			local := hostname.LocalHostname{Hostname: podName + "." + name, Port: subsetPort}
+			local = s.hostnames.withInstanceNames("service", service, s.hostnames.inNamespaceSubdomain(service.Namespace, []hostname.LocalHostname{local}))[0]
+			records[local.Hostname] = registration{hostnames: []hostname.LocalHostname{local}, ips: []net.IP{ip}}
+		}
+	}
+	keys := []string{}
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	registrations := []registration{}
+	for _, key := range keys {
+		registrations = append(registrations, records[key])
+	}
+	return registrations, nil
+}
@@ -0,0 +1,56 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileHeadlessServiceRetried(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "myset", Annotations: map[string]string{annotationPerPod: "true"}}}
+	service.Spec.ClusterIP = v1.ClusterIPNone
+	endpoints := &v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "myset"}}
+	endpoints.Subsets = []v1.EndpointSubset{{Ports: []v1.EndpointPort{{Port: 8080}}}}
+	pods := []*v1.Pod{}
+	for i, hostIP := range []string{"10.0.0.1", "10.0.0.2"} {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: []string{"pod-0", "pod-1"}[i]}}
+		pod.Status.HostIP = hostIP
+		pods = append(pods, pod)
+		endpoints.Subsets[0].Addresses = append(endpoints.Subsets[0].Addresses, v1.EndpointAddress{TargetRef: &v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: pod.Name}})
+	}
+	headless := &HeadlessSource{
+		client:    fake.NewClientBuilder().WithObjects(service, endpoints, pods[0], pods[1]).Build(),
+		hostnames: HostnameOptions{}.withDefaults(),
+	}
+	backend := &testPublisher{err: errors.New("publisher is down"), published: map[string]bool{}}
+	registry := publisher.NewRegistry(nil, backend)
+	ready := make(chan struct{})
+	close(ready)
+	reconciler := newRegistrationsReconciler("service", "", registry, headless.client, &v1.Service{}, ready, headless.getRegistrations)
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "myset"}}
+
+	if _, err := reconciler.Reconcile(context.TODO(), request); err == nil {
+		t.Fatalf("Reconcile() succeeded while publishing fails")
+	}
+	if _, retried := reconciler.retries()["service default/myset"]; !retried {
+		t.Errorf("retries() = %v, want the service", reconciler.retries())
+	}
+
+	backend.err = nil
+	if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+		t.Fatalf("Reconcile() = %+v", err)
+	}
+	if !backend.published["pod-0.myset"] || !backend.published["pod-1.myset"] {
+		t.Errorf("published %v, want pod-0.myset and pod-1.myset", backend.published)
+	}
+	if retries := reconciler.retries(); len(retries) != 0 {
+		t.Errorf("retries() = %v, want none", retries)
+	}
+}
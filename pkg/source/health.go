@@ -0,0 +1,43 @@
+package source
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+)
+
+// healthTimeout How long one update may hold the registry before the process counts as wedged
+const healthTimeout = time.Second * 5
+
+// AddHealthChecks Adds the checks of /healthz and /readyz, which the first manager serves for
+// the liveness and readiness probes of the pod. The process is live while no update holds the
+// registry for long, and ready once every watch of all managers listed its objects and records
+// are published on at least one interface
+func AddHealthChecks(allSources []*Manager, registry *publisher.Registry) error {
+	sources := allSources[0]
+	if err := sources.manager.AddHealthzCheck("registry", func(*http.Request) error {
+		return registry.CheckLive(healthTimeout)
+	}); err != nil {
+		return err
+	}
+	if err := sources.manager.AddReadyzCheck("informers", func(*http.Request) error {
+		for _, watched := range allSources {
+			for _, synced := range watched.synced {
+				if !synced() {
+					return errors.New("watches have not listed their objects yet")
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return sources.manager.AddReadyzCheck("interfaces", func(*http.Request) error {
+		if registry.UpInterfaceCount() == 0 {
+			return errors.New("no broadcast interface is up")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,508 @@
+package source
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	ctrlcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	IngressAPIAuto              = "auto"
+	ingressAPINetworkingV1      = "networking.k8s.io/v1"
+	ingressAPINetworkingV1beta1 = "networking.k8s.io/v1beta1"
+	ingressAPIExtensionsV1beta1 = "extensions/v1beta1"
+)
+
+const (
+	// annotationEnabled Excludes an ingress from being broadcast when set to "false"
+	annotationEnabled = hostname.AnnotationPrefix + "enabled"
+	// annotationText Comma separated key=value pairs published in the TXT record
+	annotationText = hostname.AnnotationPrefix + "txt"
+	// annotationTargetIP Comma separated addresses to advertise instead of the LoadBalancer IPs
+	annotationTargetIP = hostname.AnnotationPrefix + "target-ip"
+	// annotationPort The port to advertise instead of 80/443
+	annotationPort = hostname.AnnotationPrefix + "port"
+	// annotationServiceType The DNS-SD service type to register instead of _http._tcp or _https._tcp
+	annotationServiceType = hostname.AnnotationPrefix + "service-type"
+	// annotationPriority The SRV priority of the ingress hostnames
+	annotationPriority = hostname.AnnotationPrefix + "srv-priority"
+	// annotationWeight The SRV weight of the ingress hostnames
+	annotationWeight = hostname.AnnotationPrefix + "srv-weight"
+	// annotationTTL The TTL in seconds of the records published for the ingress
+	annotationTTL = hostname.AnnotationPrefix + "ttl"
+	// annotationPublish Opts a LoadBalancer service into being broadcast
+	annotationPublish = hostname.AnnotationPrefix + "publish"
+	// annotationWildcardHosts Comma separated names registered for wildcard rules, e.g. grafana
+	// registers grafana.apps.local for *.apps.local
+	annotationWildcardHosts = hostname.AnnotationPrefix + "wildcard-hosts"
+	// annotationHostname The .local hostname to broadcast a service as instead of <name>.local
+	annotationHostname = hostname.AnnotationPrefix + "hostname"
+	// annotationSSDPDescription The path of the UPnP device description served through the ingress,
+	// which makes the hostnames advertised over SSDP with --ssdp
+	annotationSSDPDescription = hostname.AnnotationPrefix + "ssdp-description"
+	// annotationWSDiscovery The space separated WS-Discovery types of the service, e.g.
+	// dn:NetworkVideoTransmitter, which makes the hostnames advertised with --ws-discovery
+	annotationWSDiscovery = hostname.AnnotationPrefix + "ws-discovery"
+)
+
+// IngressAPIPreference The ingress API versions probed by auto-detection, newest first
+var IngressAPIPreference = []string{ingressAPINetworkingV1, ingressAPINetworkingV1beta1, ingressAPIExtensionsV1beta1}
+
+func uint16Ptr(value uint16) *uint16 {
+	return &value
+}
+
+// DetectServedVersion Returns the first of the given API versions that serves resource
+func DetectServedVersion(clientset *kubernetes.Clientset, apiVersions []string, resource string) (string, error) {
+	for _, apiVersion := range apiVersions {
+		resources, err := clientset.Discovery().ServerResourcesForGroupVersion(apiVersion)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				log.Debugf("API version %v is not served", apiVersion)
+				continue
+			}
+			return "", fmt.Errorf("failed to query API server for %v: %+v", apiVersion, err)
+		}
+		for _, served := range resources.APIResources {
+			if served.Name == resource {
+				return apiVersion, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("None of the API versions %v serve %v", strings.Join(apiVersions, ", "), resource)
+}
+
+// GetIngressSource Returns an empty object of the given ingress API version along with a
+// function that converts the watched objects to networking.k8s.io/v1 Ingresses
+func GetIngressSource(apiVersion string) (client.Object, func(interface{}) *networkingv1.Ingress, error) {
+	switch apiVersion {
+	case ingressAPINetworkingV1:
+		return &networkingv1.Ingress{}, func(obj interface{}) *networkingv1.Ingress {
+			return obj.(*networkingv1.Ingress)
+		}, nil
+	case ingressAPINetworkingV1beta1:
+		return &v1beta1.Ingress{}, func(obj interface{}) *networkingv1.Ingress {
+			return ingressFromV1beta1(obj.(*v1beta1.Ingress))
+		}, nil
+	case ingressAPIExtensionsV1beta1:
+		return &extensionsv1beta1.Ingress{}, func(obj interface{}) *networkingv1.Ingress {
+			return ingressFromV1beta1(ingressFromExtensionsV1beta1(obj.(*extensionsv1beta1.Ingress)))
+		}, nil
+	}
+	return nil, nil, fmt.Errorf("Unsupported ingress API version %v, expected one of %v", apiVersion, strings.Join(IngressAPIPreference, ", "))
+}
+
+// ingressFromExtensionsV1beta1 Maps an extensions/v1beta1 Ingress onto the identically shaped networking.k8s.io/v1beta1 type
+func ingressFromExtensionsV1beta1(in *extensionsv1beta1.Ingress) *v1beta1.Ingress {
+	out := &v1beta1.Ingress{
+		ObjectMeta: in.ObjectMeta,
+		Spec: v1beta1.IngressSpec{
+			IngressClassName: in.Spec.IngressClassName,
+		},
+		Status: v1beta1.IngressStatus{
+			LoadBalancer: in.Status.LoadBalancer,
+		},
+	}
+	if in.Spec.Backend != nil {
+		out.Spec.Backend = &v1beta1.IngressBackend{
+			ServiceName: in.Spec.Backend.ServiceName,
+			ServicePort: in.Spec.Backend.ServicePort,
+			Resource:    in.Spec.Backend.Resource,
+		}
+	}
+	for _, tls := range in.Spec.TLS {
+		out.Spec.TLS = append(out.Spec.TLS, v1beta1.IngressTLS{
+			Hosts:      tls.Hosts,
+			SecretName: tls.SecretName,
+		})
+	}
+	for _, rule := range in.Spec.Rules {
+		outRule := v1beta1.IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			outRule.HTTP = &v1beta1.HTTPIngressRuleValue{}
+			for _, path := range rule.HTTP.Paths {
+				outRule.HTTP.Paths = append(outRule.HTTP.Paths, v1beta1.HTTPIngressPath{
+					Path:     path.Path,
+					PathType: (*v1beta1.PathType)(path.PathType),
+					Backend: v1beta1.IngressBackend{
+						ServiceName: path.Backend.ServiceName,
+						ServicePort: path.Backend.ServicePort,
+						Resource:    path.Backend.Resource,
+					},
+				})
+			}
+		}
+		out.Spec.Rules = append(out.Spec.Rules, outRule)
+	}
+	return out
+}
+
+// NamespaceScope The namespaces watched by the sources, configured through --namespace and --exclude-namespace
+type NamespaceScope struct {
+	// Namespaces is empty to watch all namespaces
+	Namespaces []string
+	Excluded   []string
+}
+
+// newCache Returns the constructor of the manager cache, which watches the listed namespaces
+// or all of them but the excluded ones. The API server leaves out the objects of the excluded
+// namespaces and the objects of the types of labelSelectors not matching their selector
+func (n NamespaceScope) newCache(labelSelectors map[schema.GroupVersionKind]string) ctrlcache.NewCacheFunc {
+	newScoped := ctrlcache.New
+	if len(n.Namespaces) > 0 {
+		namespaces := []string{}
+		for _, namespace := range n.Namespaces {
+			if !n.isExcluded(namespace) {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+		newScoped = newMultiNamespaceCache(namespaces)
+	}
+	fieldSelector := n.fieldSelector()
+	if fieldSelector == "" && len(labelSelectors) == 0 {
+		return newScoped
+	}
+	return newSelectingCache(newScoped, labelSelectors, fieldSelector)
+}
+
+// fieldSelector Returns the field selector leaving out the excluded namespaces when all
+// namespaces are watched, empty otherwise
+func (n NamespaceScope) fieldSelector() string {
+	if len(n.Namespaces) > 0 || len(n.Excluded) == 0 {
+		return ""
+	}
+	selectors := []fields.Selector{}
+	for _, namespace := range n.Excluded {
+		selectors = append(selectors, fields.OneTermNotEqualSelector("metadata.namespace", namespace))
+	}
+	return fields.AndSelectors(selectors...).String()
+}
+
+func (n NamespaceScope) isExcluded(namespace string) bool {
+	for _, excluded := range n.Excluded {
+		if excluded == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func (n NamespaceScope) String() string {
+	if len(n.Namespaces) == 0 {
+		if len(n.Excluded) == 0 {
+			return "all namespaces"
+		}
+		return "all namespaces except " + strings.Join(n.Excluded, ", ")
+	}
+	return "namespaces " + strings.Join(n.Namespaces, ", ")
+}
+
+// ingressFromV1beta1 Maps the fields we care about from a v1beta1 Ingress onto the v1 shape
+func ingressFromV1beta1(in *v1beta1.Ingress) *networkingv1.Ingress {
+	out := &networkingv1.Ingress{
+		ObjectMeta: in.ObjectMeta,
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: in.Spec.IngressClassName,
+		},
+		Status: networkingv1.IngressStatus{
+			LoadBalancer: in.Status.LoadBalancer,
+		},
+	}
+	if in.Spec.Backend != nil {
+		backend := ingressBackendFromV1beta1(*in.Spec.Backend)
+		out.Spec.DefaultBackend = &backend
+	}
+	for _, tls := range in.Spec.TLS {
+		out.Spec.TLS = append(out.Spec.TLS, networkingv1.IngressTLS{
+			Hosts:      tls.Hosts,
+			SecretName: tls.SecretName,
+		})
+	}
+	for _, rule := range in.Spec.Rules {
+		outRule := networkingv1.IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			outRule.HTTP = &networkingv1.HTTPIngressRuleValue{}
+			for _, path := range rule.HTTP.Paths {
+				outRule.HTTP.Paths = append(outRule.HTTP.Paths, networkingv1.HTTPIngressPath{
+					Path:     path.Path,
+					PathType: (*networkingv1.PathType)(path.PathType),
+					Backend:  ingressBackendFromV1beta1(path.Backend),
+				})
+			}
+		}
+		out.Spec.Rules = append(out.Spec.Rules, outRule)
+	}
+	return out
+}
+
+func ingressBackendFromV1beta1(in v1beta1.IngressBackend) networkingv1.IngressBackend {
+	out := networkingv1.IngressBackend{Resource: in.Resource}
+	if in.ServiceName != "" {
+		out.Service = &networkingv1.IngressServiceBackend{Name: in.ServiceName}
+		if in.ServicePort.Type == intstr.String {
+			out.Service.Port.Name = in.ServicePort.StrVal
+		} else {
+			out.Service.Port.Number = in.ServicePort.IntVal
+		}
+	}
+	return out
+}
+
+// removedHostnames Returns the hostnames of old that are not registered under the same key in current
+func removedHostnames(old []hostname.LocalHostname, current []hostname.LocalHostname) []hostname.LocalHostname {
+	keys := map[string]bool{}
+	for _, local := range current {
+		keys[local.Key()] = true
+	}
+	removed := []hostname.LocalHostname{}
+	for _, local := range old {
+		if !keys[local.Key()] {
+			removed = append(removed, local)
+		}
+	}
+	return removed
+}
+
+// getLoadBalancerIPs Returns the addresses to advertise for a LoadBalancer status,
+// resolving hostname entries like those of AWS load balancers
+func getLoadBalancerIPs(options HostnameOptions, ingresses []v1.LoadBalancerIngress) []net.IP {
+	ips := []net.IP{}
+	for _, ingress := range ingresses {
+		if ip := net.ParseIP(ingress.IP); ip != nil {
+			ips = append(ips, ip)
+		} else if ingress.Hostname != "" {
+			resolved, err := hostname.Resolve(ingress.Hostname)
+			if err != nil {
+				log.Warnf("Failed to resolve LoadBalancer hostname %v: %+v", ingress.Hostname, err)
+			}
+			ips = append(ips, resolved...)
+		}
+	}
+	return options.SelectAddresses(ips)
+}
+
+// GetIngressHostnames Returns the hostnames of the rules of ingress that options map into the
+// broadcast domain and the addresses to advertise them with, there are none while the ingress
+// has no address
+func GetIngressHostnames(options HostnameOptions, ingress *networkingv1.Ingress) ([]hostname.LocalHostname, []net.IP) {
+	options = options.withDefaults()
+	hostnames := []hostname.LocalHostname{}
+	if ingress.Annotations[annotationEnabled] == "false" {
+		log.WithFields(ingressFields(ingress)).Debugf("Ingress %v/%v has broadcasting disabled", ingress.Namespace, ingress.Name)
+		return hostnames, nil
+	}
+	// Annotations apply to every hostname of the ingress
+	template := hostname.LocalHostname{}
+	if annotated, exists := ingress.Annotations[annotationText]; exists {
+		template.Text = parseTextAnnotation(annotated)
+	}
+	if annotated, exists := ingress.Annotations[annotationPort]; exists {
+		if port, err := strconv.Atoi(annotated); err == nil && port > 0 && port <= 65535 {
+			template.Port = port
+		} else {
+			log.WithFields(ingressFields(ingress)).Warnf("Ingress %v/%v has an invalid %v annotation %v, using the default port", ingress.Namespace, ingress.Name, annotationPort, annotated)
+		}
+	}
+	if annotated, exists := ingress.Annotations[annotationServiceType]; exists {
+		if serviceType := strings.TrimSuffix(annotated, "."); hostname.ServiceTypePattern.MatchString(serviceType) {
+			template.ServiceType = serviceType
+		} else {
+			log.WithFields(ingressFields(ingress)).Warnf("Ingress %v/%v has an invalid %v annotation %v, using the default service type", ingress.Namespace, ingress.Name, annotationServiceType, annotated)
+		}
+	}
+	if annotated, exists := ingress.Annotations[annotationPriority]; exists {
+		if priority, err := strconv.ParseUint(annotated, 10, 16); err == nil {
+			template.Priority = uint16Ptr(uint16(priority))
+		} else {
+			log.WithFields(ingressFields(ingress)).Warnf("Ingress %v/%v has an invalid %v annotation %v, using the default priority", ingress.Namespace, ingress.Name, annotationPriority, annotated)
+		}
+	}
+	if annotated, exists := ingress.Annotations[annotationWeight]; exists {
+		if weight, err := strconv.ParseUint(annotated, 10, 16); err == nil {
+			template.Weight = uint16Ptr(uint16(weight))
+		} else {
+			log.WithFields(ingressFields(ingress)).Warnf("Ingress %v/%v has an invalid %v annotation %v, using the default weight", ingress.Namespace, ingress.Name, annotationWeight, annotated)
+		}
+	}
+	if annotated, exists := ingress.Annotations[annotationTTL]; exists {
+		if ttl, err := strconv.ParseUint(annotated, 10, 32); err == nil && ttl > 0 {
+			template.TTL = uint32(ttl)
+		} else {
+			log.WithFields(ingressFields(ingress)).Warnf("Ingress %v/%v has an invalid %v annotation %v, using the default TTL", ingress.Namespace, ingress.Name, annotationTTL, annotated)
+		}
+	}
+	if annotated, exists := ingress.Annotations[annotationSSDPDescription]; exists {
+		if strings.HasPrefix(annotated, "/") {
+			template.SSDPDescription = annotated
+		} else {
+			log.WithFields(ingressFields(ingress)).Warnf("Ingress %v/%v has an invalid %v annotation %v, it is not a path", ingress.Namespace, ingress.Name, annotationSSDPDescription, annotated)
+		}
+	}
+	if annotated, exists := ingress.Annotations[annotationWSDiscovery]; exists {
+		if types, ok := publisher.WSDTypes(annotated); ok {
+			template.WSDiscoveryTypes = types
+		} else {
+			log.WithFields(ingressFields(ingress)).Warnf("Ingress %v/%v has an invalid %v annotation %v, types take one of the prefixes dn, tds, wsdp or pub", ingress.Namespace, ingress.Name, annotationWSDiscovery, annotated)
+		}
+	}
+	for _, rule := range ingress.Spec.Rules {
+		for _, host := range expandWildcardHost(ingress, rule.Host) {
+			name, ok := options.TrimDomain(host)
+			if !ok {
+				log.WithFields(ingressFields(ingress)).Debugf("Skipping host %v of ingress %v/%v, it is not in the %v domain or a mapped domain", host, ingress.Namespace, ingress.Name, options.Domain)
+				continue
+			}
+			local := template
+			local.Hostname = name
+			local.TLS = isTLSHost(ingress.Spec.TLS, host)
+			paths := rulePaths(rule)
+			if !options.InstancePerPath {
+				if len(paths) > 0 && local.Text == nil {
+					local.Text = []string{"path=" + paths[0]}
+				}
+				hostnames = append(hostnames, local)
+				continue
+			}
+			if len(paths) == 0 {
+				hostnames = append(hostnames, local)
+			}
+			for _, path := range paths {
+				instance := local
+				if path != "/" {
+					// grafana (grafana.example) for /grafana under grafana.example
+					segments := strings.Split(strings.Trim(path, "/"), "/")
+					instance.Instance = fmt.Sprintf("%v (%v)", segments[len(segments)-1], name)
+				}
+				if instance.Text == nil {
+					instance.Text = []string{"path=" + path}
+				}
+				hostnames = append(hostnames, instance)
+			}
+		}
+	}
+	if annotated, exists := ingress.Annotations[annotationTargetIP]; exists {
+		if ips, ok := parseIPList(annotated); ok {
+			return hostnames, options.SelectAddresses(hostname.SortIPs(ips))
+		}
+		log.WithFields(ingressFields(ingress)).Warnf("Ingress %v/%v has an invalid %v annotation %v, using the LoadBalancer IP", ingress.Namespace, ingress.Name, annotationTargetIP, annotated)
+	}
+	return hostnames, getLoadBalancerIPs(options, ingress.Status.LoadBalancer.Ingress)
+}
+
+// expandWildcardHost Returns the rule host itself, or for a wildcard host the names listed in the
+// wildcard-hosts annotation, which are either single labels or full hostnames matching the wildcard.
+// Wildcards in a later label are not expanded
+func expandWildcardHost(ingress *networkingv1.Ingress, host string) []string {
+	if !strings.HasPrefix(host, "*.") {
+		return []string{host}
+	}
+	if strings.Contains(host[2:], "*") {
+		log.WithFields(ingressFields(ingress)).Warnf("Skipping wildcard host %v of ingress %v/%v, only a leading * is expanded", host, ingress.Namespace, ingress.Name)
+		return nil
+	}
+	annotated, exists := ingress.Annotations[annotationWildcardHosts]
+	if !exists {
+		log.WithFields(ingressFields(ingress)).Warnf("Skipping wildcard host %v of ingress %v/%v, list the names to register in the %v annotation", host, ingress.Namespace, ingress.Name, annotationWildcardHosts)
+		return nil
+	}
+	suffix := host[1:]
+	hosts := []string{}
+	for _, name := range strings.Split(annotated, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !strings.Contains(name, ".") {
+			name += suffix
+		}
+		if dot := strings.Index(name, "."); dot <= 0 || strings.Contains(name[:dot], "*") || !strings.EqualFold(name[dot:], suffix) {
+			log.WithFields(ingressFields(ingress)).Warnf("Ignoring %v in the %v annotation of ingress %v/%v, it does not match %v", name, annotationWildcardHosts, ingress.Namespace, ingress.Name, host)
+			continue
+		}
+		hosts = append(hosts, name)
+	}
+	return hosts
+}
+
+// rulePaths Returns the paths a browser can open for a rule, which are its paths that
+// are not regular expressions, with / first when it is routed
+func rulePaths(rule networkingv1.IngressRule) []string {
+	if rule.HTTP == nil {
+		return nil
+	}
+	root := false
+	paths := []string{}
+	for _, path := range rule.HTTP.Paths {
+		if path.Path == "" || path.Path == "/" {
+			root = true
+		} else if strings.HasPrefix(path.Path, "/") && !strings.ContainsAny(path.Path, "()[]*?+$^|\\") {
+			paths = append(paths, path.Path)
+		}
+	}
+	if root {
+		paths = append([]string{"/"}, paths...)
+	}
+	return paths
+}
+
+// isTLSHost Reports whether host is listed in the hosts of a tls entry, either literally
+// or by a wildcard. An entry without hosts covers all hosts of the ingress
+func isTLSHost(tls []networkingv1.IngressTLS, host string) bool {
+	for _, entry := range tls {
+		if len(entry.Hosts) == 0 {
+			return true
+		}
+		for _, tlsHost := range entry.Hosts {
+			if strings.EqualFold(tlsHost, host) {
+				return true
+			}
+			// A wildcard matches exactly one label
+			if strings.HasPrefix(tlsHost, "*.") {
+				if dot := strings.Index(host, "."); dot > 0 && strings.EqualFold(tlsHost[1:], host[dot:]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parseIPList Parses a comma separated list of addresses
+func parseIPList(value string) ([]net.IP, bool) {
+	ips := []net.IP{}
+	for _, field := range strings.Split(value, ",") {
+		ip := net.ParseIP(strings.TrimSpace(field))
+		if ip == nil {
+			return nil, false
+		}
+		ips = append(ips, ip)
+	}
+	return ips, true
+}
+
+// parseTextAnnotation Splits "key1=val1,key2=val2" into TXT record entries
+func parseTextAnnotation(value string) []string {
+	text := []string{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair != "" {
+			text = append(text, pair)
+		}
+	}
+	return text
+}
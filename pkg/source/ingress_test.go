@@ -0,0 +1,187 @@
+package source
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestIngress Returns an ingress with a rule for each host, routing /, and the address 10.0.0.1
+func newTestIngress(annotations map[string]string, hosts ...string) *networkingv1.Ingress {
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "grafana", Annotations: annotations}}
+	for _, host := range hosts {
+		ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{Host: host, IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{{Path: "/"}}},
+		}})
+	}
+	ingress.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	return ingress
+}
+
+// hostnameStrings Returns the hostnames of hostnames
+func hostnameStrings(hostnames []hostname.LocalHostname) []string {
+	names := []string{}
+	for _, local := range hostnames {
+		names = append(names, local.Hostname)
+	}
+	return names
+}
+
+func TestGetIngressHostnames(t *testing.T) {
+	appended := HostnameOptions{Options: hostname.DefaultOptions()}
+	appended.OtherDomains = hostname.OtherDomainsAppend
+	mapped := HostnameOptions{Options: hostname.DefaultOptions()}
+	mapped.MappedDomains = []string{"example.com"}
+	tests := []struct {
+		name    string
+		options HostnameOptions
+		ingress *networkingv1.Ingress
+		want    []string
+	}{
+		{"local hosts", HostnameOptions{}, newTestIngress(nil, "grafana.local", "prometheus.local"), []string{"grafana", "prometheus"}},
+		{"other domains skipped", HostnameOptions{}, newTestIngress(nil, "grafana.local", "grafana.example.com"), []string{"grafana"}},
+		{"other domains appended", appended, newTestIngress(nil, "grafana.example.com"), []string{"grafana.example.com"}},
+		{"mapped domain", mapped, newTestIngress(nil, "grafana.example.com", "grafana.example.org"), []string{"grafana"}},
+		{"other broadcast domain", HostnameOptions{Options: hostname.Options{Domain: "home.arpa"}}, newTestIngress(nil, "grafana.home.arpa", "grafana.local"), []string{"grafana"}},
+		{"disabled", HostnameOptions{}, newTestIngress(map[string]string{annotationEnabled: "false"}, "grafana.local"), []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hostnames, ips := GetIngressHostnames(test.options, test.ingress)
+			if got := hostnameStrings(hostnames); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("GetIngressHostnames() hostnames = %v, want %v", got, test.want)
+			}
+			if len(test.want) > 0 && !reflect.DeepEqual(hostname.IPStrings(ips), []string{"10.0.0.1"}) {
+				t.Errorf("GetIngressHostnames() ips = %v, want [10.0.0.1]", hostname.IPStrings(ips))
+			}
+		})
+	}
+}
+
+func TestGetIngressHostnamesAnnotations(t *testing.T) {
+	ingress := newTestIngress(map[string]string{
+		annotationPort:        "8443",
+		annotationServiceType: "_grafana._tcp.",
+		annotationTargetIP:    "fd00::2, 10.0.0.2",
+		annotationText:        "version=1, ,path=/dashboards",
+	}, "grafana.local")
+	ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{"*.local"}}}
+	ipv6 := HostnameOptions{Options: hostname.DefaultOptions()}
+	ipv6.IPFamily = hostname.IPFamilyIPv6
+	tests := []struct {
+		name    string
+		options HostnameOptions
+		wantIPs []string
+	}{
+		{"both families", HostnameOptions{}, []string{"10.0.0.2", "fd00::2"}},
+		{"ipv6 only", ipv6, []string{"fd00::2"}},
+	}
+	want := []hostname.LocalHostname{{TLS: true, Hostname: "grafana", Port: 8443, ServiceType: "_grafana._tcp", Text: []string{"version=1", "path=/dashboards"}}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hostnames, ips := GetIngressHostnames(test.options, ingress)
+			if !reflect.DeepEqual(hostnames, want) {
+				t.Errorf("GetIngressHostnames() hostnames = %+v, want %+v", hostnames, want)
+			}
+			if got := hostname.IPStrings(ips); !reflect.DeepEqual(got, test.wantIPs) {
+				t.Errorf("GetIngressHostnames() ips = %v, want %v", got, test.wantIPs)
+			}
+		})
+	}
+}
+
+func TestGetIngressHostnamesInstancePerPath(t *testing.T) {
+	ingress := newTestIngress(nil, "grafana.local")
+	ingress.Spec.Rules[0].HTTP.Paths = append(ingress.Spec.Rules[0].HTTP.Paths, networkingv1.HTTPIngressPath{Path: "/explore/logs"}, networkingv1.HTTPIngressPath{Path: "/api/(.*)"})
+	tests := []struct {
+		instancePerPath bool
+		want            []hostname.LocalHostname
+	}{
+		{false, []hostname.LocalHostname{{Hostname: "grafana", Text: []string{"path=/"}}}},
+		{true, []hostname.LocalHostname{
+			{Hostname: "grafana", Text: []string{"path=/"}},
+			{Hostname: "grafana", Instance: "logs (grafana)", Text: []string{"path=/explore/logs"}},
+		}},
+	}
+	for _, test := range tests {
+		hostnames, _ := GetIngressHostnames(HostnameOptions{InstancePerPath: test.instancePerPath}, ingress)
+		if !reflect.DeepEqual(hostnames, test.want) {
+			t.Errorf("GetIngressHostnames() with InstancePerPath %v = %+v, want %+v", test.instancePerPath, hostnames, test.want)
+		}
+	}
+}
+
+func TestExpandWildcardHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      string
+		annotated *string
+		want      []string
+	}{
+		{"no wildcard", "grafana.example.local", nil, []string{"grafana.example.local"}},
+		{"not annotated", "*.example.local", nil, nil},
+		{"labels", "*.example.local", stringPtr("grafana, prometheus,,"), []string{"grafana.example.local", "prometheus.example.local"}},
+		{"full hostnames", "*.example.local", stringPtr("grafana.example.local,Prometheus.EXAMPLE.local"), []string{"grafana.example.local", "Prometheus.EXAMPLE.local"}},
+		{"other suffix", "*.example.local", stringPtr("grafana.other.local,grafana.local"), []string{}},
+		{"more than one label", "*.example.local", stringPtr("a.grafana.example.local"), []string{}},
+		{"wildcard in the name", "*.example.local", stringPtr("*.example.local,graf*na.example.local,*"), []string{}},
+		{"nested wildcard", "*.*.example.local", stringPtr("grafana,grafana.apps.example.local"), nil},
+		{"wildcard under a subdomain", "*.apps.example.local", stringPtr("grafana,grafana.example.local"), []string{"grafana.apps.example.local"}},
+		{"wildcard in a label", "grafana-*.example.local", stringPtr("grafana"), []string{"grafana-*.example.local"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if test.annotated != nil {
+				annotations[annotationWildcardHosts] = *test.annotated
+			}
+			if got := expandWildcardHost(newTestIngress(annotations), test.host); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expandWildcardHost(%q) = %#v, want %#v", test.host, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetIngressHostnamesWildcards(t *testing.T) {
+	annotations := map[string]string{annotationWildcardHosts: "grafana,prometheus.example.local"}
+	tests := []struct {
+		name    string
+		options HostnameOptions
+		hosts   []string
+		want    []string
+	}{
+		{"local domain", HostnameOptions{}, []string{"*.example.local"}, []string{"grafana.example", "prometheus.example"}},
+		{"trimmed domain", HostnameOptions{Options: hostname.Options{Domain: "example.local"}}, []string{"*.example.local"}, []string{"grafana", "prometheus"}},
+		{"other domain", HostnameOptions{Options: hostname.Options{Domain: "home.arpa"}}, []string{"*.example.local"}, []string{}},
+		{"mapped domain", HostnameOptions{Options: hostname.Options{Domain: "home.arpa", MappedDomains: []string{"example.local"}}}, []string{"*.example.local"}, []string{"grafana", "prometheus"}},
+		{"other domains appended", HostnameOptions{Options: hostname.Options{Domain: "home.arpa", OtherDomains: hostname.OtherDomainsAppend}}, []string{"*.example.local"}, []string{"grafana.example.local", "prometheus.example.local"}},
+		{"nested wildcard", HostnameOptions{}, []string{"*.*.local", "grafana.local"}, []string{"grafana"}},
+		{"wildcard under the broadcast domain", HostnameOptions{Options: hostname.Options{Domain: "home.arpa"}}, []string{"*.apps.home.arpa"}, []string{"grafana.apps"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hostnames, _ := GetIngressHostnames(test.options, newTestIngress(annotations, test.hosts...))
+			if got := hostnameStrings(hostnames); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("GetIngressHostnames() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetIngressHostnamesWildcardTLS(t *testing.T) {
+	ingress := newTestIngress(map[string]string{annotationWildcardHosts: "grafana"}, "*.example.local", "prometheus.local")
+	ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{"*.example.local"}}}
+	hostnames, _ := GetIngressHostnames(HostnameOptions{}, ingress)
+	want := []hostname.LocalHostname{{TLS: true, Hostname: "grafana.example", Text: []string{"path=/"}}, {Hostname: "prometheus", Text: []string{"path=/"}}}
+	if !reflect.DeepEqual(hostnames, want) {
+		t.Errorf("GetIngressHostnames() = %+v, want %+v", hostnames, want)
+	}
+}
+
+func stringPtr(value string) *string {
+	return &value
+}
@@ -0,0 +1,51 @@
+package source
+
+import (
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// instanceData The fields an InstanceTemplate is executed with, e.g. {{ .Namespace }}-{{ .Name }}
+type instanceData struct {
+	// Kind The kind of the object, e.g. ingress
+	Kind        string
+	Namespace   string
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+	// Hostname The hostname without the domain
+	Hostname string
+	// Instance The instance name the hostname has without the template
+	Instance string
+}
+
+// withInstanceNames Returns hostnames with the instance names InstanceTemplate gives them for
+// obj of kind. Hostnames the template fails for or renders empty keep their instance name
+func (o HostnameOptions) withInstanceNames(kind string, obj metav1.Object, hostnames []hostname.LocalHostname) []hostname.LocalHostname {
+	if o.InstanceTemplate == nil || len(hostnames) == 0 {
+		return hostnames
+	}
+	named := make([]hostname.LocalHostname, 0, len(hostnames))
+	for _, local := range hostnames {
+		data := instanceData{
+			Kind:        kind,
+			Namespace:   obj.GetNamespace(),
+			Name:        obj.GetName(),
+			Labels:      obj.GetLabels(),
+			Annotations: obj.GetAnnotations(),
+			Hostname:    local.Hostname,
+			Instance:    local.InstanceName(),
+		}
+		instance := &strings.Builder{}
+		if err := o.InstanceTemplate.Execute(instance, data); err != nil {
+			log.Warnf("Failed to name the instance of %v of %v %v/%v, keeping %v: %+v", local.Hostname, kind, obj.GetNamespace(), obj.GetName(), data.Instance, err)
+		} else if name := strings.TrimSpace(instance.String()); name != "" {
+			local.Instance = name
+		}
+		named = append(named, local)
+	}
+	return named
+}
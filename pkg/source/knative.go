@@ -0,0 +1,119 @@
+package source
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+const knativeServingGroup = "serving.knative.dev"
+
+var (
+	knativeRouteKind = schema.GroupVersionKind{Group: knativeServingGroup, Version: "v1", Kind: "Route"}
+	// KnativeDomainMappingPreference The DomainMapping API versions probed at startup, newest first
+	KnativeDomainMappingPreference = []string{knativeServingGroup + "/v1beta1", knativeServingGroup + "/v1alpha1"}
+)
+
+// KnativeSource Registers the .local domains of Knative Routes and DomainMappings
+// against the LoadBalancer IP of the Knative networking layer (Kourier, Istio, ...)
+type KnativeSource struct {
+	ingressServiceKey  string
+	ingressServices    corelisters.ServiceNamespaceLister
+	ingressServiceName string
+	hostnames          HostnameOptions
+}
+
+// NewKnativeSource Watches Knative Routes, and DomainMappings when domainMappingAPI is set.
+// ingressService is the namespace/name of the LoadBalancer service fronting Knative
+func NewKnativeSource(sources *Manager, ingressService string, domainMappingAPI string, registry *publisher.Registry) (*KnativeSource, error) {
+	parts := strings.SplitN(ingressService, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("Knative ingress service %v is not of the form namespace/name", ingressService)
+	}
+
+	// Only watch the single ingress service. Routes pick up a changed address on their next resync.
+	services := sources.selectedFactory(parts[0], "", "metadata.name="+parts[1]).Core().V1().Services()
+	sources.track("services", services.Informer(), nil)
+	source := &KnativeSource{
+		ingressServiceKey:  ingressService,
+		ingressServices:    services.Lister().Services(parts[0]),
+		ingressServiceName: parts[1],
+		hostnames:          sources.hostnames,
+	}
+
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(knativeRouteKind)
+	if err := sources.WatchHostnames("knative route", route, registry, source.getHostnames); err != nil {
+		return nil, err
+	}
+	if domainMappingAPI != "" {
+		gv, _ := schema.ParseGroupVersion(domainMappingAPI)
+		domainMapping := &unstructured.Unstructured{}
+		domainMapping.SetGroupVersionKind(gv.WithKind("DomainMapping"))
+		if err := sources.WatchHostnames("domainmapping", domainMapping, registry, source.getHostnames); err != nil {
+			return nil, err
+		}
+	}
+	return source, nil
+}
+
+// getHostnames Returns the .local hostnames in the status URLs of a Route or DomainMapping
+func (s *KnativeSource) getHostnames(obj interface{}) ([]hostname.LocalHostname, []net.IP) {
+	resource := obj.(*unstructured.Unstructured)
+	urls := []string{}
+	if statusURL, found, _ := unstructured.NestedString(resource.Object, "status", "url"); found {
+		urls = append(urls, statusURL)
+	}
+	traffic, _, _ := unstructured.NestedSlice(resource.Object, "status", "traffic")
+	for _, target := range traffic {
+		if fields, ok := target.(map[string]interface{}); ok {
+			if targetURL, found, _ := unstructured.NestedString(fields, "url"); found {
+				urls = append(urls, targetURL)
+			}
+		}
+	}
+
+	hostnames := []hostname.LocalHostname{}
+	seen := map[string]bool{}
+	for _, rawURL := range urls {
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			log.Debugf("Ignoring unparsable URL %v of %v", rawURL, resource.GetName())
+			continue
+		}
+		name, ok := s.hostnames.TrimDomain(parsed.Hostname())
+		if !ok {
+			continue
+		}
+		local := hostname.LocalHostname{TLS: parsed.Scheme == "https", Hostname: name}
+		if !seen[local.Key()] {
+			seen[local.Key()] = true
+			hostnames = append(hostnames, local)
+		}
+	}
+	if len(hostnames) == 0 {
+		return nil, nil
+	}
+
+	ips := s.getIngressIPs()
+	if len(ips) == 0 {
+		log.Debugf("Knative ingress service %v has no LoadBalancer IP yet", s.ingressServiceKey)
+	}
+	return hostnames, ips
+}
+
+func (s *KnativeSource) getIngressIPs() []net.IP {
+	service, err := s.ingressServices.Get(s.ingressServiceName)
+	if err != nil {
+		return nil
+	}
+	return getLoadBalancerIPs(s.hostnames, service.Status.LoadBalancer.Ingress)
+}
@@ -0,0 +1,11 @@
+package source
+
+import (
+	log "github.com/sirupsen/logrus"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// ingressFields Returns the namespace and name of ingress as log fields
+func ingressFields(ingress *networkingv1.Ingress) log.Fields {
+	return log.Fields{"namespace": ingress.Namespace, "ingress": ingress.Name}
+}
@@ -0,0 +1,359 @@
+// Package source Watches ingresses and the other supported Kubernetes objects in a
+// controller-runtime Manager and registers their hostnames with a publisher.Registry
+package source
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// informerResync How often the informers replay their objects, which picks up addresses that
+	// depend on other objects or DNS
+	informerResync = time.Second * 30
+	// leaseDuration How long standbys wait before taking over the lease of a leader that stopped renewing it
+	leaseDuration = time.Second * 15
+	renewDeadline = time.Second * 10
+	retryPeriod   = time.Second * 2
+)
+
+// HostnameOptions How the sources pick, name and address the hostnames of objects, configured
+// once at startup through the flags. The Domain of the zero value is hostname.DefaultDomain
+type HostnameOptions struct {
+	hostname.Options
+	// InstancePerPath Publishes every path of an ingress rule as its own DNS-SD instance,
+	// --instance-per-path
+	InstancePerPath bool
+	// InstanceTemplate Names the DNS-SD instances of the hostnames of objects when set, executed
+	// with an instanceData
+	InstanceTemplate *template.Template
+	// NamespaceSubdomains Publishes the hostnames of namespaced objects under a subdomain named
+	// after their namespace, e.g. grafana.monitoring.local, when set
+	NamespaceSubdomains bool
+	// SubdomainOfNamespace Replaces the subdomain of the namespaces it has, also without
+	// NamespaceSubdomains. An empty subdomain publishes the hostnames of a namespace unchanged
+	SubdomainOfNamespace map[string]string
+}
+
+// withDefaults Returns the options with the default domain when they have none
+func (o HostnameOptions) withDefaults() HostnameOptions {
+	if o.Domain == "" {
+		o.Domain = hostname.DefaultDomain
+	}
+	return o
+}
+
+// ManagerOptions Configure the Manager the sources run in
+type ManagerOptions struct {
+	Scope NamespaceScope
+	// Hostnames How the sources map the hostnames of objects into the broadcast domain
+	Hostnames HostnameOptions
+	// Lease The namespace/name of the Lease competed for, no leader election when empty
+	Lease string
+	// HealthListen and MetricsListen The addresses of the probe and metrics endpoints, disabled when empty
+	HealthListen  string
+	MetricsListen string
+	// Cluster Tells apart the owners of the hostnames of several clusters, added to them when set
+	Cluster string
+	// Selectors Narrow down the watched objects of their types, e.g. the ingresses
+	Selectors []ObjectSelector
+}
+
+// Manager Runs a Reconciler per watched source type in a controller-runtime Manager, whose
+// cache holds the objects of the watched namespaces. Objects selected by name or labels
+// regardless of the watched namespaces, such as the ingress controller service, come from
+// small informers of their own. ready is closed once the cache and those informers listed
+// their objects
+type Manager struct {
+	manager   manager.Manager
+	clientset kubernetes.Interface
+	scope     NamespaceScope
+	cluster   string
+	hostnames HostnameOptions
+	// selected Factories narrowed down to a namespace and selectors, keyed by them
+	selected map[string]informers.SharedInformerFactory
+	synced   []cache.InformerSynced
+	ready    chan struct{}
+	// reconcilers The Reconcilers of the sources, for reporting their retries
+	reconcilers []*registrationReconciler
+	// controllers The controllers of WatchHostnames keyed by kind, which requeueOn adds watches to
+	controllers map[string]controller.Controller
+}
+
+// NewManager Creates the controller-runtime Manager, the sources are added with the Watch
+// functions before running it
+func NewManager(config *rest.Config, clientset kubernetes.Interface, options ManagerOptions) (*Manager, error) {
+	labelSelectors := map[schema.GroupVersionKind]string{}
+	for _, selector := range options.Selectors {
+		gvk, err := apiutil.GVKForObject(selector.Object, scheme.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		labelSelectors[gvk] = selector.Labels.String()
+	}
+	resync := informerResync
+	lease, renew, retry := leaseDuration, renewDeadline, retryPeriod
+	managerOpts := manager.Options{
+		Scheme:                        scheme.Scheme,
+		SyncPeriod:                    &resync,
+		NewCache:                      options.Scope.newCache(labelSelectors),
+		HealthProbeBindAddress:        options.HealthListen,
+		MetricsBindAddress:            options.MetricsListen,
+		LeaderElectionResourceLock:    resourcelock.LeasesResourceLock,
+		LeaderElectionReleaseOnCancel: true,
+		LeaseDuration:                 &lease,
+		RenewDeadline:                 &renew,
+		RetryPeriod:                   &retry,
+	}
+	if managerOpts.MetricsBindAddress == "" {
+		managerOpts.MetricsBindAddress = "0"
+	}
+	if options.Lease != "" {
+		parts := strings.SplitN(options.Lease, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("leader election lease %v is not of the form namespace/name", options.Lease)
+		}
+		managerOpts.LeaderElection = true
+		managerOpts.LeaderElectionNamespace, managerOpts.LeaderElectionID = parts[0], parts[1]
+	}
+	mgr, err := manager.New(config, managerOpts)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		manager:     mgr,
+		clientset:   clientset,
+		scope:       options.Scope,
+		cluster:     options.Cluster,
+		hostnames:   options.Hostnames.withDefaults(),
+		selected:    map[string]informers.SharedInformerFactory{},
+		ready:       make(chan struct{}),
+		controllers: map[string]controller.Controller{},
+	}
+	if err := mgr.Add(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WatchHostnames Keeps the hostnames of the objects of the type of object registered, through
+// a Reconciler named after kind. Unstructured objects need their kind set. getHostnames
+// returns no hostnames for objects that should not be broadcast and no addresses for those
+// that are still pending, which are retried with a backoff
+func (m *Manager) WatchHostnames(kind string, object client.Object, registry *publisher.Registry, getHostnames func(obj interface{}) ([]hostname.LocalHostname, []net.IP)) error {
+	// Create the informer up front, so that standbys list the objects too and readiness waits for it
+	if err := m.watchCached(kind, object); err != nil {
+		return fmt.Errorf("watching %v: %+v", kind, err)
+	}
+	reconciler := newRegistrationReconciler(kind, m.cluster, m.hostnames, registry, m.manager.GetClient(), object, m.ready, getHostnames)
+	ctrl, err := m.reconcileCached(strings.ReplaceAll(kind, " ", "-"), object, reconciler)
+	if err != nil {
+		return err
+	}
+	m.controllers[kind] = ctrl
+	return nil
+}
+
+// reconcileCached Builds the controller named name, which has reconciler reconcile the objects
+// of the type of object in the cache and reports its retries
+func (m *Manager) reconcileCached(name string, object client.Object, reconciler *registrationReconciler) (controller.Controller, error) {
+	m.reconcilers = append(m.reconcilers, reconciler)
+	return builder.ControllerManagedBy(m.manager).
+		Named(name).
+		For(object).
+		WithOptions(controller.Options{RateLimiter: newRetryRateLimiter()}).
+		Build(reconciler)
+}
+
+// watchSelectedHostnames Is WatchHostnames for the objects of the type of object in informer,
+// which comes from a selected factory, reporting its failed lists and watches as resource
+func (m *Manager) watchSelectedHostnames(kind string, resource string, informer cache.SharedIndexInformer, object client.Object, registry *publisher.Registry, getHostnames func(obj interface{}) ([]hostname.LocalHostname, []net.IP)) error {
+	m.track(resource, informer, nil)
+	reconciler := newRegistrationReconciler(kind, m.cluster, m.hostnames, registry, storeReader{store: informer.GetStore(), resource: resource}, object, m.ready, getHostnames)
+	m.reconcilers = append(m.reconcilers, reconciler)
+	ctrl, err := controller.New(strings.ReplaceAll(kind, " ", "-"), m.manager, controller.Options{Reconciler: reconciler, RateLimiter: newRetryRateLimiter()})
+	if err != nil {
+		return err
+	}
+	m.controllers[kind] = ctrl
+	return ctrl.Watch(&source.Informer{Informer: informer}, &handler.EnqueueRequestForObject{})
+}
+
+// requeueOn Watches the objects of the type of object in the cache, reporting failed lists
+// and watches as resource, and has the objects of kind that requests returns for a changed
+// one reconciled again. The hostnames of kind are watched already
+func (m *Manager) requeueOn(kind string, resource string, object client.Object, requests handler.MapFunc) error {
+	ctrl, exists := m.controllers[kind]
+	if !exists {
+		return fmt.Errorf("%v are not watched", kind)
+	}
+	if err := m.watchCached(resource, object); err != nil {
+		return err
+	}
+	return ctrl.Watch(&source.Kind{Type: object}, handler.EnqueueRequestsFromMapFunc(requests))
+}
+
+// Retries Returns why the objects requeued with a backoff are retried, keyed by owner such
+// as "ingress default/grafana"
+func (m *Manager) Retries() map[string]string {
+	retries := map[string]string{}
+	for _, reconciler := range m.reconcilers {
+		for owner, reason := range reconciler.retries() {
+			retries[owner] = reason
+		}
+	}
+	return retries
+}
+
+// owner Returns the owner of the hostnames of the object of kind at key, e.g. "ingress default/grafana"
+func (m *Manager) owner(kind string, key string) string {
+	return ownerOf(kind, key, m.cluster)
+}
+
+// ownerOf Returns the owner "kind key", followed by the cluster in parentheses when there is
+// one, such that the same object in two clusters are different owners whose hostnames collide
+func ownerOf(kind string, key string, cluster string) string {
+	if cluster == "" {
+		return kind + " " + key
+	}
+	return kind + " " + key + " (" + cluster + ")"
+}
+
+// newRetryRateLimiter Returns the backoff of objects whose hostnames have no address yet or
+// failed to publish
+func newRetryRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewItemExponentialFailureRateLimiter(reconcileRetryInitialDelay, reconcileRetryMaxDelay)
+}
+
+// selectedFactory Returns the factory of objects in namespace matching the label and field
+// selectors, regardless of the watched namespaces. An empty namespace selects all of them
+func (m *Manager) selectedFactory(namespace string, labelSelector string, fieldSelector string) informers.SharedInformerFactory {
+	key := namespace + " " + labelSelector + " " + fieldSelector
+	if factory, exists := m.selected[key]; exists {
+		return factory
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(m.clientset, informerResync, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labelSelector
+			options.FieldSelector = fieldSelector
+		}))
+	m.selected[key] = factory
+	return factory
+}
+
+// watchCached Creates the informer of the cache for object, which readiness waits for, and
+// reports its failed lists and watches as resource
+func (m *Manager) watchCached(resource string, object client.Object) error {
+	informer, err := m.manager.GetCache().GetInformer(context.TODO(), object)
+	if err != nil {
+		return err
+	}
+	handleWatchErrors(resource, informer)
+	m.synced = append(m.synced, informer.HasSynced)
+	return nil
+}
+
+// track Adds handler to informer of a selected factory, when set, and waits for it to sync.
+// Its failed lists and watches are reported as resource
+func (m *Manager) track(resource string, informer cache.SharedIndexInformer, handler cache.ResourceEventHandler) cache.SharedIndexInformer {
+	if handler != nil {
+		informer.AddEventHandler(handler)
+	}
+	handleWatchErrors(resource, informer)
+	m.synced = append(m.synced, informer.HasSynced)
+	return informer
+}
+
+// storeReader Reads the objects of resource from the store of an informer, it cannot list them
+type storeReader struct {
+	store    cache.Store
+	resource string
+}
+
+func (r storeReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	storeKey := key.Name
+	if key.Namespace != "" {
+		storeKey = key.Namespace + "/" + key.Name
+	}
+	item, exists, err := r.store.GetByKey(storeKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apierrors.NewNotFound(schema.GroupResource{Resource: r.resource}, key.Name)
+	}
+	stored, ok := item.(runtime.Object)
+	if !ok {
+		return fmt.Errorf("%v %v is a %T", r.resource, storeKey, item)
+	}
+	copied := reflect.ValueOf(stored.DeepCopyObject())
+	if copied.Type() != reflect.TypeOf(obj) {
+		return fmt.Errorf("%v %v is a %T, not a %T", r.resource, storeKey, stored, obj)
+	}
+	reflect.ValueOf(obj).Elem().Set(copied.Elem())
+	return nil
+}
+
+func (r storeReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return fmt.Errorf("listing %v is not supported", r.resource)
+}
+
+// Start Runs the informers of the selected factories until ctx is done, the Manager calls it
+func (m *Manager) Start(ctx context.Context) error {
+	for _, factory := range m.selected {
+		factory.Start(ctx.Done())
+	}
+	if cache.WaitForCacheSync(ctx.Done(), m.synced...) {
+		log.Infof("Informers synced, registering hostnames")
+		close(m.ready)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// NeedLeaderElection Keeps the selected informers running on standbys, which become ready too
+func (m *Manager) NeedLeaderElection() bool {
+	return false
+}
+
+// Ready Is closed once the cache and the selected informers listed their objects
+func (m *Manager) Ready() <-chan struct{} {
+	return m.ready
+}
+
+// Run Runs the Manager until ctx is done, errors when it could not start or lost the leadership
+func (m *Manager) Run(ctx context.Context) error {
+	return m.manager.Start(ctx)
+}
+
+// Elected Is closed once this replica may publish, right away without leader election
+func (m *Manager) Elected() <-chan struct{} {
+	return m.manager.Elected()
+}
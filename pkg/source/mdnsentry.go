@@ -0,0 +1,50 @@
+package source
+
+import (
+	"net"
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// mdnsEntryKind The MDNSEntry custom resource for manually managed records
+var mdnsEntryKind = schema.GroupVersionKind{Group: "zeroconf.ingress", Version: "v1alpha1", Kind: "MDNSEntry"}
+
+// WatchMDNSEntryHostnames Registers the hostnames of MDNSEntry resources
+func WatchMDNSEntryHostnames(sources *Manager, registry *publisher.Registry) error {
+	entry := &unstructured.Unstructured{}
+	entry.SetGroupVersionKind(mdnsEntryKind)
+	return sources.WatchHostnames("mdnsentry", entry, registry, func(obj interface{}) ([]hostname.LocalHostname, []net.IP) {
+		return getMDNSEntryHostnames(sources.hostnames, obj.(*unstructured.Unstructured))
+	})
+}
+
+// getMDNSEntryHostnames Maps the spec of an MDNSEntry onto a hostname,
+// there are no addresses when the entry is invalid
+func getMDNSEntryHostnames(options HostnameOptions, entry *unstructured.Unstructured) ([]hostname.LocalHostname, []net.IP) {
+	host, _, _ := unstructured.NestedString(entry.Object, "spec", "hostname")
+	name, ok := options.TrimDomain(host)
+	if !ok {
+		log.Warnf("Ignoring mdnsentry %v/%v, hostname %v is not in the %v domain", entry.GetNamespace(), entry.GetName(), host, options.Domain)
+		return nil, nil
+	}
+	rawIP, _, _ := unstructured.NestedString(entry.Object, "spec", "ip")
+	ip := net.ParseIP(rawIP)
+	if ip == nil {
+		log.Warnf("Ignoring mdnsentry %v/%v, %v is not a valid IP", entry.GetNamespace(), entry.GetName(), rawIP)
+		return nil, nil
+	}
+	port, _, _ := unstructured.NestedInt64(entry.Object, "spec", "port")
+	serviceType, _, _ := unstructured.NestedString(entry.Object, "spec", "serviceType")
+	text, _, _ := unstructured.NestedStringSlice(entry.Object, "spec", "txt")
+	return []hostname.LocalHostname{{
+		Hostname:    name,
+		Port:        int(port),
+		ServiceType: strings.TrimSuffix(serviceType, "."),
+		Text:        text,
+	}}, []net.IP{ip}
+}
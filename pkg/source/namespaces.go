@@ -0,0 +1,192 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	ctrlcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// multiNamespaceCache Watches each of a list of namespaces with a cache of its own, like the
+// cache of ctrlcache.MultiNamespacedCacheBuilder. Unlike its informers, those of this cache take
+// a watch error handler, which is set on the informer of every namespace
+type multiNamespaceCache struct {
+	caches map[string]ctrlcache.Cache
+}
+
+// newMultiNamespaceCache Returns the constructor of a cache watching namespaces
+func newMultiNamespaceCache(namespaces []string) ctrlcache.NewCacheFunc {
+	return func(config *rest.Config, opts ctrlcache.Options) (ctrlcache.Cache, error) {
+		caches := map[string]ctrlcache.Cache{}
+		for _, namespace := range namespaces {
+			opts.Namespace = namespace
+			namespaced, err := ctrlcache.New(config, opts)
+			if err != nil {
+				return nil, err
+			}
+			caches[namespace] = namespaced
+		}
+		return &multiNamespaceCache{caches: caches}, nil
+	}
+}
+
+// GetInformer Returns the informers of obj of every namespace as one
+func (c *multiNamespaceCache) GetInformer(ctx context.Context, obj client.Object) (ctrlcache.Informer, error) {
+	informers := multiNamespaceInformer{}
+	for namespace, namespaced := range c.caches {
+		informer, err := namespaced.GetInformer(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		informers[namespace] = informer
+	}
+	return informers, nil
+}
+
+// GetInformerForKind Returns the informers of gvk of every namespace as one
+func (c *multiNamespaceCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (ctrlcache.Informer, error) {
+	informers := multiNamespaceInformer{}
+	for namespace, namespaced := range c.caches {
+		informer, err := namespaced.GetInformerForKind(ctx, gvk)
+		if err != nil {
+			return nil, err
+		}
+		informers[namespace] = informer
+	}
+	return informers, nil
+}
+
+// Start Runs the caches of the namespaces until ctx is done
+func (c *multiNamespaceCache) Start(ctx context.Context) error {
+	for namespace, namespaced := range c.caches {
+		go func(namespace string, namespaced ctrlcache.Cache) {
+			if err := namespaced.Start(ctx); err != nil {
+				log.Errorf("Failed to start the cache of namespace %v: %+v", namespace, err)
+			}
+		}(namespace, namespaced)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// WaitForCacheSync Waits for the caches of all namespaces to sync
+func (c *multiNamespaceCache) WaitForCacheSync(ctx context.Context) bool {
+	synced := true
+	for _, namespaced := range c.caches {
+		if !namespaced.WaitForCacheSync(ctx) {
+			synced = false
+		}
+	}
+	return synced
+}
+
+// IndexField Adds the index to the cache of every namespace
+func (c *multiNamespaceCache) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	for _, namespaced := range c.caches {
+		if err := namespaced.IndexField(ctx, obj, field, extractValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get Reads the object at key from the cache of its namespace
+func (c *multiNamespaceCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	namespaced, exists := c.caches[key.Namespace]
+	if !exists {
+		return fmt.Errorf("Namespace %v of %v is not watched", key.Namespace, key)
+	}
+	return namespaced.Get(ctx, key, obj)
+}
+
+// List Lists the objects of the namespace of opts, or of all watched namespaces without one
+func (c *multiNamespaceCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if listOpts.Namespace != v1.NamespaceAll {
+		namespaced, exists := c.caches[listOpts.Namespace]
+		if !exists {
+			return fmt.Errorf("Namespace %v is not watched", listOpts.Namespace)
+		}
+		return namespaced.List(ctx, list, opts...)
+	}
+	listAccessor, err := apimeta.ListAccessor(list)
+	if err != nil {
+		return err
+	}
+	items := []runtime.Object{}
+	var resourceVersion string
+	for _, namespaced := range c.caches {
+		namespacedList := list.DeepCopyObject().(client.ObjectList)
+		if err := namespaced.List(ctx, namespacedList, opts...); err != nil {
+			return err
+		}
+		namespacedItems, err := apimeta.ExtractList(namespacedList)
+		if err != nil {
+			return err
+		}
+		items = append(items, namespacedItems...)
+		if accessor, err := apimeta.ListAccessor(namespacedList); err == nil {
+			// The list of the last namespace has the most recent resource version
+			resourceVersion = accessor.GetResourceVersion()
+		}
+	}
+	listAccessor.SetResourceVersion(resourceVersion)
+	return apimeta.SetList(list, items)
+}
+
+// multiNamespaceInformer The informers of one type of object, by namespace
+type multiNamespaceInformer map[string]ctrlcache.Informer
+
+func (i multiNamespaceInformer) AddEventHandler(handler cache.ResourceEventHandler) {
+	for _, informer := range i {
+		informer.AddEventHandler(handler)
+	}
+}
+
+func (i multiNamespaceInformer) AddEventHandlerWithResyncPeriod(handler cache.ResourceEventHandler, resyncPeriod time.Duration) {
+	for _, informer := range i {
+		informer.AddEventHandlerWithResyncPeriod(handler, resyncPeriod)
+	}
+}
+
+func (i multiNamespaceInformer) AddIndexers(indexers cache.Indexers) error {
+	for _, informer := range i {
+		if err := informer.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i multiNamespaceInformer) HasSynced() bool {
+	for _, informer := range i {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// SetWatchErrorHandler Sets handler on the informer of every namespace, which must not have started
+func (i multiNamespaceInformer) SetWatchErrorHandler(handler cache.WatchErrorHandler) error {
+	for namespace, informer := range i {
+		setter, ok := informer.(watchErrorSetter)
+		if !ok {
+			return fmt.Errorf("the informer of namespace %v takes no watch error handler", namespace)
+		}
+		if err := setter.SetWatchErrorHandler(handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
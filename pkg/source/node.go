@@ -0,0 +1,59 @@
+package source
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// serviceTypeSSH The DNS-SD service type nodes are published under unless annotated otherwise
+const serviceTypeSSH = "_ssh._tcp"
+
+// WatchNodeHostnames Registers <node>.local with the InternalIPs of every node matching the
+// label selector, as an _ssh._tcp instance on port 22 unless the service-type and port
+// annotations of the node say otherwise
+func WatchNodeHostnames(sources *Manager, selector string, registry *publisher.Registry) error {
+	informer := sources.selectedFactory("", selector, "").Core().V1().Nodes().Informer()
+	return sources.watchSelectedHostnames("node", "nodes", informer, &v1.Node{}, registry, func(obj interface{}) ([]hostname.LocalHostname, []net.IP) {
+		return getNodeHostnames(sources.hostnames, obj.(*v1.Node))
+	})
+}
+
+// getNodeHostnames Returns the hostname of node, the first label of its name, with its
+// InternalIPs. None when it has no InternalIP
+func getNodeHostnames(options HostnameOptions, node *v1.Node) ([]hostname.LocalHostname, []net.IP) {
+	ips := []net.IP{}
+	for _, address := range node.Status.Addresses {
+		if address.Type != v1.NodeInternalIP {
+			continue
+		}
+		if ip := net.ParseIP(address.Address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if ips = options.SelectAddresses(hostname.SortIPs(ips)); len(ips) == 0 {
+		log.Debugf("Node %v has no InternalIP", node.Name)
+		return nil, nil
+	}
+	local := hostname.LocalHostname{Hostname: strings.SplitN(node.Name, ".", 2)[0], ServiceType: serviceTypeSSH, Port: 22, Text: []string{}}
+	if annotated, exists := node.Annotations[annotationServiceType]; exists {
+		if serviceType := strings.TrimSuffix(annotated, "."); hostname.ServiceTypePattern.MatchString(serviceType) {
+			local.ServiceType = serviceType
+		} else {
+			log.Warnf("Node %v has an invalid %v annotation %v, using %v", node.Name, annotationServiceType, annotated, serviceTypeSSH)
+		}
+	}
+	if annotated, exists := node.Annotations[annotationPort]; exists {
+		if port, err := strconv.Atoi(annotated); err == nil && port > 0 && port <= 65535 {
+			local.Port = port
+		} else {
+			log.Warnf("Node %v has an invalid %v annotation %v, using port 22", node.Name, annotationPort, annotated)
+		}
+	}
+	return []hostname.LocalHostname{local}, ips
+}
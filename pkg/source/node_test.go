@@ -0,0 +1,85 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// testPublisher Fails to publish while err is set, and counts the published instances
+type testPublisher struct {
+	err       error
+	published map[string]bool
+}
+
+func (p *testPublisher) Publish(instance publisher.ServiceInstance) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published[instance.Hostname] = true
+	return nil
+}
+
+func (p *testPublisher) Unpublish(instance publisher.ServiceInstance) {
+	delete(p.published, instance.Hostname)
+}
+
+func (p *testPublisher) SetInterfaces(ifaces []net.Interface) error {
+	return nil
+}
+
+func (p *testPublisher) Close() {
+}
+
+func TestReconcileNodeRetried(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1.cluster.internal"}}
+	node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeHostName, Address: "worker-1"}, {Type: v1.NodeInternalIP, Address: "10.0.0.1"}}
+	if err := store.Add(node); err != nil {
+		t.Fatalf("Failed to add the node to the store: %+v", err)
+	}
+	backend := &testPublisher{err: errors.New("publisher is down"), published: map[string]bool{}}
+	registry := publisher.NewRegistry(nil, backend)
+	ready := make(chan struct{})
+	close(ready)
+	hostnames := HostnameOptions{}.withDefaults()
+	reconciler := newRegistrationReconciler("node", "", hostnames, registry, storeReader{store: store, resource: "nodes"}, &v1.Node{}, ready, func(obj interface{}) ([]hostname.LocalHostname, []net.IP) {
+		return getNodeHostnames(hostnames, obj.(*v1.Node))
+	})
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+
+	if _, err := reconciler.Reconcile(context.TODO(), request); err == nil {
+		t.Fatalf("Reconcile() succeeded while publishing fails")
+	}
+	if _, retried := reconciler.retries()["node worker-1.cluster.internal"]; !retried {
+		t.Errorf("retries() = %v, want the node", reconciler.retries())
+	}
+
+	backend.err = nil
+	if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+		t.Fatalf("Reconcile() = %+v", err)
+	}
+	if !backend.published["worker-1"] {
+		t.Errorf("published %v, want worker-1", backend.published)
+	}
+	if retries := reconciler.retries(); len(retries) != 0 {
+		t.Errorf("retries() = %v, want none", retries)
+	}
+
+	store.Delete(node)
+	if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+		t.Fatalf("Reconcile() of the deleted node = %+v", err)
+	}
+	if len(backend.published) != 0 {
+		t.Errorf("published %v after deleting the node, want none", backend.published)
+	}
+}
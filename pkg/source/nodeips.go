@@ -0,0 +1,47 @@
+package source
+
+import (
+	"net"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeIPSource Tracks the node IPs of the ingress controller pods, advertised for
+// ingresses without a LoadBalancer status, e.g. ingress-nginx with hostNetwork on bare metal
+type NodeIPSource struct {
+	pods      corelisters.PodLister
+	hostnames HostnameOptions
+}
+
+// NewNodeIPSource Watches the pods matching selector in all namespaces
+func NewNodeIPSource(sources *Manager, selector string) *NodeIPSource {
+	pods := sources.selectedFactory("", selector, "").Core().V1().Pods()
+	sources.track("pods", pods.Informer(), cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			pod := obj.(*v1.Pod)
+			log.Debugf("Ingress controller pod %v/%v runs on %v", pod.Namespace, pod.Name, pod.Status.HostIP)
+		},
+	})
+	return &NodeIPSource{pods: pods.Lister(), hostnames: sources.hostnames}
+}
+
+// IPs Returns the distinct node IPs of the running ingress controller pods
+func (s *NodeIPSource) IPs() []net.IP {
+	ips := []net.IP{}
+	pods, _ := s.pods.List(labels.Everything())
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if ip := net.ParseIP(pod.Status.HostIP); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	// Sort for a stable order, the lister returns pods in random order
+	return s.hostnames.SelectAddresses(hostname.SortIPs(ips))
+}
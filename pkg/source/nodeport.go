@@ -0,0 +1,47 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// NodePortSource Tracks the NodePorts of the ingress controller service, advertised
+// instead of 80/443 when the controller is exposed via NodePort
+type NodePortSource struct {
+	namespace string
+	name      string
+	services  corelisters.ServiceLister
+}
+
+// NewNodePortSource Watches the service namespace/name of the ingress controller
+func NewNodePortSource(sources *Manager, service string) (*NodePortSource, error) {
+	parts := strings.SplitN(service, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("Ingress controller service %v is not of the form namespace/name", service)
+	}
+	services := sources.selectedFactory(parts[0], "", "metadata.name="+parts[1]).Core().V1().Services()
+	sources.track("services", services.Informer(), nil)
+	return &NodePortSource{namespace: parts[0], name: parts[1], services: services.Lister()}, nil
+}
+
+// Ports Returns the NodePorts of the http and https service ports, 0 when there is none.
+// Service ports are matched by their port 80/443, or by their name http/https
+func (s *NodePortSource) Ports() (http int, https int) {
+	service, err := s.services.Services(s.namespace).Get(s.name)
+	if err != nil {
+		return 0, 0
+	}
+	for _, port := range service.Spec.Ports {
+		if port.NodePort == 0 {
+			continue
+		}
+		if http == 0 && (port.Port == 80 || port.Name == "http") {
+			http = int(port.NodePort)
+		} else if https == 0 && (port.Port == 443 || port.Name == "https") {
+			https = int(port.NodePort)
+		}
+	}
+	return http, https
+}
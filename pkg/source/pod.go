@@ -0,0 +1,79 @@
+package source
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// WatchPodHostnames Registers the hostnames annotated on pods running on the host network
+func WatchPodHostnames(sources *Manager, registry *publisher.Registry) error {
+	return sources.WatchHostnames("pod", &v1.Pod{}, registry, func(obj interface{}) ([]hostname.LocalHostname, []net.IP) {
+		return getPodHostnames(sources.hostnames, obj.(*v1.Pod))
+	})
+}
+
+// getPodHostnames Returns the comma separated hostnames of the hostname annotation of a pod on
+// the host network and its node IP, there is no address until the pod is ready
+func getPodHostnames(options HostnameOptions, pod *v1.Pod) ([]hostname.LocalHostname, []net.IP) {
+	annotated, exists := pod.Annotations[annotationHostname]
+	if !exists || !pod.Spec.HostNetwork {
+		return nil, nil
+	}
+	template := hostname.LocalHostname{}
+	if annotated, exists := pod.Annotations[annotationPort]; exists {
+		if port, err := strconv.Atoi(annotated); err == nil && port > 0 && port <= 65535 {
+			template.Port = port
+		} else {
+			log.Warnf("Pod %v/%v has an invalid %v annotation %v, using the default port", pod.Namespace, pod.Name, annotationPort, annotated)
+		}
+	}
+	if annotated, exists := pod.Annotations[annotationServiceType]; exists {
+		if serviceType := strings.TrimSuffix(annotated, "."); hostname.ServiceTypePattern.MatchString(serviceType) {
+			template.ServiceType = serviceType
+		} else {
+			log.Warnf("Pod %v/%v has an invalid %v annotation %v, using the default service type", pod.Namespace, pod.Name, annotationServiceType, annotated)
+		}
+	}
+	hostnames := []hostname.LocalHostname{}
+	for _, host := range strings.Split(annotated, ",") {
+		if host = strings.TrimSpace(host); host == "" {
+			continue
+		}
+		name, ok := options.TrimDomain(host)
+		if !ok {
+			log.Warnf("Ignoring hostname %v of pod %v/%v, it is not in the %v domain", host, pod.Namespace, pod.Name, options.Domain)
+			continue
+		}
+		local := template
+		local.Hostname = name
+		hostnames = append(hostnames, local)
+	}
+	if !isPodReady(pod) {
+		log.Debugf("Pod %v/%v is not ready yet", pod.Namespace, pod.Name)
+		return hostnames, nil
+	}
+	ip := net.ParseIP(pod.Status.HostIP)
+	if ip == nil {
+		return hostnames, nil
+	}
+	return hostnames, []net.IP{ip}
+}
+
+// isPodReady Reports whether pod is running, not terminating and ready
+func isPodReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
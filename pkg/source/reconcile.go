@@ -0,0 +1,203 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	reconcileRetryInitialDelay = time.Second
+	reconcileRetryMaxDelay     = time.Minute * 5
+)
+
+// errNoAddress Is returned for objects whose hostnames have no address yet, which are retried
+var errNoAddress = errors.New("no address to advertise yet")
+
+// registrationReconciler Reconciles the registrations of the watched objects of one type. It
+// registers the hostnames of the latest version of an object and has it requeued with an
+// exponential backoff while it has no address yet or publishing failed. Retries pick up
+// addresses that depend on other objects or DNS. Reconciling waits for all informers to sync,
+// so that lookups in the caches of other sources see every object from the first registration on
+type registrationReconciler struct {
+	mutex    sync.Mutex
+	kind     string
+	cluster  string
+	registry *publisher.Registry
+	client   client.Reader
+	// object The type of the reconciled objects, copied for every lookup
+	object client.Object
+	ready  <-chan struct{}
+	// getRegistrations Returns the hostnames of an object grouped by the addresses they are
+	// registered with, an error has the object retried
+	getRegistrations func(obj client.Object) ([]registration, error)
+	// registered The hostnames registered for each object, those it drops are unregistered
+	registered map[string][]hostname.LocalHostname
+	// retrying Why the objects requeued with a backoff are retried, keyed by object
+	retrying map[string]string
+}
+
+// registration Hostnames of an object that are registered with the same addresses
+type registration struct {
+	hostnames []hostname.LocalHostname
+	ips       []net.IP
+}
+
+// newRegistrationReconciler Returns the reconciler of objects whose hostnames all share the
+// addresses getHostnames returns. hostnames maps them into namespace subdomains, names their
+// instances and adds their aliases
+func newRegistrationReconciler(kind string, cluster string, hostnames HostnameOptions, registry *publisher.Registry, reader client.Reader, object client.Object, ready <-chan struct{}, getHostnames func(obj interface{}) ([]hostname.LocalHostname, []net.IP)) *registrationReconciler {
+	return newRegistrationsReconciler(kind, cluster, registry, reader, object, ready, func(obj client.Object) ([]registration, error) {
+		locals, ips := getHostnames(obj)
+		locals = hostnames.inNamespaceSubdomain(obj.GetNamespace(), locals)
+		locals = hostnames.withInstanceNames(kind, obj, locals)
+		locals = hostnames.withAliases(kind, obj, locals)
+		return []registration{{hostnames: locals, ips: ips}}, nil
+	})
+}
+
+// newRegistrationsReconciler Returns the reconciler of objects whose hostnames may have
+// addresses of their own, getRegistrations returns them as they are registered
+func newRegistrationsReconciler(kind string, cluster string, registry *publisher.Registry, reader client.Reader, object client.Object, ready <-chan struct{}, getRegistrations func(obj client.Object) ([]registration, error)) *registrationReconciler {
+	return &registrationReconciler{
+		kind:             kind,
+		cluster:          cluster,
+		registry:         registry,
+		client:           reader,
+		object:           object,
+		ready:            ready,
+		getRegistrations: getRegistrations,
+		registered:       map[string][]hostname.LocalHostname{},
+		retrying:         map[string]string{},
+	}
+}
+
+// Reconcile Registers the hostnames of the object of request, requeueing it while it has no address
+func (r *registrationReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	select {
+	case <-r.ready:
+	case <-ctx.Done():
+		return reconcile.Result{}, ctx.Err()
+	}
+	key := request.Name
+	if request.Namespace != "" {
+		key = request.NamespacedName.String()
+	}
+	obj := r.object.DeepCopyObject().(client.Object)
+	if err := r.client.Get(ctx, request.NamespacedName, obj); apierrors.IsNotFound(err) {
+		obj = nil
+	} else if err != nil {
+		return reconcile.Result{}, err
+	}
+	err := r.reconcile(key, obj)
+	r.setRetrying(key, err)
+	switch {
+	case err == nil:
+		return reconcile.Result{}, nil
+	case err == errNoAddress:
+		log.Debugf("%v %v has no address yet, retrying", r.kind, key)
+		return reconcile.Result{Requeue: true}, nil
+	default:
+		log.WithFields(publisher.OwnerFields(ownerOf(r.kind, key, r.cluster))).Errorf("Failed to register %v %v, retrying: %+v", r.kind, key, err)
+		return reconcile.Result{}, err
+	}
+}
+
+// setRetrying Records why key is retried, err is nil once it does not need a retry
+func (r *registrationReconciler) setRetrying(key string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err == nil {
+		delete(r.retrying, key)
+	} else {
+		r.retrying[key] = err.Error()
+	}
+}
+
+// retries Returns why the objects requeued with a backoff are retried, keyed by owner
+func (r *registrationReconciler) retries() map[string]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	retries := map[string]string{}
+	for key, reason := range r.retrying {
+		retries[ownerOf(r.kind, key, r.cluster)] = reason
+	}
+	return retries
+}
+
+// reconcile Brings the registrations of key in line with obj, nil once it was deleted
+func (r *registrationReconciler) reconcile(key string, obj client.Object) error {
+	r.mutex.Lock()
+	previous := r.registered[key]
+	r.mutex.Unlock()
+	owner := ownerOf(r.kind, key, r.cluster)
+	if obj == nil {
+		// Unregistering is a no-op for hostnames that never got an address
+		r.registry.Unregister(owner, previous)
+		r.setRegistered(key, nil)
+		return nil
+	}
+	registrations, err := r.getRegistrations(obj)
+	if err != nil {
+		return err
+	}
+	addressed, hostnames, pending := false, []hostname.LocalHostname{}, []hostname.LocalHostname{}
+	for _, registration := range registrations {
+		if len(registration.ips) == 0 {
+			pending = append(pending, registration.hostnames...)
+		} else {
+			addressed = true
+			hostnames = append(hostnames, registration.hostnames...)
+		}
+	}
+	if removed := removedHostnames(previous, hostnames); len(removed) > 0 {
+		if addressed {
+			// Hostnames that are still there are updated in place by registering them,
+			// which keeps them owned by this object
+			log.Infof("%v %v changed, re-registering hostnames", r.kind, key)
+		} else {
+			log.Infof("%v %v lost its address, unregistering hostnames", r.kind, key)
+		}
+		r.registry.Unregister(owner, removed)
+	}
+	if len(hostnames) > 0 {
+		r.setRegistered(key, hostnames)
+	} else {
+		r.setRegistered(key, nil)
+	}
+	for _, registration := range registrations {
+		if len(registration.ips) == 0 {
+			continue
+		}
+		// On resyncs this replaces registrations whose resolved addresses changed
+		// since they were registered and is a no-op otherwise. Register reports every
+		// unpublished hostname of the owner, so the last error covers all registrations
+		err = r.registry.Register(owner, objectReference(obj), registration.hostnames, registration.ips)
+	}
+	if len(pending) > 0 {
+		_ = r.registry.Register(owner, objectReference(obj), pending, nil)
+		if err == nil {
+			return errNoAddress
+		}
+	}
+	return err
+}
+
+func (r *registrationReconciler) setRegistered(key string, hostnames []hostname.LocalHostname) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if hostnames == nil {
+		delete(r.registered, key)
+	} else {
+		r.registered[key] = hostnames
+	}
+}
@@ -0,0 +1,69 @@
+package source
+
+import (
+	"net"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// openshiftRouteKind The OpenShift/OKD Route kind
+var openshiftRouteKind = schema.GroupVersionKind{Group: "route.openshift.io", Version: "v1", Kind: "Route"}
+
+// WatchOpenshiftRouteHostnames Registers the hostnames of OpenShift Routes
+func WatchOpenshiftRouteHostnames(sources *Manager, registry *publisher.Registry) error {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(openshiftRouteKind)
+	return sources.WatchHostnames("route", route, registry, func(obj interface{}) ([]hostname.LocalHostname, []net.IP) {
+		return getOpenshiftRouteHostnames(sources.hostnames, obj.(*unstructured.Unstructured))
+	})
+}
+
+// getOpenshiftRouteHostnames Returns the .local hostname of a Route and the addresses
+// of the router that admitted it, there are none when there is nothing to broadcast
+func getOpenshiftRouteHostnames(options HostnameOptions, route *unstructured.Unstructured) ([]hostname.LocalHostname, []net.IP) {
+	host, _, _ := unstructured.NestedString(route.Object, "spec", "host")
+	name, ok := options.TrimDomain(host)
+	if !ok {
+		return nil, nil
+	}
+	termination, _, _ := unstructured.NestedString(route.Object, "spec", "tls", "termination")
+	local := hostname.LocalHostname{TLS: termination != "", Hostname: name}
+
+	ingresses, _, _ := unstructured.NestedSlice(route.Object, "status", "ingress")
+	for _, ingress := range ingresses {
+		fields, ok := ingress.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		canonical, _, _ := unstructured.NestedString(fields, "routerCanonicalHostname")
+		if canonical == "" {
+			continue
+		}
+		if ips := resolveRouterHostname(options, canonical); len(ips) > 0 {
+			return []hostname.LocalHostname{local}, ips
+		}
+	}
+	log.Debugf("Route %v/%v has not been admitted by a router yet", route.GetNamespace(), route.GetName())
+	return nil, nil
+}
+
+// resolveRouterHostname Returns the addresses of a router canonical hostname, which may already be an IP
+func resolveRouterHostname(options HostnameOptions, canonical string) []net.IP {
+	if ip := net.ParseIP(canonical); ip != nil {
+		return options.SelectAddresses([]net.IP{ip})
+	}
+	ips, err := hostname.Resolve(canonical)
+	if err != nil {
+		log.Warnf("Failed to resolve router canonical hostname %v: %+v", canonical, err)
+		return nil
+	}
+	selected := options.SelectAddresses(ips)
+	if len(selected) == 0 {
+		log.Warnf("Router canonical hostname %v has no %v address", canonical, options.IPFamily)
+	}
+	return selected
+}
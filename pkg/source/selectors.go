@@ -0,0 +1,221 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	ctrlcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// ObjectSelector Narrows down the cached objects of the type of Object to those matching Labels
+type ObjectSelector struct {
+	Object client.Object
+	Labels labels.Selector
+}
+
+// selectingCache Caches the objects of the types with a label selector, and all namespaced
+// objects when there is a field selector, in caches of their own whose lists and watches pass
+// the selectors to the API server. The cache of controller-runtime cannot narrow them down
+// itself. The other objects are cached by the embedded cache
+type selectingCache struct {
+	ctrlcache.Cache
+	config *rest.Config
+	opts   ctrlcache.Options
+	// newScoped Creates the caches of the watched namespaces
+	newScoped ctrlcache.NewCacheFunc
+	// labelSelectors The label selectors of the selected types
+	labelSelectors map[schema.GroupVersionKind]string
+	// fieldSelector Leaves out the objects of the excluded namespaces, empty when there are none
+	fieldSelector string
+	mutex         sync.Mutex
+	selected      map[schema.GroupVersionKind]ctrlcache.Cache
+	// ctx The context of Start, which the caches created after it are started with
+	ctx context.Context
+}
+
+// newSelectingCache Returns the constructor of a selectingCache creating its caches with newScoped
+func newSelectingCache(newScoped ctrlcache.NewCacheFunc, labelSelectors map[schema.GroupVersionKind]string, fieldSelector string) ctrlcache.NewCacheFunc {
+	return func(config *rest.Config, opts ctrlcache.Options) (ctrlcache.Cache, error) {
+		if opts.Mapper == nil {
+			mapper, err := apiutil.NewDynamicRESTMapper(config)
+			if err != nil {
+				return nil, err
+			}
+			opts.Mapper = mapper
+		}
+		unselected, err := newScoped(config, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &selectingCache{
+			Cache:          unselected,
+			config:         config,
+			opts:           opts,
+			newScoped:      newScoped,
+			labelSelectors: labelSelectors,
+			fieldSelector:  fieldSelector,
+			selected:       map[schema.GroupVersionKind]ctrlcache.Cache{},
+		}, nil
+	}
+}
+
+// cacheFor Returns the cache of the objects of gvk, creating it when they are selected
+func (c *selectingCache) cacheFor(gvk schema.GroupVersionKind) (ctrlcache.Cache, error) {
+	labelSelector, fieldSelector := c.labelSelectors[gvk], ""
+	if c.fieldSelector != "" {
+		mapping, err := c.opts.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, err
+		}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			fieldSelector = c.fieldSelector
+		}
+	}
+	if labelSelector == "" && fieldSelector == "" {
+		return c.Cache, nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if selected, exists := c.selected[gvk]; exists {
+		return selected, nil
+	}
+	selected, err := c.newScoped(withSelectors(c.config, labelSelector, fieldSelector), c.opts)
+	if err != nil {
+		return nil, err
+	}
+	c.selected[gvk] = selected
+	if c.ctx != nil {
+		go c.start(gvk, selected)
+	}
+	return selected, nil
+}
+
+// cacheOf Returns the cache of the objects of the type of obj, that of its items for a list
+func (c *selectingCache) cacheOf(obj runtime.Object, isList bool) (ctrlcache.Cache, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.opts.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	if isList {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	return c.cacheFor(gvk)
+}
+
+func (c *selectingCache) start(gvk schema.GroupVersionKind, selected ctrlcache.Cache) {
+	if err := selected.Start(c.ctx); err != nil {
+		log.Errorf("Failed to start the cache of %v: %+v", gvk, err)
+	}
+}
+
+// Start Runs the caches until ctx is done
+func (c *selectingCache) Start(ctx context.Context) error {
+	c.mutex.Lock()
+	c.ctx = ctx
+	for gvk, selected := range c.selected {
+		go c.start(gvk, selected)
+	}
+	c.mutex.Unlock()
+	return c.Cache.Start(ctx)
+}
+
+// WaitForCacheSync Waits for all caches to sync
+func (c *selectingCache) WaitForCacheSync(ctx context.Context) bool {
+	c.mutex.Lock()
+	caches := []ctrlcache.Cache{c.Cache}
+	for _, selected := range c.selected {
+		caches = append(caches, selected)
+	}
+	c.mutex.Unlock()
+	synced := true
+	for _, cache := range caches {
+		if !cache.WaitForCacheSync(ctx) {
+			synced = false
+		}
+	}
+	return synced
+}
+
+func (c *selectingCache) GetInformer(ctx context.Context, obj client.Object) (ctrlcache.Informer, error) {
+	cache, err := c.cacheOf(obj, false)
+	if err != nil {
+		return nil, err
+	}
+	return cache.GetInformer(ctx, obj)
+}
+
+func (c *selectingCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (ctrlcache.Informer, error) {
+	cache, err := c.cacheFor(gvk)
+	if err != nil {
+		return nil, err
+	}
+	return cache.GetInformerForKind(ctx, gvk)
+}
+
+func (c *selectingCache) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	cache, err := c.cacheOf(obj, false)
+	if err != nil {
+		return err
+	}
+	return cache.IndexField(ctx, obj, field, extractValue)
+}
+
+func (c *selectingCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	cache, err := c.cacheOf(obj, false)
+	if err != nil {
+		return err
+	}
+	return cache.Get(ctx, key, obj)
+}
+
+func (c *selectingCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	cache, err := c.cacheOf(list, true)
+	if err != nil {
+		return err
+	}
+	return cache.List(ctx, list, opts...)
+}
+
+// withSelectors Returns a copy of config whose GET requests, the lists and watches of a cache,
+// ask the API server for the objects matching the label and field selectors only
+func withSelectors(config *rest.Config, labelSelector string, fieldSelector string) *rest.Config {
+	selecting := rest.CopyConfig(config)
+	selecting.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return &selectingTransport{next: next, labelSelector: labelSelector, fieldSelector: fieldSelector}
+	})
+	return selecting
+}
+
+// selectingTransport Adds label and field selectors to GET requests
+type selectingTransport struct {
+	next          http.RoundTripper
+	labelSelector string
+	fieldSelector string
+}
+
+func (t *selectingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Method != http.MethodGet {
+		return t.next.RoundTrip(request)
+	}
+	// A RoundTripper must not modify the request it was given
+	request = request.Clone(request.Context())
+	query := request.URL.Query()
+	if t.labelSelector != "" {
+		query.Set("labelSelector", t.labelSelector)
+	}
+	if t.fieldSelector != "" {
+		query.Set("fieldSelector", t.fieldSelector)
+	}
+	request.URL.RawQuery = query.Encode()
+	return t.next.RoundTrip(request)
+}
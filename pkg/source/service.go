@@ -0,0 +1,80 @@
+package source
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// WatchServiceHostnames Registers the hostnames of annotated services
+func WatchServiceHostnames(sources *Manager, registry *publisher.Registry) error {
+	return sources.WatchHostnames("service", &v1.Service{}, registry, func(obj interface{}) ([]hostname.LocalHostname, []net.IP) {
+		service := obj.(*v1.Service)
+		hostnames, ips := getServiceHostnames(sources.hostnames, service)
+		if len(hostnames) > 0 && len(ips) == 0 {
+			// Only LoadBalancers get an address later, the status update reconciles them again
+			registry.Skip(sources.owner("service", service.Namespace+"/"+service.Name), objectReference(service), "Service %v/%v is not published, a %v service has no address to advertise without externalIPs", service.Namespace, service.Name, service.Spec.Type)
+			return nil, nil
+		}
+		return hostnames, ips
+	})
+}
+
+// getServiceHostnames Returns the hostname of a LoadBalancer service annotated to be published,
+// or of a service of any type with a hostname annotation, and its externally reachable addresses.
+// There are no hostnames when the service is not to be broadcast or is a LoadBalancer without an
+// address yet, and no addresses for the hostnames of other services without externalIPs
+func getServiceHostnames(options HostnameOptions, service *v1.Service) ([]hostname.LocalHostname, []net.IP) {
+	annotated, named := service.Annotations[annotationHostname]
+	published := service.Spec.Type == v1.ServiceTypeLoadBalancer && service.Annotations[annotationPublish] == "true"
+	if (!named && !published) || isPerPodService(service) {
+		// The pods of headless services are published by the HeadlessSource
+		return nil, nil
+	}
+	name := service.Name
+	if named {
+		var ok bool
+		if name, ok = options.TrimDomain(annotated); !ok {
+			log.Warnf("Ignoring service %v/%v, hostname %v is not in the %v domain", service.Namespace, service.Name, annotated, options.Domain)
+			return nil, nil
+		}
+	}
+	if len(service.Spec.Ports) == 0 {
+		log.Warnf("Ignoring service %v/%v, it exposes no ports", service.Namespace, service.Name)
+		return nil, nil
+	}
+	port := int(service.Spec.Ports[0].Port)
+	if annotated, exists := service.Annotations[annotationPort]; exists {
+		if annotatedPort, err := strconv.Atoi(annotated); err == nil && annotatedPort > 0 && annotatedPort <= 65535 {
+			port = annotatedPort
+		} else {
+			log.Warnf("Service %v/%v has an invalid %v annotation %v, using its first port", service.Namespace, service.Name, annotationPort, annotated)
+		}
+	}
+	if ips := getServiceIPs(options, service); len(ips) > 0 {
+		return []hostname.LocalHostname{{Hostname: name, Port: port}}, ips
+	}
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return []hostname.LocalHostname{{Hostname: name, Port: port}}, nil
+	}
+	log.Debugf("Service %v/%v has no LoadBalancer IP or external IP yet", service.Namespace, service.Name)
+	return nil, nil
+}
+
+// getServiceIPs Returns the LoadBalancer addresses of service, or else its external IPs
+func getServiceIPs(options HostnameOptions, service *v1.Service) []net.IP {
+	if ips := getLoadBalancerIPs(options, service.Status.LoadBalancer.Ingress); len(ips) > 0 {
+		return ips
+	}
+	ips := []net.IP{}
+	for _, externalIP := range service.Spec.ExternalIPs {
+		if ip := net.ParseIP(externalIP); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return options.SelectAddresses(ips)
+}
@@ -0,0 +1,98 @@
+package source
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetServiceHostnames(t *testing.T) {
+	loadBalancer := func(annotations map[string]string) *v1.Service {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "media", Name: "jellyfin", Annotations: annotations}}
+		service.Spec.Type = v1.ServiceTypeLoadBalancer
+		service.Spec.Ports = []v1.ServicePort{{Port: 8096}}
+		service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "fd00::1"}, {IP: "10.0.0.1"}}
+		return service
+	}
+	clusterIP := loadBalancer(map[string]string{annotationHostname: "jellyfin.local"})
+	clusterIP.Spec.Type = v1.ServiceTypeClusterIP
+	clusterIP.Status.LoadBalancer.Ingress = nil
+	clusterIP.Spec.ExternalIPs = []string{"10.0.0.2"}
+	ipv4 := HostnameOptions{Options: hostname.DefaultOptions()}
+	ipv4.IPFamily = hostname.IPFamilyIPv4
+	tests := []struct {
+		name    string
+		options HostnameOptions
+		service *v1.Service
+		want    []hostname.LocalHostname
+		wantIPs []string
+	}{
+		{"not published", HostnameOptions{}, loadBalancer(nil), nil, []string{}},
+		{"published", HostnameOptions{}, loadBalancer(map[string]string{annotationPublish: "true"}), []hostname.LocalHostname{{Hostname: "jellyfin", Port: 8096}}, []string{"10.0.0.1", "fd00::1"}},
+		{"ipv4 only", ipv4, loadBalancer(map[string]string{annotationPublish: "true"}), []hostname.LocalHostname{{Hostname: "jellyfin", Port: 8096}}, []string{"10.0.0.1"}},
+		{"hostname annotation", HostnameOptions{}, loadBalancer(map[string]string{annotationHostname: "media.local", annotationPort: "80"}), []hostname.LocalHostname{{Hostname: "media", Port: 80}}, []string{"10.0.0.1", "fd00::1"}},
+		{"hostname in another domain", HostnameOptions{}, loadBalancer(map[string]string{annotationHostname: "media.example.com"}), nil, []string{}},
+		{"hostname in the broadcast domain", HostnameOptions{Options: hostname.Options{Domain: "home.arpa"}}, loadBalancer(map[string]string{annotationHostname: "media.home.arpa"}), []hostname.LocalHostname{{Hostname: "media", Port: 8096}}, []string{"10.0.0.1", "fd00::1"}},
+		{"external IPs", HostnameOptions{}, clusterIP, []hostname.LocalHostname{{Hostname: "jellyfin", Port: 8096}}, []string{"10.0.0.2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hostnames, ips := getServiceHostnames(test.options.withDefaults(), test.service)
+			if !reflect.DeepEqual(hostnames, test.want) {
+				t.Errorf("getServiceHostnames() hostnames = %+v, want %+v", hostnames, test.want)
+			}
+			if got := hostname.IPStrings(ips); !reflect.DeepEqual(got, test.wantIPs) {
+				t.Errorf("getServiceHostnames() ips = %v, want %v", got, test.wantIPs)
+			}
+		})
+	}
+}
+
+func TestInNamespaceSubdomain(t *testing.T) {
+	hostnames := []hostname.LocalHostname{{Hostname: "grafana"}, {Hostname: "grafana", Instance: "logs (grafana)"}}
+	tests := []struct {
+		name      string
+		options   HostnameOptions
+		namespace string
+		want      []string
+	}{
+		{"unchanged", HostnameOptions{}, "monitoring", []string{"grafana", "grafana"}},
+		{"namespace subdomains", HostnameOptions{NamespaceSubdomains: true}, "monitoring", []string{"grafana.monitoring", "grafana.monitoring"}},
+		{"cluster scoped", HostnameOptions{NamespaceSubdomains: true}, "", []string{"grafana", "grafana"}},
+		{"mapped namespace", HostnameOptions{SubdomainOfNamespace: map[string]string{"monitoring": "mon"}}, "monitoring", []string{"grafana.mon", "grafana.mon"}},
+		{"namespace mapped to none", HostnameOptions{NamespaceSubdomains: true, SubdomainOfNamespace: map[string]string{"monitoring": ""}}, "monitoring", []string{"grafana", "grafana"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			moved := test.options.inNamespaceSubdomain(test.namespace, hostnames)
+			if got := hostnameStrings(moved); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("inNamespaceSubdomain() = %v, want %v", got, test.want)
+			}
+			if want := "logs (grafana)" + moved[0].Hostname[len("grafana"):]; moved[1].Instance != want {
+				t.Errorf("inNamespaceSubdomain() instance = %q, want %q", moved[1].Instance, want)
+			}
+		})
+	}
+}
+
+func TestWithAliases(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "grafana", Annotations: map[string]string{
+		annotationAliases: "dash.local, grafana.local,dash.example.com,,charts.local",
+	}}}
+	hostnames := []hostname.LocalHostname{{Hostname: "grafana", Instance: "Grafana"}, {Hostname: "grafana", Instance: "logs (grafana)"}}
+	aliased := HostnameOptions{}.withDefaults().withAliases("service", service, hostnames)
+	want := []hostname.LocalHostname{
+		{Hostname: "grafana", Instance: "Grafana"},
+		{Hostname: "grafana", Instance: "logs (grafana)"},
+		{Hostname: "dash"},
+		{Hostname: "dash", Instance: "logs (dash)"},
+		{Hostname: "charts"},
+		{Hostname: "charts", Instance: "logs (charts)"},
+	}
+	if !reflect.DeepEqual(aliased, want) {
+		t.Errorf("withAliases() = %+v, want %+v", aliased, want)
+	}
+}
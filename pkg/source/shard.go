@@ -0,0 +1,90 @@
+package source
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeShards Splits the hostnames between the ready nodes matching a selector, for DaemonSets
+// where every node would announce every hostname otherwise. Each hostname is owned by the node
+// with the highest hash of node and hostname, so that nodes joining or leaving only move the
+// hostnames they gain or lose
+type NodeShards struct {
+	mutex    sync.Mutex
+	nodeName string
+	// nodes The sorted names of the ready nodes
+	nodes    []string
+	lister   corelisters.NodeLister
+	registry *publisher.Registry
+}
+
+// NewNodeShards Watches the nodes matching selector, nodeName is the node of this replica
+func NewNodeShards(sources *Manager, nodeName string, selector string, registry *publisher.Registry) *NodeShards {
+	nodes := sources.selectedFactory("", selector, "").Core().V1().Nodes()
+	shards := &NodeShards{nodeName: nodeName, nodes: []string{}, lister: nodes.Lister(), registry: registry}
+	update := func(interface{}) { shards.update() }
+	sources.track("nodes", nodes.Informer(), cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(interface{}, interface{}) { shards.update() },
+		DeleteFunc: update,
+	})
+	registry.Owns = shards.owns
+	return shards
+}
+
+// update Re-shards the registry when the ready nodes changed
+func (s *NodeShards) update() {
+	nodes := []string{}
+	listed, _ := s.lister.List(labels.Everything())
+	for _, node := range listed {
+		if nodeReady(node) {
+			nodes = append(nodes, node.Name)
+		}
+	}
+	sort.Strings(nodes)
+	s.mutex.Lock()
+	changed := !reflect.DeepEqual(nodes, s.nodes)
+	s.nodes = nodes
+	s.mutex.Unlock()
+	if changed {
+		log.Infof("Sharding hostnames between nodes %v", nodes)
+		s.registry.Reshard()
+	}
+}
+
+func nodeReady(node *v1.Node) bool {
+	if node.DeletionTimestamp != nil || node.Spec.Unschedulable {
+		return false
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// owns Reports whether this node announces hostname, nothing is owned before it is ready
+func (s *NodeShards) owns(hostname string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	owner := ""
+	var highest uint64
+	for _, node := range s.nodes {
+		sum := sha1.Sum([]byte(node + "/" + hostname))
+		if weight := binary.BigEndian.Uint64(sum[:8]); owner == "" || weight > highest {
+			owner, highest = node, weight
+		}
+	}
+	return owner != "" && owner == s.nodeName
+}
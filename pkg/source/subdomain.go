@@ -0,0 +1,38 @@
+package source
+
+import (
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+)
+
+// namespaceSubdomain Returns the subdomain the hostnames of namespace are published under,
+// empty when they are published as they are
+func (o HostnameOptions) namespaceSubdomain(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	if subdomain, mapped := o.SubdomainOfNamespace[namespace]; mapped {
+		return subdomain
+	}
+	if o.NamespaceSubdomains {
+		return namespace
+	}
+	return ""
+}
+
+// inNamespaceSubdomain Returns hostnames moved under the subdomain of namespace, along with
+// their instance names
+func (o HostnameOptions) inNamespaceSubdomain(namespace string, hostnames []hostname.LocalHostname) []hostname.LocalHostname {
+	subdomain := o.namespaceSubdomain(namespace)
+	if subdomain == "" || len(hostnames) == 0 {
+		return hostnames
+	}
+	moved := make([]hostname.LocalHostname, 0, len(hostnames))
+	for _, local := range hostnames {
+		local.Hostname = local.Hostname + "." + subdomain
+		if local.Instance != "" {
+			local.Instance = local.Instance + "." + subdomain
+		}
+		moved = append(moved, local)
+	}
+	return moved
+}
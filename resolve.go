@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/hostname"
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	"github.com/spf13/cobra"
+)
+
+func newResolveCommand() *cobra.Command {
+	var interfaces interfaceOptions
+	var domain string
+	cmd := &cobra.Command{
+		Use:   "resolve HOSTNAME...",
+		Short: "Query hostnames over mDNS and print the addresses responders answer with",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, ifaces, err := interfaces.selection()
+			if err != nil {
+				return err
+			}
+			failed := false
+			for _, name := range args {
+				fqdn := strings.TrimSuffix(name, ".")
+				if !strings.Contains(fqdn, ".") {
+					fqdn += "." + strings.Trim(domain, ".")
+				}
+				ips := publisher.QueryHostname(ifaces, fqdn)
+				if len(ips) == 0 {
+					fmt.Fprintf(cmd.OutOrStdout(), "%v\tno answer\n", fqdn)
+					failed = true
+					continue
+				}
+				hostname.SortIPs(ips)
+				fmt.Fprintf(cmd.OutOrStdout(), "%v\t%v\n", fqdn, strings.Join(hostname.IPStrings(ips), ","))
+			}
+			if failed {
+				return fmt.Errorf("some hostnames were not answered")
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	interfaces.addFlags(flags)
+	flags.StringVar(&domain, "domain", "local", "Domain appended to hostnames without one")
+	return cmd
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mikeas1/ingress-frontend-zeroconf/pkg/publisher"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchdogTimeout How long one update may hold the registry before the watchdog is not pinged
+const watchdogTimeout = time.Second * 5
+
+// sdNotify Sends state to the service manager through $NOTIFY_SOCKET, the sd_notify protocol
+// of systemd. Outside of a unit without Type=notify there is no socket and nothing is sent
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		// Abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Debugf("Failed to connect to the notify socket: %+v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Debugf("Failed to notify %v: %+v", state, err)
+	}
+}
+
+// watchdogInterval Returns the interval of the watchdog of the unit, 0 when it has none
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notifySystemd Sends READY=1 once ready is closed and pings the watchdog of the unit at half
+// its interval while the registry keeps accepting updates, until ctx is done
+func notifySystemd(ctx context.Context, ready <-chan struct{}, registry *publisher.Registry) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-ready:
+	}
+	sdNotify("READY=1")
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := registry.CheckLive(watchdogTimeout); err != nil {
+			// systemd restarts the unit once the watchdog interval passed without a ping
+			log.Warnf("Not pinging the watchdog: %+v", err)
+			continue
+		}
+		sdNotify("WATCHDOG=1")
+	}
+}
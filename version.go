@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// Set with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	// version The semantic version of the release
+	version = "dev"
+	// commit The git commit built
+	commit = "unknown"
+	// buildDate When the binary was built, RFC 3339
+	buildDate = "unknown"
+)
+
+// libraryModules The modules whose versions identify how the broadcaster talks to the
+// cluster and on the network
+var libraryModules = []string{
+	"k8s.io/client-go",
+	"sigs.k8s.io/controller-runtime",
+	"github.com/miekg/dns",
+	"github.com/godbus/dbus/v5",
+}
+
+// buildInfo What identifies a build in bug reports
+type buildInfo struct {
+	Version   string            `json:"version"`
+	Commit    string            `json:"commit"`
+	BuildDate string            `json:"buildDate"`
+	GoVersion string            `json:"goVersion"`
+	Platform  string            `json:"platform"`
+	Libraries map[string]string `json:"libraries"`
+}
+
+// getBuildInfo Returns the info set with ldflags, and the versions of the libraries from
+// the module information embedded by the go tool
+func getBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Libraries: map[string]string{},
+	}
+	for _, module := range libraryModules {
+		info.Libraries[module] = "unknown"
+	}
+	if embedded, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range embedded.Deps {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			if _, listed := info.Libraries[dep.Path]; listed {
+				info.Libraries[dep.Path] = dep.Version
+			}
+		}
+	}
+	return info
+}
+
+// print Writes the info to out, one line per field
+func (i buildInfo) print(out io.Writer) {
+	fmt.Fprintf(out, "Version:    %v\n", i.Version)
+	fmt.Fprintf(out, "Commit:     %v\n", i.Commit)
+	fmt.Fprintf(out, "Build date: %v\n", i.BuildDate)
+	fmt.Fprintf(out, "Go:         %v %v\n", i.GoVersion, i.Platform)
+	for _, module := range libraryModules {
+		fmt.Fprintf(out, "%v %v\n", module, i.Libraries[module])
+	}
+}
+
+func newVersionCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version, commit, build date and library versions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info := getBuildInfo()
+			switch output {
+			case outputTable:
+				info.print(cmd.OutOrStdout())
+			case outputJSON:
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(info)
+			default:
+				return fmt.Errorf("unknown output format %v, expected %v or %v", output, outputTable, outputJSON)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable, "Print a table or json")
+	return cmd
+}